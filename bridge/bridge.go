@@ -0,0 +1,176 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+const (
+	// Headers added to dead-lettered messages
+	DeadLetterReasonHeader   = "DeadLetterReason"
+	DeadLetterAttemptsHeader = "DeadLetterAttempts"
+
+	defaultRetryBackoff = 100 * time.Millisecond
+	defaultMaxBackoff   = 10 * time.Second
+)
+
+// TransformFunc transforms a message before it's forwarded. Returning a nil message drops it,
+// returning an error sends the original message to the dead-letter sink without retrying
+type TransformFunc func(ctx context.Context, msg messaging.Message) (messaging.Message, error)
+
+// Options to configure a Bridge
+type Options struct {
+	// Transform is applied to every message before forwarding it
+	Transform TransformFunc
+	// MaxRetries is the number of additional attempts after a failed send
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled on every attempt up to MaxBackoff
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	// DeadLetter receives the messages that couldn't be transformed or delivered, when nil they're dropped
+	DeadLetter Sink
+}
+
+// Bridge consumes messages from a source and forwards them to a sink
+type Bridge struct {
+	source  Source
+	sink    Sink
+	options Options
+}
+
+// Create a new bridge between a source and a sink
+func New(source Source, sink Sink, options Options) (*Bridge, error) {
+	if source == nil {
+		return nil, errors.New("bridge source is nil")
+	}
+	if sink == nil {
+		return nil, errors.New("bridge sink is nil")
+	}
+	if options.MaxRetries < 0 {
+		return nil, errors.New("max retries can't be negative")
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = defaultRetryBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultMaxBackoff
+	}
+
+	return &Bridge{
+		source:  source,
+		sink:    sink,
+		options: options,
+	}, nil
+}
+
+// Run the bridge until the context is cancelled or the source is exhausted
+func (b *Bridge) Run(ctx context.Context) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+	xTelemetry.Info(ctx, "Bridge::Run::Starting")
+
+	err := b.source.Run(ctx, b.Forward)
+
+	xTelemetry.Info(ctx, "Bridge::Run::Stopped")
+	return err
+}
+
+// Forward a single message: transform, send with retries and dead-letter on failure.
+// The message operation ID is propagated in the context, a new one is generated if the message has none
+func (b *Bridge) Forward(ctx context.Context, msg messaging.Message) error {
+	startTime := time.Now()
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	operationID := msg.GetOperationID()
+	if operationID == "" {
+		operationID = uuid.New().String()
+		msg.SetOperationID(operationID)
+	}
+	ctx = telemetry.SetOperationID(ctx, operationID)
+
+	// Error messages (e.g. undecodable events) can't be forwarded
+	if msg.GetError() != nil {
+		xTelemetry.Error(ctx, "Bridge::Forward::Received error message", telemetry.String("Error", msg.GetError().Error()))
+		return b.deadLetter(ctx, msg, msg.GetError(), 0)
+	}
+
+	// Transform the message
+	out := msg
+	if b.options.Transform != nil {
+		transformed, err := b.options.Transform(ctx, msg)
+		if err != nil {
+			xTelemetry.Error(ctx, "Bridge::Forward::Failed to transform message", telemetry.String("Error", err.Error()))
+			return b.deadLetter(ctx, msg, err, 0)
+		}
+		if transformed == nil {
+			xTelemetry.Debug(ctx, "Bridge::Forward::Message dropped by transform", telemetry.String("Command", msg.GetCommand()))
+			return nil
+		}
+		out = transformed
+		if out.GetOperationID() == "" {
+			out.SetOperationID(operationID)
+		}
+	}
+
+	// Send with retries
+	backoff := b.options.RetryBackoff
+	var err error
+	attempts := 0
+	for attempts <= b.options.MaxRetries {
+		attempts++
+		err = b.sink.Send(ctx, out)
+		if err == nil {
+			xTelemetry.Dependency(ctx, "Bridge", out.GetCommand(), true, startTime, time.Now(), "Bridge::Forward::Message forwarded", telemetry.String("Command", out.GetCommand()))
+			return nil
+		}
+		xTelemetry.Warn(ctx, "Bridge::Forward::Failed to send message", telemetry.String("Error", err.Error()), telemetry.Int("Attempt", attempts))
+
+		if attempts > b.options.MaxRetries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, b.options.MaxBackoff)
+	}
+
+	xTelemetry.Dependency(ctx, "Bridge", out.GetCommand(), false, startTime, time.Now(), "Bridge::Forward::Failed to forward message", telemetry.String("Error", err.Error()))
+	return b.deadLetter(ctx, out, err, attempts)
+}
+
+// Send a message to the dead-letter sink, returning the original error when there's no sink or it fails too
+func (b *Bridge) deadLetter(ctx context.Context, msg messaging.Message, cause error, attempts int) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	if b.options.DeadLetter == nil {
+		return cause
+	}
+
+	// The error field doesn't survive serialization, so the dead-letter copy carries the reason as a header
+	deadLetterMsg := messaging.NewMessage(msg.GetOperationID(), nil, msg.GetStatus(), msg.GetCommand(), msg.GetData())
+	if carrier, ok := deadLetterMsg.(messaging.HeaderCarrier); ok {
+		if source, ok := msg.(messaging.HeaderCarrier); ok {
+			for key, value := range source.GetHeaders() {
+				carrier.SetHeader(key, value)
+			}
+		}
+		carrier.SetHeader(DeadLetterReasonHeader, cause.Error())
+		carrier.SetHeader(DeadLetterAttemptsHeader, strconv.Itoa(attempts))
+	}
+
+	err := b.options.DeadLetter.Send(ctx, deadLetterMsg)
+	if err != nil {
+		xTelemetry.Error(ctx, "Bridge::DeadLetter::Failed to dead-letter message", telemetry.String("Error", err.Error()), telemetry.String("Cause", cause.Error()))
+		return errors.Join(cause, err)
+	}
+	xTelemetry.Info(ctx, "Bridge::DeadLetter::Message dead-lettered", telemetry.String("Cause", cause.Error()), telemetry.String("Command", deadLetterMsg.GetCommand()))
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package bridge_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/bridge"
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goadapters/messaging/memory"
+	"github.com/perocha/goutils/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func initializeTelemetry() context.Context {
+	telemetryConfig := telemetry.NewXTelemetryConfig("", "bridge", "info", 1)
+	xTelemetry, err := telemetry.NewXTelemetry(telemetryConfig)
+	if err != nil {
+		log.Fatalf("Main::Fatal error::Failed to initialize XTelemetry %s\n", err.Error())
+	}
+	return context.WithValue(context.Background(), telemetry.TelemetryContextKey, xTelemetry)
+}
+
+func TestBridge_MessagingToMessaging(t *testing.T) {
+	ctx, cancel := context.WithCancel(initializeTelemetry())
+	defer cancel()
+
+	source := memory.NewMemoryAdapter("in")
+	destination := memory.NewMemoryAdapter("out")
+	output, stop, err := destination.Subscribe(ctx)
+	assert.NoError(t, err)
+	defer stop()
+
+	b, err := bridge.New(bridge.FromMessaging(source), bridge.MessagingSink(destination), bridge.Options{
+		Transform: func(ctx context.Context, msg messaging.Message) (messaging.Message, error) {
+			if msg.GetCommand() == "ignore" {
+				return nil, nil
+			}
+			return messaging.NewMessage("", nil, "forwarded", msg.GetCommand(), msg.GetData()), nil
+		},
+	})
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+
+	// Publish until the bridge has subscribed and forwards the message
+	var forwarded messaging.Message
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, source.Publish(ctx, messaging.NewMessage("op-1", nil, "", "create", []byte("data"))))
+		select {
+		case forwarded = <-output:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "op-1", forwarded.GetOperationID())
+	assert.Equal(t, "forwarded", forwarded.GetStatus())
+	assert.Equal(t, []byte("data"), forwarded.GetData())
+
+	// Messages dropped by the transform are not forwarded
+	assert.NoError(t, source.Publish(ctx, messaging.NewMessage("op-2", nil, "", "ignore", nil)))
+	assert.NoError(t, source.Publish(ctx, messaging.NewMessage("op-3", nil, "", "update", nil)))
+	for msg := range output {
+		if msg.GetOperationID() != "op-1" {
+			assert.Equal(t, "op-3", msg.GetOperationID())
+			break
+		}
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestBridge_RetriesAndDeadLetter(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	attempts := 0
+	flaky := bridge.SinkFunc(func(ctx context.Context, msg messaging.Message) error {
+		attempts++
+		return errors.New("downstream unavailable")
+	})
+
+	var deadLettered messaging.Message
+	deadLetter := bridge.SinkFunc(func(ctx context.Context, msg messaging.Message) error {
+		deadLettered = msg
+		return nil
+	})
+
+	b, err := bridge.New(bridge.FromMessaging(memory.NewMemoryAdapter("in")), flaky, bridge.Options{
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+		DeadLetter:   deadLetter,
+	})
+	assert.NoError(t, err)
+
+	err = b.Forward(ctx, messaging.NewMessage("", nil, "", "create", []byte("data")))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.NotNil(t, deadLettered)
+	assert.NotEmpty(t, deadLettered.GetOperationID())
+
+	headers := deadLettered.(messaging.HeaderCarrier)
+	assert.Equal(t, "downstream unavailable", headers.GetHeader(bridge.DeadLetterReasonHeader))
+	assert.Equal(t, "3", headers.GetHeader(bridge.DeadLetterAttemptsHeader))
+}
+
+func TestBridge_ReceiverToHTTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(initializeTelemetry())
+	defer cancel()
+
+	// Downstream webhook that checks the forwarded message
+	var received messaging.Message
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = messaging.NewMessage("", nil, "", "", nil)
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, received.Deserialize(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer webhook.Close()
+
+	httpSender, err := httpadapter.HttpSenderInit(ctx)
+	assert.NoError(t, err)
+	endpoint := httpadapter.NewEndpoint("localhost", strings.Split(webhook.URL, ":")[2], "/webhook")
+	sender := bridge.CommsSink(httpSender, endpoint)
+
+	receiver, err := httpadapter.HTTPServerAdapterInit(ctx, "8080")
+	assert.NoError(t, err)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	b, err := bridge.New(bridge.FromReceiver(receiver, "/events"), sender, bridge.Options{})
+	assert.NoError(t, err)
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+
+	// Post until the bridge has registered its endpoint
+	data, _ := messaging.NewMessage("op-7", nil, "", "create", []byte("data")).Serialize()
+	assert.Eventually(t, func() bool {
+		resp, err := http.Post(server.URL+"/events", "application/json", bytes.NewReader(data))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "op-7", received.GetOperationID())
+
+	// Messages without an OperationID continue the caller's trace
+	unset, _ := messaging.NewMessage("", nil, "", "create", []byte("data")).Serialize()
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/events", bytes.NewReader(unset))
+	req.Header.Set(comms.OperationIDHeader, "caller-op-1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "caller-op-1", received.GetOperationID())
+
+	// Invalid bodies are rejected
+	resp, err = http.Post(server.URL+"/events", "application/json", strings.NewReader("not json"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// The endpoint is unregistered when the bridge stops
+	cancel()
+	assert.NoError(t, <-done)
+	resp, err = http.Post(server.URL+"/events", "application/json", bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
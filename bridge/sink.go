@@ -0,0 +1,42 @@
+package bridge
+
+import (
+	"context"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/messaging"
+)
+
+// Sink is the destination messages are forwarded to
+type Sink interface {
+	Send(ctx context.Context, msg messaging.Message) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ctx context.Context, msg messaging.Message) error
+
+// Send the message
+func (f SinkFunc) Send(ctx context.Context, msg messaging.Message) error {
+	return f(ctx, msg)
+}
+
+// MessagingSink publishes messages to a messaging system
+func MessagingSink(messagingSystem messaging.MessagingSystem) Sink {
+	return SinkFunc(func(ctx context.Context, msg messaging.Message) error {
+		return messagingSystem.Publish(ctx, msg)
+	})
+}
+
+// TopicSink publishes messages to a topic of a topic-aware messaging system
+func TopicSink(messagingSystem messaging.TopicMessagingSystem, topic string) Sink {
+	return SinkFunc(func(ctx context.Context, msg messaging.Message) error {
+		return messagingSystem.PublishTo(ctx, topic, msg)
+	})
+}
+
+// CommsSink sends messages to an endpoint through a comms sender, e.g. an HTTP webhook
+func CommsSink(sender comms.CommsSender, endpoint comms.EndPoint) Sink {
+	return SinkFunc(func(ctx context.Context, msg messaging.Message) error {
+		return sender.SendRequest(ctx, endpoint, msg)
+	})
+}
@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// ForwardFunc forwards a single message, returning an error if it couldn't be delivered nor dead-lettered
+type ForwardFunc func(ctx context.Context, msg messaging.Message) error
+
+// Source produces the messages to be forwarded. Run blocks until the context is cancelled or the source is exhausted
+type Source interface {
+	Run(ctx context.Context, forward ForwardFunc) error
+}
+
+// Source consuming a messaging system subscription
+type messagingSource struct {
+	messagingSystem messaging.MessagingSystem
+}
+
+// FromMessaging consumes the messages of a messaging system. Subscribe filters set in the Run context apply
+func FromMessaging(messagingSystem messaging.MessagingSystem) Source {
+	return &messagingSource{messagingSystem: messagingSystem}
+}
+
+// Subscribe and forward every message until the context is cancelled or the channel closed
+func (s *messagingSource) Run(ctx context.Context, forward ForwardFunc) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	channel, cancel, err := s.messagingSystem.Subscribe(ctx)
+	if err != nil {
+		xTelemetry.Error(ctx, "Bridge::MessagingSource::Failed to subscribe", telemetry.String("Error", err.Error()))
+		return err
+	}
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-channel:
+			if !ok {
+				return nil
+			}
+			// Failures are logged and dead-lettered by the bridge, keep consuming
+			forward(ctx, msg)
+		}
+	}
+}
+
+// Source receiving messages posted to a comms receiver endpoint
+type receiverSource struct {
+	receiver     comms.CommsReceiver
+	endpointPath string
+}
+
+// FromReceiver registers an endpoint on the receiver and forwards the messages posted to it.
+// The caller replies with 200 once the message is forwarded, or 502 if it couldn't be delivered.
+// The receiver must be started separately
+func FromReceiver(receiver comms.CommsReceiver, endpointPath string) Source {
+	return &receiverSource{
+		receiver:     receiver,
+		endpointPath: endpointPath,
+	}
+}
+
+// Register the endpoint and wait until the context is cancelled, then unregister it
+func (s *receiverSource) Run(ctx context.Context, forward ForwardFunc) error {
+	err := s.receiver.RegisterEndPoint(ctx, s.endpointPath, func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		msg := messaging.NewMessage("", nil, "", "", nil)
+		err := msg.Deserialize(r.Body())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return ctx, err
+		}
+
+		// Continue the caller's trace, the receiver put its OperationID in the context
+		if msg.GetOperationID() == "" {
+			if operationID, _ := ctx.Value(telemetry.OperationIDKeyContextKey).(string); operationID != "" {
+				msg.SetOperationID(operationID)
+			}
+		}
+
+		err = forward(ctx, msg)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return ctx, err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	return s.receiver.UnregisterEndPoint(context.WithoutCancel(ctx), s.endpointPath)
+}
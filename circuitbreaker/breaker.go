@@ -0,0 +1,421 @@
+// Package circuitbreaker stops calling a failing dependency for a while, so callers fail fast instead of
+// waiting for timeouts. A Breaker is closed while calls succeed, opens when the failure rate or the slow call
+// rate of the recent calls crosses a threshold, and lets a few trial calls through (half-open) after the open
+// timeout to decide whether to close again.
+//
+// NewCommsSender and NewMessagingSystem wrap the comms and messaging interfaces with a breaker per endpoint or topic.
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// ErrOpen is matched by the OpenError returned while a breaker is open, errors.Is(err, ErrOpen)
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned instead of calling the dependency while the breaker is open
+type OpenError struct {
+	// Name of the breaker, e.g. the endpoint or topic
+	Name string
+	// RetryAfter is the time left until trial calls are allowed
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open, retry after %s", e.Name, e.RetryAfter)
+}
+
+// Is makes errors.Is(err, ErrOpen) true for an OpenError
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// State of a breaker
+type State int
+
+const (
+	// Closed lets every call through
+	Closed State = iota
+	// Open rejects every call with an OpenError
+	Open
+	// HalfOpen lets a few trial calls through
+	HalfOpen
+)
+
+// Get the state name
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	defaultWindowSize           = 20
+	defaultMinimumCalls         = 10
+	defaultFailureRateThreshold = 0.5
+	defaultOpenTimeout          = 30 * time.Second
+	defaultHalfOpenCalls        = 1
+
+	// Most breakers kept by a sender or messaging system, the least recently used one is dropped past it
+	maxBreakers = 1000
+)
+
+// Options to configure a breaker
+type Options struct {
+	// WindowSize is the number of recent calls the rates are computed on, defaults to 20
+	WindowSize int
+	// MinimumCalls is the number of calls needed in the window before the breaker can open, defaults to 10
+	MinimumCalls int
+	// FailureRateThreshold opens the breaker when the failure rate reaches it, between 0 and 1. Defaults to 0.5
+	FailureRateThreshold float64
+	// SlowCallDuration is the duration from which a call is slow, zero disables slow call tracking
+	SlowCallDuration time.Duration
+	// SlowCallRateThreshold opens the breaker when the slow call rate reaches it, between 0 and 1. Defaults to 1
+	SlowCallRateThreshold float64
+	// OpenTimeout is how long the breaker stays open before allowing trial calls, defaults to 30 seconds
+	OpenTimeout time.Duration
+	// HalfOpenCalls is the number of successful trial calls needed to close the breaker, defaults to 1
+	HalfOpenCalls int
+	// IsFailure decides if an error counts as a failure. By default every error does, except cancellations
+	// and errors that aren't retryable (e.g. a 4xx status, see httpadapter.StatusError)
+	IsFailure func(err error) bool
+	// EndpointKey names the breaker of an endpoint in NewCommsSender, endpoints with the same name share a breaker.
+	// Defaults to the scheme and host of the endpoint URL. The name is logged and returned in OpenError,
+	// so it must not hold credentials
+	EndpointKey func(endpoint comms.EndPoint) string
+	// OnStateChange is called on every state change, after the telemetry is logged.
+	// It's called with the breaker locked, so it must not call the breaker
+	OnStateChange func(name string, from State, to State)
+}
+
+// Validate the options and fill in the defaults
+func (o Options) withDefaults() (Options, error) {
+	if o.WindowSize < 0 || o.MinimumCalls < 0 || o.HalfOpenCalls < 0 {
+		return o, errors.New("window size, minimum calls and half-open calls can't be negative")
+	}
+	if o.FailureRateThreshold < 0 || o.FailureRateThreshold > 1 || o.SlowCallRateThreshold < 0 || o.SlowCallRateThreshold > 1 {
+		return o, errors.New("rate thresholds must be between 0 and 1")
+	}
+	if o.SlowCallDuration < 0 || o.OpenTimeout < 0 {
+		return o, errors.New("durations can't be negative")
+	}
+
+	if o.WindowSize == 0 {
+		o.WindowSize = defaultWindowSize
+	}
+	if o.MinimumCalls == 0 {
+		o.MinimumCalls = defaultMinimumCalls
+	}
+	if o.MinimumCalls > o.WindowSize {
+		return o, errors.New("minimum calls can't exceed the window size: " + strconv.Itoa(o.WindowSize))
+	}
+	if o.FailureRateThreshold == 0 {
+		o.FailureRateThreshold = defaultFailureRateThreshold
+	}
+	if o.SlowCallRateThreshold == 0 {
+		o.SlowCallRateThreshold = 1
+	}
+	if o.OpenTimeout == 0 {
+		o.OpenTimeout = defaultOpenTimeout
+	}
+	if o.HalfOpenCalls == 0 {
+		o.HalfOpenCalls = defaultHalfOpenCalls
+	}
+	if o.IsFailure == nil {
+		o.IsFailure = isFailure
+	}
+	if o.EndpointKey == nil {
+		o.EndpointKey = endpointKey
+	}
+
+	return o, nil
+}
+
+// Breaker tracks the outcome of the calls to a dependency
+type Breaker struct {
+	name    string
+	options Options
+
+	mu    sync.Mutex
+	state State
+	// Incremented on every state change, outcomes of calls started in a previous state are ignored
+	generation uint64
+	openedAt   time.Time
+
+	// Ring of the recent outcomes in the closed state
+	outcomes []outcome
+	next     int
+	calls    int
+	failures int
+	slow     int
+
+	// Trial calls in the half-open state
+	trialsStarted   int
+	trialsSucceeded int
+}
+
+// outcome of a call
+type outcome struct {
+	failure bool
+	slow    bool
+}
+
+// Create a breaker
+func New(name string, options Options) (*Breaker, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Breaker{
+		name:     name,
+		options:  options,
+		outcomes: make([]outcome, options.WindowSize),
+	}, nil
+}
+
+// Get the name
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Get the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.options.OpenTimeout {
+		return HalfOpen
+	}
+
+	return b.state
+}
+
+// Execute the call if the breaker allows it, recording its outcome. Returns an OpenError without calling while open
+func (b *Breaker) Execute(ctx context.Context, call func(ctx context.Context) error) error {
+	done, err := b.Allow(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = call(ctx)
+	done(err)
+
+	return err
+}
+
+// Check if a call is allowed. If it is, done must be called with the call's error once it completes
+func (b *Breaker) Allow(ctx context.Context) (func(err error), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == Open {
+		if remaining := b.options.OpenTimeout - now.Sub(b.openedAt); remaining > 0 {
+			return nil, &OpenError{Name: b.name, RetryAfter: remaining}
+		}
+		b.setState(ctx, HalfOpen)
+	}
+
+	if b.state == HalfOpen {
+		if b.trialsStarted >= b.options.HalfOpenCalls {
+			return nil, &OpenError{Name: b.name}
+		}
+		b.trialsStarted++
+	}
+
+	generation := b.generation
+	var once sync.Once
+	done := func(err error) {
+		once.Do(func() {
+			b.record(ctx, generation, err, time.Since(now))
+		})
+	}
+
+	return done, nil
+}
+
+// Record the outcome of a call
+func (b *Breaker) record(ctx context.Context, generation uint64, err error, duration time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	result := outcome{
+		failure: err != nil && b.options.IsFailure(err),
+		slow:    b.options.SlowCallDuration > 0 && duration >= b.options.SlowCallDuration,
+	}
+
+	// A cancelled call tells nothing about the service, unless IsFailure counts it: it isn't recorded,
+	// and a half-open trial gives its slot back
+	if !result.failure && errors.Is(err, context.Canceled) {
+		if b.state == HalfOpen {
+			b.trialsStarted--
+		}
+		return
+	}
+
+	switch b.state {
+	case HalfOpen:
+		if result.failure || result.slow {
+			b.setState(ctx, Open)
+			return
+		}
+		b.trialsSucceeded++
+		if b.trialsSucceeded >= b.options.HalfOpenCalls {
+			b.setState(ctx, Closed)
+		}
+
+	case Closed:
+		// Replace the oldest outcome of the window
+		if b.calls == len(b.outcomes) {
+			oldest := b.outcomes[b.next]
+			if oldest.failure {
+				b.failures--
+			}
+			if oldest.slow {
+				b.slow--
+			}
+		} else {
+			b.calls++
+		}
+		b.outcomes[b.next] = result
+		b.next = (b.next + 1) % len(b.outcomes)
+		if result.failure {
+			b.failures++
+		}
+		if result.slow {
+			b.slow++
+		}
+
+		if b.calls >= b.options.MinimumCalls {
+			failureRate := float64(b.failures) / float64(b.calls)
+			slowRate := float64(b.slow) / float64(b.calls)
+			if failureRate >= b.options.FailureRateThreshold || (b.options.SlowCallDuration > 0 && slowRate >= b.options.SlowCallRateThreshold) {
+				b.setState(ctx, Open)
+			}
+		}
+	}
+}
+
+// Change the state, resetting the counters and logging the change. Must be called with the lock held
+func (b *Breaker) setState(ctx context.Context, state State) {
+	from := b.state
+	failureRate := 0.0
+	if b.calls > 0 {
+		failureRate = float64(b.failures) / float64(b.calls)
+	}
+
+	b.state = state
+	b.generation++
+	b.trialsStarted = 0
+	b.trialsSucceeded = 0
+	if state == Open {
+		b.openedAt = time.Now()
+	}
+	if state == Closed {
+		b.calls, b.failures, b.slow, b.next = 0, 0, 0, 0
+	}
+
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+	fields := []telemetry.XField{
+		telemetry.String("Name", b.name),
+		telemetry.String("From", from.String()),
+		telemetry.String("To", state.String()),
+		telemetry.String("FailureRate", strconv.FormatFloat(failureRate, 'f', 2, 64)),
+	}
+	if state == Open {
+		xTelemetry.Warn(ctx, "CircuitBreaker::StateChange::Circuit opened", fields...)
+	} else {
+		xTelemetry.Info(ctx, "CircuitBreaker::StateChange", fields...)
+	}
+
+	if b.options.OnStateChange != nil {
+		b.options.OnStateChange(b.name, from, state)
+	}
+}
+
+// Every error is a failure, except cancellations and errors that report they aren't retryable
+func isFailure(err error) bool {
+	return comms.IsRetryable(err) && !errors.Is(err, context.Canceled)
+}
+
+// breakers holds a breaker per name, created on first use. Past maxBreakers, the least recently used is dropped
+type breakers struct {
+	options Options
+	mu      sync.Mutex
+	byName  map[string]*namedBreaker
+	// Incremented on every get, to order the breakers by last use
+	uses uint64
+}
+
+// namedBreaker is a breaker of the set with its last use
+type namedBreaker struct {
+	breaker  *Breaker
+	lastUsed uint64
+}
+
+// Create a breaker set, validating the options
+func newBreakers(options Options) (*breakers, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	return &breakers{
+		options: options,
+		byName:  make(map[string]*namedBreaker),
+	}, nil
+}
+
+// Get the breaker of the name
+func (s *breakers) get(name string) *Breaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.byName[name]
+	if !ok {
+		if len(s.byName) >= maxBreakers {
+			s.evict()
+		}
+		// The options were validated when the set was created
+		breaker, _ := New(name, s.options)
+		entry = &namedBreaker{breaker: breaker}
+		s.byName[name] = entry
+	}
+	s.uses++
+	entry.lastUsed = s.uses
+
+	return entry.breaker
+}
+
+// Drop the least recently used breaker, must be called with the lock held
+func (s *breakers) evict() {
+	var oldest *namedBreaker
+	for _, entry := range s.byName {
+		if oldest == nil || entry.lastUsed < oldest.lastUsed {
+			oldest = entry
+		}
+	}
+	if oldest != nil {
+		delete(s.byName, oldest.breaker.Name())
+	}
+}
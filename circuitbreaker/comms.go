@@ -0,0 +1,83 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/messaging"
+)
+
+// CommsSender guards the inner sender with a breaker per endpoint
+type CommsSender struct {
+	inner    comms.CommsSender
+	breakers *breakers
+}
+
+// Wrap a comms sender with a breaker per endpoint, named by Options.EndpointKey (the endpoint's scheme and host by default)
+func NewCommsSender(inner comms.CommsSender, options Options) (*CommsSender, error) {
+	if inner == nil {
+		return nil, errors.New("inner sender is nil")
+	}
+
+	breakers, err := newBreakers(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommsSender{
+		inner:    inner,
+		breakers: breakers,
+	}, nil
+}
+
+// Send the request through the endpoint's breaker, failing with an OpenError while it's open
+func (c *CommsSender) SendRequest(ctx context.Context, endpoint comms.EndPoint, data messaging.Message) error {
+	return c.Breaker(endpoint).Execute(ctx, func(ctx context.Context) error {
+		return c.inner.SendRequest(ctx, endpoint, data)
+	})
+}
+
+// Call the endpoint through its breaker, if the inner sender supports request/response calls.
+// Server error statuses (5xx) count as failures, the response is still returned
+func (c *CommsSender) Call(ctx context.Context, method string, endpoint comms.EndPoint, data messaging.Message) (comms.Response, error) {
+	caller, ok := c.inner.(comms.RequestResponseSender)
+	if !ok {
+		return nil, errors.New("inner sender doesn't support request/response calls")
+	}
+
+	done, err := c.Breaker(endpoint).Allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := caller.Call(ctx, method, endpoint, data)
+	if err == nil && resp.StatusCode() >= 500 {
+		done(errors.New("server returned status " + strconv.Itoa(resp.StatusCode())))
+	} else {
+		done(err)
+	}
+
+	return resp, err
+}
+
+// Get the breaker of the endpoint
+func (c *CommsSender) Breaker(endpoint comms.EndPoint) *Breaker {
+	return c.breakers.get(c.breakers.options.EndpointKey(endpoint))
+}
+
+// Name the breaker of an endpoint by the scheme and host of its URL, leaving out credentials, path and query
+func endpointKey(endpoint comms.EndPoint) string {
+	if endpoint == nil {
+		return ""
+	}
+
+	resolved, err := comms.ResolveURL(endpoint)
+	if err != nil {
+		// Not a URL, e.g. the name of a balanced endpoint
+		return endpoint.GetEndPoint()
+	}
+
+	return resolved.Scheme + "://" + resolved.Host
+}
@@ -0,0 +1,91 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/perocha/goadapters/messaging"
+)
+
+// Name of the breaker guarding Publish, topics published with PublishTo have their own breaker
+const DefaultTopic = ""
+
+// MessagingSystem guards publishing to the inner messaging system with a breaker. Subscribing isn't guarded
+type MessagingSystem struct {
+	inner    messaging.MessagingSystem
+	breakers *breakers
+}
+
+// Wrap a messaging system so that publishing fails fast while the system is failing
+func NewMessagingSystem(inner messaging.MessagingSystem, options Options) (*MessagingSystem, error) {
+	if inner == nil {
+		return nil, errors.New("inner messaging system is nil")
+	}
+
+	breakers, err := newBreakers(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MessagingSystem{
+		inner:    inner,
+		breakers: breakers,
+	}, nil
+}
+
+// Publish through the breaker, failing with an OpenError while it's open
+func (m *MessagingSystem) Publish(ctx context.Context, data messaging.Message) error {
+	return m.Breaker(DefaultTopic).Execute(ctx, func(ctx context.Context) error {
+		return m.inner.Publish(ctx, data)
+	})
+}
+
+// Subscribe to the inner messaging system
+func (m *MessagingSystem) Subscribe(ctx context.Context) (<-chan messaging.Message, context.CancelFunc, error) {
+	return m.inner.Subscribe(ctx)
+}
+
+// Close the inner messaging system
+func (m *MessagingSystem) Close(ctx context.Context) error {
+	return m.inner.Close(ctx)
+}
+
+// Get the breaker of a topic, DefaultTopic for Publish
+func (m *MessagingSystem) Breaker(topic string) *Breaker {
+	return m.breakers.get(topic)
+}
+
+// TopicMessagingSystem guards publishing to every topic of the inner messaging system with its own breaker
+type TopicMessagingSystem struct {
+	*MessagingSystem
+	inner messaging.TopicMessagingSystem
+}
+
+// Wrap a topic-aware messaging system with a breaker per topic
+func NewTopicMessagingSystem(inner messaging.TopicMessagingSystem, options Options) (*TopicMessagingSystem, error) {
+	if inner == nil {
+		return nil, errors.New("inner messaging system is nil")
+	}
+
+	system, err := NewMessagingSystem(inner, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TopicMessagingSystem{
+		MessagingSystem: system,
+		inner:           inner,
+	}, nil
+}
+
+// Publish to the topic through its breaker, failing with an OpenError while it's open
+func (m *TopicMessagingSystem) PublishTo(ctx context.Context, topic string, data messaging.Message) error {
+	return m.Breaker(topic).Execute(ctx, func(ctx context.Context) error {
+		return m.inner.PublishTo(ctx, topic, data)
+	})
+}
+
+// Subscribe to the topics of the inner messaging system
+func (m *TopicMessagingSystem) SubscribeTo(ctx context.Context, topics ...string) (<-chan messaging.Message, context.CancelFunc, error) {
+	return m.inner.SubscribeTo(ctx, topics...)
+}
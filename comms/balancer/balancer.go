@@ -0,0 +1,390 @@
+// Package balancer spreads requests over the replicas of a service. A Group resolves the replicas with a
+// Resolver, picks one per request with a Strategy and ejects replicas that keep failing. Groups implement
+// comms.BalancedEndPoint, so they can be passed to any sender instead of a single endpoint:
+//
+//	group, err := balancer.NewGroup(ctx, balancer.NewFileResolver("/etc/orders/replicas"), balancer.Options{Strategy: balancer.LeastInFlight})
+//	err = sender.SendRequest(ctx, group.EndPoint("/orders"), msg)
+package balancer
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// ErrNoHosts is returned when the resolver found no hosts
+var ErrNoHosts = errors.New("no hosts available")
+
+// Strategy to pick the host of a request
+type Strategy int
+
+const (
+	// RoundRobin picks the hosts in turn
+	RoundRobin Strategy = iota
+	// LeastInFlight picks the host with the fewest requests in progress
+	LeastInFlight
+	// ConsistentHash picks the same host for the same key (see WithHashKey), keys move to other hosts only
+	// when their host is removed or ejected. Requests without a key are spread in turn
+	ConsistentHash
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultEjectionTime     = 30 * time.Second
+	defaultRefreshInterval  = 30 * time.Second
+
+	// Points of every host on the hash ring
+	virtualNodes = 100
+)
+
+// Options to configure a Group
+type Options struct {
+	// Strategy to pick the host of a request, defaults to RoundRobin
+	Strategy Strategy
+	// FailureThreshold is the number of consecutive failures that ejects a host, defaults to 3
+	FailureThreshold int
+	// EjectionTime is how long an ejected host is skipped, defaults to 30 seconds
+	EjectionTime time.Duration
+	// RefreshInterval is how often the resolver is called again, defaults to 30 seconds. Negative disables refreshing
+	RefreshInterval time.Duration
+}
+
+// Group balances requests over the hosts found by a resolver
+type Group struct {
+	resolver Resolver
+	options  Options
+
+	mu    sync.Mutex
+	hosts []*host
+	ring  []ringPoint
+	next  uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// host is a replica with its health
+type host struct {
+	url          *url.URL
+	inFlight     int
+	failures     int
+	ejectedUntil time.Time
+}
+
+// ringPoint is a point of a host on the hash ring
+type ringPoint struct {
+	hash uint32
+	host *host
+}
+
+// Key of the hash key in the context
+type hashKeyContextKey struct{}
+
+// Set the key used by the ConsistentHash strategy, e.g. a tenant or an order ID
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyContextKey{}, key)
+}
+
+// Create a group, resolving the hosts a first time. The hosts are refreshed in the background until Close is called
+func NewGroup(ctx context.Context, resolver Resolver, options Options) (*Group, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	if resolver == nil {
+		return nil, errors.New("resolver is nil")
+	}
+	if options.Strategy < RoundRobin || options.Strategy > ConsistentHash {
+		return nil, errors.New("unknown strategy: " + strconv.Itoa(int(options.Strategy)))
+	}
+	if options.FailureThreshold < 0 {
+		return nil, errors.New("failure threshold can't be negative")
+	}
+	if options.FailureThreshold == 0 {
+		options.FailureThreshold = defaultFailureThreshold
+	}
+	if options.EjectionTime <= 0 {
+		options.EjectionTime = defaultEjectionTime
+	}
+	if options.RefreshInterval == 0 {
+		options.RefreshInterval = defaultRefreshInterval
+	}
+
+	g := &Group{
+		resolver: resolver,
+		options:  options,
+		done:     make(chan struct{}),
+	}
+	if err := g.Refresh(ctx); err != nil {
+		xTelemetry.Error(ctx, "Balancer::NewGroup::Failed to resolve hosts", telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+
+	refreshCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	g.cancel = cancel
+	if options.RefreshInterval > 0 {
+		go g.refreshLoop(refreshCtx)
+	} else {
+		close(g.done)
+	}
+
+	return g, nil
+}
+
+// Resolve the hosts again. Hosts that are still resolved keep their health and requests in progress
+func (g *Group) Refresh(ctx context.Context) error {
+	urls, err := g.resolver.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		return ErrNoHosts
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	existing := make(map[string]*host, len(g.hosts))
+	for _, h := range g.hosts {
+		existing[h.url.String()] = h
+	}
+
+	hosts := make([]*host, 0, len(urls))
+	seen := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		key := u.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if h, ok := existing[key]; ok {
+			hosts = append(hosts, h)
+		} else {
+			hosts = append(hosts, &host{url: u})
+		}
+	}
+
+	ring := make([]ringPoint, 0, len(hosts)*virtualNodes)
+	for _, h := range hosts {
+		for i := 0; i < virtualNodes; i++ {
+			ring = append(ring, ringPoint{hash: crc32.ChecksumIEEE([]byte(h.url.String() + "#" + strconv.Itoa(i))), host: h})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	g.hosts = hosts
+	g.ring = ring
+
+	return nil
+}
+
+// Refresh the hosts periodically, keeping the previous hosts when resolving fails
+func (g *Group) refreshLoop(ctx context.Context) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+	defer close(g.done)
+
+	ticker := time.NewTicker(g.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.Refresh(ctx); err != nil {
+				xTelemetry.Error(ctx, "Balancer::Refresh::Failed to resolve hosts", telemetry.String("Error", err.Error()))
+			}
+		}
+	}
+}
+
+// Stop refreshing the hosts
+func (g *Group) Close() error {
+	g.cancel()
+	<-g.done
+	return nil
+}
+
+// Get the hosts currently resolved
+func (g *Group) Hosts() []*url.URL {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	urls := make([]*url.URL, len(g.hosts))
+	for i, h := range g.hosts {
+		urls[i] = cloneURL(h.url)
+	}
+
+	return urls
+}
+
+// Get an endpoint of the group, the path is appended to the path of the picked host
+func (g *Group) EndPoint(path string) *EndPoint {
+	return &EndPoint{
+		group: g,
+		path:  path,
+	}
+}
+
+// Pick a host for a request
+func (g *Group) pick(ctx context.Context) (*host, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.hosts) == 0 {
+		return nil, ErrNoHosts
+	}
+
+	// Skip the ejected hosts, unless every host is ejected
+	now := time.Now()
+	healthy := make([]*host, 0, len(g.hosts))
+	for _, h := range g.hosts {
+		if !now.Before(h.ejectedUntil) {
+			healthy = append(healthy, h)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = g.hosts
+	}
+
+	var picked *host
+	switch g.options.Strategy {
+	case LeastInFlight:
+		// Start from the next host in turn, so that ties are spread
+		start := int(g.next % uint64(len(healthy)))
+		g.next++
+		for i := 0; i < len(healthy); i++ {
+			h := healthy[(start+i)%len(healthy)]
+			if picked == nil || h.inFlight < picked.inFlight {
+				picked = h
+			}
+		}
+
+	case ConsistentHash:
+		if key, _ := ctx.Value(hashKeyContextKey{}).(string); key != "" {
+			picked = g.lookupRing(key, healthy)
+		}
+	}
+	if picked == nil {
+		picked = healthy[g.next%uint64(len(healthy))]
+		g.next++
+	}
+
+	picked.inFlight++
+	return picked, nil
+}
+
+// Find the first healthy host on the ring after the key
+func (g *Group) lookupRing(key string, healthy []*host) *host {
+	allowed := make(map[*host]bool, len(healthy))
+	for _, h := range healthy {
+		allowed[h] = true
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(g.ring), func(i int) bool { return g.ring[i].hash >= hash })
+	for i := 0; i < len(g.ring); i++ {
+		point := g.ring[(start+i)%len(g.ring)]
+		if allowed[point.host] {
+			return point.host
+		}
+	}
+
+	return nil
+}
+
+// Record the outcome of a request. Hosts are ejected after FailureThreshold consecutive failures
+func (g *Group) release(ctx context.Context, h *host, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	h.inFlight--
+	if !isFailure(err) {
+		if err == nil {
+			h.failures = 0
+		}
+		return
+	}
+
+	h.failures++
+	if h.failures >= g.options.FailureThreshold {
+		h.failures = 0
+		h.ejectedUntil = time.Now().Add(g.options.EjectionTime)
+
+		xTelemetry := telemetry.GetXTelemetryClient(ctx)
+		xTelemetry.Warn(ctx, "Balancer::Release::Host ejected", telemetry.String("Host", h.url.Redacted()), telemetry.String("EjectionTime", g.options.EjectionTime.String()), telemetry.String("Error", err.Error()))
+	}
+}
+
+// Check if the error counts against the health of the host. Cancelled requests and errors that
+// can't be fixed by retrying (e.g. a 4xx status, see httpadapter.StatusError) don't
+func isFailure(err error) bool {
+	return comms.IsRetryable(err) && !errors.Is(err, context.Canceled)
+}
+
+// EndPoint is a path on the hosts of a group, it implements comms.BalancedEndPoint
+type EndPoint struct {
+	group *Group
+	path  string
+}
+
+// Get a description of the endpoint, the path on the first host
+func (e *EndPoint) GetEndPoint() string {
+	hosts := e.group.Hosts()
+	if len(hosts) == 0 {
+		return e.path
+	}
+
+	return e.target(hosts[0]).String()
+}
+
+// Set the path of the endpoint, the hosts come from the group's resolver
+func (e *EndPoint) SetEndPoint(endPoint string) {
+	e.path = endPoint
+}
+
+// Pick the URL of a request, done must be called with the request's error
+func (e *EndPoint) Pick(ctx context.Context) (*url.URL, func(err error), error) {
+	h, err := e.group.pick(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	done := func(err error) {
+		once.Do(func() {
+			e.group.release(ctx, h, err)
+		})
+	}
+
+	return e.target(h.url), done, nil
+}
+
+// Append the endpoint's path to the host URL
+func (e *EndPoint) target(hostURL *url.URL) *url.URL {
+	target := cloneURL(hostURL)
+	if e.path != "" {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(e.path, "/")
+	}
+
+	return target
+}
+
+// Copy a URL
+func cloneURL(u *url.URL) *url.URL {
+	clone := *u
+	if u.User != nil {
+		user := *u.User
+		clone.User = &user
+	}
+
+	return &clone
+}
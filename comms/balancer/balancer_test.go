@@ -0,0 +1,319 @@
+package balancer_test
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/balancer"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initializeTelemetry() context.Context {
+	// Initialize telemetry package
+	serviceName := "balancer"
+	telemetryConfig := telemetry.NewXTelemetryConfig("", serviceName, "info", 1)
+	xTelemetry, err := telemetry.NewXTelemetry(telemetryConfig)
+	if err != nil {
+		log.Fatalf("Main::Fatal error::Failed to initialize XTelemetry %s\n", err.Error())
+	}
+	// Add telemetry object to the context, so that it can be reused across the application
+	ctx := context.WithValue(context.Background(), telemetry.TelemetryContextKey, xTelemetry)
+	return ctx
+}
+
+// replica is a test server counting its requests
+type replica struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	hits   int
+	paths  []string
+	status int
+}
+
+func newReplica(t *testing.T) *replica {
+	r := &replica{status: http.StatusOK}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.hits++
+		r.paths = append(r.paths, req.URL.Path)
+		status := r.status
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *replica) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hits
+}
+
+func (r *replica) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+// Create a group over static hosts, closed when the test ends
+func newGroup(t *testing.T, ctx context.Context, options balancer.Options, rawURLs ...string) *balancer.Group {
+	resolver, err := balancer.NewStaticResolver(rawURLs...)
+	require.NoError(t, err)
+	group, err := balancer.NewGroup(ctx, resolver, options)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = group.Close() })
+	return group
+}
+
+func TestGroup_RoundRobinWithSender(t *testing.T) {
+	ctx := initializeTelemetry()
+	replicas := []*replica{newReplica(t), newReplica(t), newReplica(t)}
+	group := newGroup(t, ctx, balancer.Options{}, replicas[0].server.URL, replicas[1].server.URL, replicas[2].server.URL)
+
+	sender, err := httpadapter.HttpSenderInit(ctx)
+	require.NoError(t, err)
+	endpoint := group.EndPoint("/orders")
+	assert.Implements(t, (*comms.BalancedEndPoint)(nil), endpoint)
+
+	for i := 0; i < 9; i++ {
+		assert.NoError(t, sender.SendRequest(ctx, endpoint, messaging.NewMessage("", nil, "pending", "create", nil)))
+	}
+	for _, r := range replicas {
+		assert.Equal(t, 3, r.count())
+		assert.Equal(t, "/orders", r.paths[0])
+	}
+}
+
+func TestGroup_EjectsFailingHosts(t *testing.T) {
+	ctx := initializeTelemetry()
+	healthy, failing := newReplica(t), newReplica(t)
+	failing.setStatus(http.StatusServiceUnavailable)
+	group := newGroup(t, ctx, balancer.Options{FailureThreshold: 2, EjectionTime: time.Hour}, healthy.server.URL, failing.server.URL)
+
+	sender, err := httpadapter.HttpSenderInit(ctx)
+	require.NoError(t, err)
+	endpoint := group.EndPoint("/orders")
+
+	failures := 0
+	for i := 0; i < 20; i++ {
+		if sender.SendRequest(ctx, endpoint, messaging.NewMessage("", nil, "pending", "create", nil)) != nil {
+			failures++
+		}
+	}
+
+	// The failing host is ejected after two consecutive failures
+	assert.Equal(t, 2, failing.count())
+	assert.Equal(t, 2, failures)
+	assert.Equal(t, 18, healthy.count())
+}
+
+func TestGroup_ClientErrorsDontEject(t *testing.T) {
+	ctx := initializeTelemetry()
+	group := newGroup(t, ctx, balancer.Options{FailureThreshold: 1, EjectionTime: time.Hour}, "http://a:80", "http://b:80")
+	endpoint := group.EndPoint("")
+
+	// A 4xx status is the caller's fault, the host stays in rotation
+	target, done, err := endpoint.Pick(ctx)
+	require.NoError(t, err)
+	done(&httpadapter.StatusError{StatusCode: httpadapter.StatusNotFound})
+	done(errors.New("reported twice, ignored"))
+
+	picked := map[string]int{}
+	for i := 0; i < 4; i++ {
+		next, done, err := endpoint.Pick(ctx)
+		require.NoError(t, err)
+		picked[next.Host]++
+		done(nil)
+	}
+	assert.Equal(t, 2, picked[target.Host])
+}
+
+func TestGroup_AllEjectedFallsBackToAll(t *testing.T) {
+	ctx := initializeTelemetry()
+	group := newGroup(t, ctx, balancer.Options{FailureThreshold: 1, EjectionTime: time.Hour}, "http://a:80", "http://b:80")
+	endpoint := group.EndPoint("")
+
+	for i := 0; i < 2; i++ {
+		_, done, err := endpoint.Pick(ctx)
+		require.NoError(t, err)
+		done(errors.New("connection refused"))
+	}
+
+	// Every host is ejected, requests are still spread over all of them
+	_, done, err := endpoint.Pick(ctx)
+	assert.NoError(t, err)
+	done(nil)
+}
+
+func TestGroup_LeastInFlight(t *testing.T) {
+	ctx := initializeTelemetry()
+	group := newGroup(t, ctx, balancer.Options{Strategy: balancer.LeastInFlight}, "http://a:80", "http://b:80", "http://c:80")
+	endpoint := group.EndPoint("/orders")
+
+	// Requests in progress spread over every host
+	dones := map[string]func(error){}
+	for i := 0; i < 3; i++ {
+		target, done, err := endpoint.Pick(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "/orders", target.Path)
+		dones[target.Host] = done
+	}
+	assert.Len(t, dones, 3)
+
+	// The host that completed its request is picked next
+	dones["b:80"](nil)
+	target, done, err := endpoint.Pick(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "b:80", target.Host)
+	done(nil)
+}
+
+func TestGroup_ConsistentHash(t *testing.T) {
+	ctx := initializeTelemetry()
+	group := newGroup(t, ctx, balancer.Options{Strategy: balancer.ConsistentHash, FailureThreshold: 1, EjectionTime: time.Hour}, "http://a:80", "http://b:80", "http://c:80")
+	endpoint := group.EndPoint("")
+
+	pick := func(key string) string {
+		target, done, err := endpoint.Pick(balancer.WithHashKey(ctx, key))
+		require.NoError(t, err)
+		done(nil)
+		return target.Host
+	}
+
+	// The same key always goes to the same host, and keys spread over the hosts
+	hosts := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		key := "tenant-" + strconv.Itoa(i)
+		first := pick(key)
+		assert.Equal(t, first, pick(key))
+		hosts[first] = true
+	}
+	assert.Len(t, hosts, 3)
+
+	// When the host of a key is ejected, the key moves to another host
+	host := pick("tenant-1")
+	target, done, err := endpoint.Pick(balancer.WithHashKey(ctx, "tenant-1"))
+	require.NoError(t, err)
+	assert.Equal(t, host, target.Host)
+	done(errors.New("connection refused"))
+	assert.NotEqual(t, host, pick("tenant-1"))
+}
+
+func TestFileResolver(t *testing.T) {
+	ctx := initializeTelemetry()
+	file := filepath.Join(t.TempDir(), "replicas")
+	require.NoError(t, os.WriteFile(file, []byte("# orders replicas\nhttp://a:80\n\nhttp://b:80\n"), 0o600))
+
+	group, err := balancer.NewGroup(ctx, balancer.NewFileResolver(file), balancer.Options{RefreshInterval: -1})
+	require.NoError(t, err)
+	defer group.Close()
+	assert.Equal(t, []string{"http://a:80", "http://b:80"}, hostStrings(group.Hosts()))
+
+	// Changes are picked up on refresh
+	require.NoError(t, os.WriteFile(file, []byte("https://c:443\n"), 0o600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, future, future))
+	require.NoError(t, group.Refresh(ctx))
+	assert.Equal(t, []string{"https://c:443"}, hostStrings(group.Hosts()))
+
+	// Invalid files keep the previous hosts
+	require.NoError(t, os.WriteFile(file, []byte("ftp://d\n"), 0o600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, future, future))
+	assert.Error(t, group.Refresh(ctx))
+	assert.Equal(t, []string{"https://c:443"}, hostStrings(group.Hosts()))
+}
+
+func TestGroup_BackgroundRefresh(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	var mu sync.Mutex
+	hosts := []string{"http://a:80"}
+	resolver := balancer.ResolverFunc(func(ctx context.Context) ([]*url.URL, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		urls := []*url.URL{}
+		for _, host := range hosts {
+			u, _ := url.Parse(host)
+			urls = append(urls, u)
+		}
+		return urls, nil
+	})
+
+	group, err := balancer.NewGroup(ctx, resolver, balancer.Options{RefreshInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer group.Close()
+
+	mu.Lock()
+	hosts = []string{"http://a:80", "http://b:80"}
+	mu.Unlock()
+
+	assert.Eventually(t, func() bool { return len(group.Hosts()) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+func TestSRVResolver(t *testing.T) {
+	resolver := balancer.NewSRVResolver("orders", "tcp", "example.com", "https")
+	resolver.LookupSRV = func(ctx context.Context, service string, proto string, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "orders", service)
+		return "_orders._tcp.example.com.", []*net.SRV{
+			{Target: "orders-1.example.com.", Port: 8443, Priority: 10},
+			{Target: "orders-2.example.com.", Port: 8443, Priority: 10},
+			{Target: "backup.example.com.", Port: 8443, Priority: 20},
+		}, nil
+	}
+
+	urls, err := resolver.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://orders-1.example.com:8443", "https://orders-2.example.com:8443"}, hostStrings(urls))
+
+	resolver.Scheme = "ftp"
+	_, err = resolver.Resolve(context.Background())
+	assert.Error(t, err)
+}
+
+func TestNewGroup_Errors(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	_, err := balancer.NewStaticResolver()
+	assert.ErrorIs(t, err, balancer.ErrNoHosts)
+	_, err = balancer.NewStaticResolver("orders:80")
+	assert.Error(t, err)
+
+	resolver, err := balancer.NewStaticResolver("http://a:80")
+	require.NoError(t, err)
+	_, err = balancer.NewGroup(ctx, nil, balancer.Options{})
+	assert.Error(t, err)
+	_, err = balancer.NewGroup(ctx, resolver, balancer.Options{Strategy: balancer.Strategy(42)})
+	assert.Error(t, err)
+	_, err = balancer.NewGroup(ctx, resolver, balancer.Options{FailureThreshold: -1})
+	assert.Error(t, err)
+
+	empty := balancer.ResolverFunc(func(ctx context.Context) ([]*url.URL, error) { return nil, nil })
+	_, err = balancer.NewGroup(ctx, empty, balancer.Options{})
+	assert.ErrorIs(t, err, balancer.ErrNoHosts)
+}
+
+func hostStrings(urls []*url.URL) []string {
+	hosts := make([]string, len(urls))
+	for i, u := range urls {
+		hosts[i] = u.String()
+	}
+	return hosts
+}
@@ -0,0 +1,202 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver finds the hosts of a service, as absolute URLs such as "https://10.0.0.4:8443"
+type Resolver interface {
+	Resolve(ctx context.Context) ([]*url.URL, error)
+}
+
+// ResolverFunc adapts a function to a Resolver
+type ResolverFunc func(ctx context.Context) ([]*url.URL, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context) ([]*url.URL, error) {
+	return f(ctx)
+}
+
+// StaticResolver always returns the same hosts
+type StaticResolver struct {
+	urls []*url.URL
+}
+
+// Create a resolver for a fixed list of host URLs
+func NewStaticResolver(rawURLs ...string) (*StaticResolver, error) {
+	if len(rawURLs) == 0 {
+		return nil, ErrNoHosts
+	}
+
+	urls, err := parseHostURLs(rawURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StaticResolver{urls: urls}, nil
+}
+
+// Get the hosts
+func (r *StaticResolver) Resolve(ctx context.Context) ([]*url.URL, error) {
+	urls := make([]*url.URL, len(r.urls))
+	for i, u := range r.urls {
+		urls[i] = cloneURL(u)
+	}
+
+	return urls, nil
+}
+
+// SRVResolver finds the hosts with a DNS SRV lookup, e.g. "_orders._tcp.example.com".
+// Only the targets with the lowest priority are returned, the others are backups
+type SRVResolver struct {
+	Service string
+	Proto   string
+	Name    string
+	// Scheme of the host URLs, "http" or "https"
+	Scheme string
+	// LookupSRV performs the lookup, defaults to net.DefaultResolver.LookupSRV
+	LookupSRV func(ctx context.Context, service string, proto string, name string) (string, []*net.SRV, error)
+}
+
+// Create a resolver for the SRV records of the service, e.g. NewSRVResolver("orders", "tcp", "example.com", "https")
+func NewSRVResolver(service string, proto string, name string, scheme string) *SRVResolver {
+	return &SRVResolver{
+		Service:   service,
+		Proto:     proto,
+		Name:      name,
+		Scheme:    scheme,
+		LookupSRV: net.DefaultResolver.LookupSRV,
+	}
+}
+
+// Look up the hosts
+func (r *SRVResolver) Resolve(ctx context.Context) ([]*url.URL, error) {
+	if r.Scheme != "http" && r.Scheme != "https" {
+		return nil, errors.New("host scheme must be http or https: " + r.Scheme)
+	}
+
+	lookup := r.LookupSRV
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupSRV
+	}
+	_, records, err := lookup(ctx, r.Service, r.Proto, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrNoHosts
+	}
+
+	lowest := records[0].Priority
+	for _, record := range records {
+		if record.Priority < lowest {
+			lowest = record.Priority
+		}
+	}
+
+	urls := make([]*url.URL, 0, len(records))
+	for _, record := range records {
+		if record.Priority != lowest {
+			continue
+		}
+		urls = append(urls, &url.URL{
+			Scheme: r.Scheme,
+			Host:   net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))),
+		})
+	}
+
+	return urls, nil
+}
+
+// FileResolver reads the hosts from a file, one URL per line. Blank lines and lines starting with # are ignored.
+// The file is read again only when it changes, so it can be updated while the group is running
+type FileResolver struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	urls    []*url.URL
+}
+
+// Create a resolver for the file
+func NewFileResolver(path string) *FileResolver {
+	return &FileResolver{path: path}
+}
+
+// Get the hosts in the file
+func (r *FileResolver) Resolve(ctx context.Context) ([]*url.URL, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.urls == nil || !info.ModTime().Equal(r.modTime) || info.Size() != r.size {
+		data, err := os.ReadFile(r.path)
+		if err != nil {
+			return nil, err
+		}
+
+		var rawURLs []string
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			rawURLs = append(rawURLs, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		urls, err := parseHostURLs(rawURLs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.path, err)
+		}
+
+		r.urls = urls
+		r.modTime = info.ModTime()
+		r.size = info.Size()
+	}
+
+	urls := make([]*url.URL, len(r.urls))
+	for i, u := range r.urls {
+		urls[i] = cloneURL(u)
+	}
+
+	return urls, nil
+}
+
+// Parse and validate host URLs
+func parseHostURLs(rawURLs []string) ([]*url.URL, error) {
+	urls := make([]*url.URL, 0, len(rawURLs))
+	for _, rawURL := range rawURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host URL: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, errors.New("host URL scheme must be http or https: " + rawURL)
+		}
+		if u.Host == "" {
+			return nil, errors.New("host URL has no host: " + rawURL)
+		}
+		urls = append(urls, u)
+	}
+
+	return urls, nil
+}
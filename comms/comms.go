@@ -2,18 +2,34 @@ package comms
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
 
 	"github.com/perocha/goadapters/messaging"
 )
 
+// ErrBodyTooLarge is returned when reading a request body larger than the receiver's limit
+var ErrBodyTooLarge = errors.New("request body too large")
+
 type CommsSender interface {
 	SendRequest(ctx context.Context, endpoint EndPoint, data messaging.Message) error
 }
 
+// RequestResponseSender is implemented by senders that return the receiver's reply, for synchronous calls
+type RequestResponseSender interface {
+	CommsSender
+	// Call sends the message (nil for no body) with the method, e.g. "GET", and returns the reply whatever its status
+	Call(ctx context.Context, method string, endpoint EndPoint, data messaging.Message) (Response, error)
+}
+
 type CommsReceiver interface {
 	Start(ctx context.Context) error
 	Stop(ctx context.Context) error
-	RegisterEndPoint(ctx context.Context, endpointPath string, handler HandlerFunc) error
+	RegisterEndPoint(ctx context.Context, endpointPath string, handler HandlerFunc, middlewares ...Middleware) error
+	RegisterRoute(ctx context.Context, method string, pattern string, handler HandlerFunc, middlewares ...Middleware) error
+	UnregisterEndPoint(ctx context.Context, endpointPath string) error
 }
 
 // HandlerFunc defines the interface for the handler function
@@ -21,15 +37,47 @@ type HandlerFunc func(context.Context, ResponseWriter, Request) (context.Context
 
 // ResponseWriter interface to abstract response writing
 type ResponseWriter interface {
+	Header() Header
 	Write([]byte) (int, error)
 	WriteHeader(statusCode int)
 	Status() int
 }
 
+// Header interface to abstract multi-value headers, keys are case insensitive (implemented by http.Header)
+type Header interface {
+	Get(key string) string
+	Values(key string) []string
+	Set(key string, value string)
+	Add(key string, value string)
+	Del(key string)
+}
+
 // Request interface to abstract incoming requests
 type Request interface {
+	Method() string
+	URL() *url.URL
+	RemoteAddr() string
 	Header(key string) string
+	Headers() Header
+	PathParam(name string) string
+	QueryParam(key string) string
+	Query() url.Values
+	// Body returns the whole body, or nil if it can't be read (see ReadBody for the error)
 	Body() []byte
+	// ReadBody returns the whole body, or ErrBodyTooLarge if it exceeds the receiver's limit
+	ReadBody() ([]byte, error)
+	// BodyReader streams the body, reads fail with ErrBodyTooLarge past the receiver's limit
+	BodyReader() io.Reader
+}
+
+// Response interface to abstract the reply to a request/response call
+type Response interface {
+	StatusCode() int
+	Header(key string) string
+	Headers() Header
+	Body() []byte
+	// Message deserializes the body into a message
+	Message() (messaging.Message, error)
 }
 
 // EndPoint interface
@@ -37,3 +85,53 @@ type EndPoint interface {
 	GetEndPoint() string
 	SetEndPoint(endPoint string)
 }
+
+// URLEndPoint is implemented by endpoints that hold a full URL
+type URLEndPoint interface {
+	EndPoint
+	URL() *url.URL
+}
+
+// BalancedEndPoint is implemented by endpoints that spread requests over several hosts
+type BalancedEndPoint interface {
+	EndPoint
+	// Pick the URL of a request. done must be called once the request completes, with its error (nil on success)
+	Pick(ctx context.Context) (target *url.URL, done func(err error), err error)
+}
+
+// Resolve the endpoint to an absolute URL. URLEndPoint implementations return their URL,
+// other endpoints are parsed from GetEndPoint
+func ResolveURL(endpoint EndPoint) (*url.URL, error) {
+	if endpoint == nil {
+		return nil, errors.New("endpoint is nil")
+	}
+
+	var resolved *url.URL
+	if urlEndPoint, ok := endpoint.(URLEndPoint); ok {
+		resolved = urlEndPoint.URL()
+	} else {
+		parsed, err := url.Parse(endpoint.GetEndPoint())
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint: %w", err)
+		}
+		resolved = parsed
+	}
+
+	if resolved == nil || resolved.Scheme == "" || resolved.Host == "" {
+		return nil, errors.New("endpoint is not an absolute URL: " + endpoint.GetEndPoint())
+	}
+
+	return resolved, nil
+}
+
+// Middleware wraps a handler, e.g. to run code before and after it, or to answer without calling it
+type Middleware func(HandlerFunc) HandlerFunc
+
+// Chain wraps the handler with the middleware, the first middleware is the outermost
+func Chain(handler HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
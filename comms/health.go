@@ -0,0 +1,16 @@
+package comms
+
+import "context"
+
+// HealthChecker is implemented by adapters that can check their connection to a service, e.g. by reading
+// its properties. CheckHealth returns nil when the service can be used, it should honor the context's deadline
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// HealthCheckFunc adapts a function to a HealthChecker
+type HealthCheckFunc func(ctx context.Context) error
+
+func (f HealthCheckFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
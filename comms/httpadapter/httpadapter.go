@@ -1,40 +1,89 @@
 package httpadapter
 
-import "net/http"
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+)
 
 // HttpSender implements the sender part of comms interface
 type HttpSender struct {
-	httpClient *http.Client
+	httpClient       *http.Client
+	tlsConfig        *tls.Config
+	timeout          time.Duration
+	transportOptions transportOptions
+	roundTripper     http.RoundTripper
+	defaultHeaders   http.Header
+	credentials      CredentialProvider
+	webhookKeys      [][]byte
+	rateLimiter      *senderLimiter
+	healthEndpoint   comms.EndPoint
+	// Status codes accepted by SendRequest, any 2xx if empty
+	successStatuses map[int]bool
 }
 
-// HttpReceiver implements the receiver part of comms interface
+// Default overall timeout of the sender's requests, including reading the response
+const DefaultSenderTimeout = 30 * time.Second
+
+// SenderOption configures an HttpSender
+type SenderOption func(*HttpSender) error
+
+// HttpReceiver implements the receiver part of comms interface. Every receiver owns its routes
 type HttpReceiver struct {
-	httpServer *http.Server
-	portNumber string
+	httpServer   *http.Server
+	portNumber   string
+	mu           sync.RWMutex
+	routes       map[string]http.Handler
+	mux          *http.ServeMux
+	maxBodyBytes int64
+	tlsConfig    *tls.Config
+	middlewares  []comms.Middleware
+	// Health endpoints by path, and the mux serving them ahead of the global middleware, built at init
+	healthEndpoints map[string]*healthChecks
+	healthMux       *http.ServeMux
+	// Request metrics of the endpoints, nil unless WithMetrics is used
+	metrics     *receiverMetrics
+	metricsPath string
+	// Values (e.g. the telemetry client) for the global middleware, taken from the context given at init
+	baseCtx context.Context
 }
 
+// ReceiverOption configures an HttpReceiver
+type ReceiverOption func(*HttpReceiver) error
+
+// Default limit of request bodies read by the receiver
+const DefaultMaxBodyBytes int64 = 10 << 20
+
 // HTTPStatus represents custom HTTP status codes
 type HTTPStatus int
 
 const (
 	// Success status codes
-	StatusOK HTTPStatus = 200
+	StatusOK        HTTPStatus = 200
+	StatusCreated   HTTPStatus = 201
+	StatusAccepted  HTTPStatus = 202
+	StatusNoContent HTTPStatus = 204
 
 	// Redirection status codes
 	StatusMovedPermanently HTTPStatus = 301
 	StatusFound            HTTPStatus = 302
 
 	// Client error status codes
-	StatusBadRequest           HTTPStatus = 400
-	StatusUnauthorized         HTTPStatus = 401
-	StatusForbidden            HTTPStatus = 403
-	StatusNotFound             HTTPStatus = 404
-	StatusMethodNotAllowed     HTTPStatus = 405
-	StatusRequestTimeout       HTTPStatus = 408
-	StatusConflict             HTTPStatus = 409
-	StatusPreconditionFailed   HTTPStatus = 412
-	StatusUnsupportedMediaType HTTPStatus = 415
-	StatusTooManyRequests      HTTPStatus = 429
+	StatusBadRequest            HTTPStatus = 400
+	StatusUnauthorized          HTTPStatus = 401
+	StatusForbidden             HTTPStatus = 403
+	StatusNotFound              HTTPStatus = 404
+	StatusMethodNotAllowed      HTTPStatus = 405
+	StatusRequestTimeout        HTTPStatus = 408
+	StatusConflict              HTTPStatus = 409
+	StatusPreconditionFailed    HTTPStatus = 412
+	StatusRequestEntityTooLarge HTTPStatus = 413
+	StatusUnsupportedMediaType  HTTPStatus = 415
+	StatusTooManyRequests       HTTPStatus = 429
 
 	// Server error status codes
 	StatusInternalServerError HTTPStatus = 500
@@ -43,3 +92,8 @@ const (
 	StatusServiceUnavailable  HTTPStatus = 503
 	StatusGatewayTimeout      HTTPStatus = 504
 )
+
+// Get the standard reason phrase of the status, e.g. "Not Found"
+func (s HTTPStatus) String() string {
+	return http.StatusText(int(s))
+}
@@ -0,0 +1,361 @@
+package httpadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// Tokens are refreshed this long before they expire, so that a request never carries an expired token.
+// Short-lived tokens are refreshed halfway through their lifetime instead
+const tokenRefreshMargin = time.Minute
+
+// Lifetime of the tokens issued without an expiry
+const defaultTokenLifetime = 5 * time.Minute
+
+// Timeout of the requests to the token endpoint when no HTTP client is given
+const defaultTokenTimeout = 10 * time.Second
+
+// CredentialProvider authorizes the sender's requests, e.g. by setting the Authorization header
+type CredentialProvider interface {
+	Authorize(ctx context.Context, req *http.Request) error
+}
+
+// Authorize every request with the credential provider
+func WithCredentials(provider CredentialProvider) SenderOption {
+	return func(a *HttpSender) error {
+		if provider == nil {
+			return errors.New("credential provider is nil")
+		}
+		a.credentials = provider
+		return nil
+	}
+}
+
+// StaticToken sends a fixed bearer token
+type StaticToken struct {
+	token string
+}
+
+// Create a provider sending "Authorization: Bearer <token>"
+func NewStaticToken(token string) (*StaticToken, error) {
+	if token == "" {
+		return nil, errors.New("token is empty")
+	}
+
+	return &StaticToken{token: token}, nil
+}
+
+// Set the Authorization header
+func (p *StaticToken) Authorize(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	return nil
+}
+
+// APIKey sends a fixed key in a header
+type APIKey struct {
+	header string
+	key    string
+}
+
+// Create a provider sending the key in the header, e.g. NewAPIKey("X-API-Key", key)
+func NewAPIKey(header string, key string) (*APIKey, error) {
+	if header == "" || key == "" {
+		return nil, errors.New("API key header and key can't be empty")
+	}
+
+	return &APIKey{header: header, key: key}, nil
+}
+
+// Set the key header
+func (p *APIKey) Authorize(ctx context.Context, req *http.Request) error {
+	req.Header.Set(p.header, p.key)
+	return nil
+}
+
+// TokenError is returned when the token endpoint refuses to issue a token
+type TokenError struct {
+	StatusCode int
+	// OAuth2 error code, e.g. "invalid_client"
+	Code        string
+	Description string
+}
+
+func (e *TokenError) Error() string {
+	message := "token endpoint returned status " + strconv.Itoa(e.StatusCode)
+	if e.Code != "" {
+		message += ": " + e.Code
+	}
+	if e.Description != "" {
+		message += " (" + e.Description + ")"
+	}
+
+	return message
+}
+
+// Check if requesting the token again may succeed, only for throttling and server errors
+func (e *TokenError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// tokenCache keeps a token until shortly before it expires. The lock is held while fetching,
+// so concurrent requests wait for a single fetch
+type tokenCache struct {
+	mu        sync.Mutex
+	token     string
+	fetchedAt time.Time
+	expiresOn time.Time
+	fetch     func(ctx context.Context) (string, time.Time, error)
+}
+
+// Get the cached token, fetching a new one if it's missing or about to expire
+func (c *tokenCache) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Until(c.expiresOn) > c.refreshMargin() {
+		return c.token, nil
+	}
+
+	fetchedAt := time.Now()
+	token, expiresOn, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token = token
+	c.fetchedAt = fetchedAt
+	c.expiresOn = expiresOn
+
+	return token, nil
+}
+
+// Get how long before it expires the cached token is refreshed, at most half its lifetime
+// so that short-lived tokens are still reused
+func (c *tokenCache) refreshMargin() time.Duration {
+	return min(tokenRefreshMargin, c.expiresOn.Sub(c.fetchedAt)/2)
+}
+
+// Discard the cached token, the next request fetches a new one
+func (c *tokenCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = ""
+}
+
+// ClientCredentialsOptions configures the OAuth2 client credentials grant
+type ClientCredentialsOptions struct {
+	// TokenURL of the authorization server, e.g. "https://login.example.com/oauth2/token"
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// Scopes requested, sent space separated
+	Scopes []string
+	// EndpointParams are extra form parameters, e.g. "audience"
+	EndpointParams url.Values
+	// SendCredentialsInBody sends the client ID and secret as form parameters instead of HTTP basic auth
+	SendCredentialsInBody bool
+	// HTTPClient calls the token endpoint, defaults to a client with a 10 seconds timeout
+	HTTPClient *http.Client
+}
+
+// ClientCredentials sends bearer tokens obtained with the OAuth2 client credentials grant.
+// Tokens are cached and refreshed before they expire
+type ClientCredentials struct {
+	options ClientCredentialsOptions
+	cache   tokenCache
+}
+
+// Create a client credentials provider
+func NewClientCredentials(options ClientCredentialsOptions) (*ClientCredentials, error) {
+	tokenURL, err := url.Parse(options.TokenURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token URL: %w", err)
+	}
+	if tokenURL.Scheme != "http" && tokenURL.Scheme != "https" {
+		return nil, errors.New("token URL scheme must be http or https: " + options.TokenURL)
+	}
+	if options.ClientID == "" {
+		return nil, errors.New("client ID is empty")
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: defaultTokenTimeout}
+	}
+
+	p := &ClientCredentials{options: options}
+	p.cache.fetch = p.fetchToken
+
+	return p, nil
+}
+
+// Set the Authorization header with the cached token
+func (p *ClientCredentials) Authorize(ctx context.Context, req *http.Request) error {
+	token, err := p.cache.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Discard the cached token, called by the sender when a request is rejected with 401
+func (p *ClientCredentials) Invalidate() {
+	p.cache.invalidate()
+}
+
+// Request a token from the token endpoint
+func (p *ClientCredentials) fetchToken(ctx context.Context) (string, time.Time, error) {
+	startTime := time.Now()
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	form := url.Values{}
+	for key, values := range p.options.EndpointParams {
+		form[key] = append([]string(nil), values...)
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(p.options.Scopes) > 0 {
+		form.Set("scope", strings.Join(p.options.Scopes, " "))
+	}
+	if p.options.SendCredentialsInBody {
+		form.Set("client_id", p.options.ClientID)
+		form.Set("client_secret", p.options.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.options.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", ContentTypeJSON)
+	if !p.options.SendCredentialsInBody {
+		req.SetBasicAuth(url.QueryEscape(p.options.ClientID), url.QueryEscape(p.options.ClientSecret))
+	}
+
+	token, expiresOn, err := p.doTokenRequest(req)
+	if err != nil {
+		xTelemetry.Error(ctx, "HTTPAdapter::ClientCredentials::Failed to get token", telemetry.String("TokenURL", req.URL.Redacted()), telemetry.String("Error", err.Error()))
+		xTelemetry.Dependency(ctx, "OAuth2", req.URL.Hostname(), false, startTime, time.Now(), "HTTPAdapter::ClientCredentials::Failed to get token")
+		return "", time.Time{}, err
+	}
+
+	xTelemetry.Dependency(ctx, "OAuth2", req.URL.Hostname(), true, startTime, time.Now(), "HTTPAdapter::ClientCredentials::Token issued")
+	return token, expiresOn, nil
+}
+
+// Perform the token request and parse the token response
+func (p *ClientCredentials) doTokenRequest(req *http.Request) (string, time.Time, error) {
+	resp, err := p.options.HTTPClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, DefaultMaxBodyBytes))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var tokenResponse struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	decodeErr := json.Unmarshal(body, &tokenResponse)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", time.Time{}, &TokenError{StatusCode: resp.StatusCode, Code: tokenResponse.Error, Description: tokenResponse.ErrorDescription}
+	}
+	if decodeErr != nil {
+		return "", time.Time{}, fmt.Errorf("invalid token response: %w", decodeErr)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", time.Time{}, errors.New("token response has no access token")
+	}
+	if tokenResponse.TokenType != "" && !strings.EqualFold(tokenResponse.TokenType, "bearer") {
+		return "", time.Time{}, errors.New("unsupported token type: " + tokenResponse.TokenType)
+	}
+
+	// Tokens without an expiry are kept for the default lifetime, a rejected token is discarded anyway
+	lifetime := defaultTokenLifetime
+	if tokenResponse.ExpiresIn != "" {
+		seconds, err := tokenResponse.ExpiresIn.Int64()
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("invalid token expiry: %w", err)
+		}
+		if seconds > 0 {
+			lifetime = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return tokenResponse.AccessToken, time.Now().Add(lifetime), nil
+}
+
+// AzureCredential sends bearer tokens from an azcore.TokenCredential, e.g. an azidentity.DefaultAzureCredential,
+// for Microsoft Entra ID (Azure AD) protected APIs
+type AzureCredential struct {
+	credential azcore.TokenCredential
+	scopes     []string
+	cache      tokenCache
+}
+
+// Create a provider requesting tokens for the scopes, e.g. NewAzureCredential(cred, "api://orders/.default")
+func NewAzureCredential(credential azcore.TokenCredential, scopes ...string) (*AzureCredential, error) {
+	if credential == nil {
+		return nil, errors.New("token credential is nil")
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("no scopes")
+	}
+
+	p := &AzureCredential{
+		credential: credential,
+		scopes:     append([]string(nil), scopes...),
+	}
+	p.cache.fetch = p.fetchToken
+
+	return p, nil
+}
+
+// Set the Authorization header with the cached token
+func (p *AzureCredential) Authorize(ctx context.Context, req *http.Request) error {
+	token, err := p.cache.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Discard the cached token, called by the sender when a request is rejected with 401
+func (p *AzureCredential) Invalidate() {
+	p.cache.invalidate()
+}
+
+// Get a token from the credential
+func (p *AzureCredential) fetchToken(ctx context.Context) (string, time.Time, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	token, err := p.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: p.scopes})
+	if err != nil {
+		xTelemetry.Error(ctx, "HTTPAdapter::AzureCredential::Failed to get token", telemetry.String("Scopes", strings.Join(p.scopes, " ")), telemetry.String("Error", err.Error()))
+		return "", time.Time{}, err
+	}
+
+	return token.Token, token.ExpiresOn, nil
+}
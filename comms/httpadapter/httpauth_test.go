@@ -0,0 +1,248 @@
+package httpadapter_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTokenServer is a local OAuth2 token endpoint issuing numbered tokens, without an expiry when expiresIn is zero
+type fakeTokenServer struct {
+	server    *httptest.Server
+	mu        sync.Mutex
+	issued    int
+	expiresIn int
+	forms     []url.Values
+	reject    bool
+}
+
+func newFakeTokenServer(t *testing.T, expiresIn int) *fakeTokenServer {
+	f := &fakeTokenServer{expiresIn: expiresIn}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		require.NoError(t, r.ParseForm())
+		f.forms = append(f.forms, r.PostForm)
+
+		w.Header().Set("Content-Type", "application/json")
+		clientID, secret, ok := r.BasicAuth()
+		if !ok {
+			clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if f.reject || clientID != "orders" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"bad credentials"}`))
+			return
+		}
+
+		f.issued++
+		response := map[string]any{
+			"access_token": "token-" + strconv.Itoa(f.issued),
+			"token_type":   "Bearer",
+		}
+		if f.expiresIn != 0 {
+			response["expires_in"] = f.expiresIn
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeTokenServer) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.issued
+}
+
+// Start an API server recording the Authorization headers, answering with the given status
+func newAuthServer(t *testing.T, status *int) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	headers := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Get("Authorization")+r.Header.Get("X-API-Key"))
+		code := *status
+		mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(server.Close)
+	return server, &headers
+}
+
+func TestCredentials_StaticTokenAndAPIKey(t *testing.T) {
+	ctx := initializeTelemetry()
+	status := http.StatusOK
+	server, headers := newAuthServer(t, &status)
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/orders")
+	msg := messaging.NewMessage("", nil, "pending", "create", nil)
+
+	token, err := httpadapter.NewStaticToken("abc")
+	require.NoError(t, err)
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithCredentials(token))
+	require.NoError(t, err)
+	assert.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+
+	key, err := httpadapter.NewAPIKey("X-API-Key", "key-1")
+	require.NoError(t, err)
+	sender, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithCredentials(key))
+	require.NoError(t, err)
+	assert.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+
+	assert.Equal(t, []string{"Bearer abc", "key-1"}, *headers)
+}
+
+func TestCredentials_ClientCredentials(t *testing.T) {
+	ctx := initializeTelemetry()
+	tokens := newFakeTokenServer(t, 3600)
+	status := http.StatusOK
+	server, headers := newAuthServer(t, &status)
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/orders")
+	msg := messaging.NewMessage("", nil, "pending", "create", nil)
+
+	provider, err := httpadapter.NewClientCredentials(httpadapter.ClientCredentialsOptions{
+		TokenURL:       tokens.server.URL,
+		ClientID:       "orders",
+		ClientSecret:   "s3cret",
+		Scopes:         []string{"orders.read", "orders.write"},
+		EndpointParams: url.Values{"audience": {"https://api.example.com"}},
+	})
+	require.NoError(t, err)
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithCredentials(provider))
+	require.NoError(t, err)
+
+	// The token is cached across requests
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+	}
+	assert.Equal(t, 1, tokens.count())
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-1"}, *headers)
+	assert.Equal(t, "client_credentials", tokens.forms[0].Get("grant_type"))
+	assert.Equal(t, "orders.read orders.write", tokens.forms[0].Get("scope"))
+	assert.Equal(t, "https://api.example.com", tokens.forms[0].Get("audience"))
+	assert.Empty(t, tokens.forms[0].Get("client_secret"))
+
+	// A rejected token is discarded, the next request gets a new one
+	status = http.StatusUnauthorized
+	assert.Error(t, sender.SendRequest(ctx, endpoint, msg))
+	status = http.StatusOK
+	assert.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+	assert.Equal(t, 2, tokens.count())
+	assert.Equal(t, "Bearer token-2", (*headers)[len(*headers)-1])
+}
+
+func TestCredentials_ClientCredentialsRefresh(t *testing.T) {
+	ctx := initializeTelemetry()
+	authorize := func(provider *httpadapter.ClientCredentials) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, provider.Authorize(ctx, req))
+		return req.Header.Get("Authorization")
+	}
+
+	// Tokens shorter-lived than the refresh margin are refreshed halfway through their lifetime
+	tokens := newFakeTokenServer(t, 1)
+	provider, err := httpadapter.NewClientCredentials(httpadapter.ClientCredentialsOptions{
+		TokenURL:              tokens.server.URL,
+		ClientID:              "orders",
+		ClientSecret:          "s3cret",
+		SendCredentialsInBody: true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Bearer token-1", authorize(provider))
+	assert.Equal(t, "Bearer token-1", authorize(provider))
+	assert.Equal(t, "orders", tokens.forms[0].Get("client_id"))
+	time.Sleep(600 * time.Millisecond)
+	assert.Equal(t, "Bearer token-2", authorize(provider))
+
+	// Tokens without an expiry are reused too
+	tokens = newFakeTokenServer(t, 0)
+	provider, err = httpadapter.NewClientCredentials(httpadapter.ClientCredentialsOptions{TokenURL: tokens.server.URL, ClientID: "orders", ClientSecret: "s3cret"})
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token-1", authorize(provider))
+	assert.Equal(t, "Bearer token-1", authorize(provider))
+	assert.Equal(t, 1, tokens.count())
+}
+
+func TestCredentials_TokenEndpointError(t *testing.T) {
+	ctx := initializeTelemetry()
+	tokens := newFakeTokenServer(t, 3600)
+	tokens.reject = true
+	status := http.StatusOK
+	server, headers := newAuthServer(t, &status)
+
+	provider, err := httpadapter.NewClientCredentials(httpadapter.ClientCredentialsOptions{TokenURL: tokens.server.URL, ClientID: "orders", ClientSecret: "s3cret"})
+	require.NoError(t, err)
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithCredentials(provider))
+	require.NoError(t, err)
+
+	// The request isn't sent without a token
+	err = sender.SendRequest(ctx, httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/orders"), messaging.NewMessage("", nil, "pending", "create", nil))
+	var tokenErr *httpadapter.TokenError
+	require.True(t, errors.As(err, &tokenErr))
+	assert.Equal(t, http.StatusUnauthorized, tokenErr.StatusCode)
+	assert.Equal(t, "invalid_client", tokenErr.Code)
+	assert.False(t, tokenErr.Retryable())
+	assert.Empty(t, *headers)
+}
+
+// fakeAzureCredential implements azcore.TokenCredential
+type fakeAzureCredential struct {
+	calls  int
+	scopes []string
+}
+
+func (f *fakeAzureCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	f.calls++
+	f.scopes = options.Scopes
+	return azcore.AccessToken{Token: "azure-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestCredentials_AzureCredential(t *testing.T) {
+	ctx := initializeTelemetry()
+	credential := &fakeAzureCredential{}
+	provider, err := httpadapter.NewAzureCredential(credential, "api://orders/.default")
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, provider.Authorize(ctx, req))
+		assert.Equal(t, "Bearer azure-token", req.Header.Get("Authorization"))
+	}
+	assert.Equal(t, 1, credential.calls)
+	assert.Equal(t, []string{"api://orders/.default"}, credential.scopes)
+
+	_, err = httpadapter.NewAzureCredential(credential)
+	assert.Error(t, err)
+}
+
+func TestCredentials_InvalidOptions(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	_, err := httpadapter.NewStaticToken("")
+	assert.Error(t, err)
+	_, err = httpadapter.NewAPIKey("", "key")
+	assert.Error(t, err)
+	_, err = httpadapter.NewClientCredentials(httpadapter.ClientCredentialsOptions{TokenURL: "ftp://tokens", ClientID: "orders"})
+	assert.Error(t, err)
+	_, err = httpadapter.NewClientCredentials(httpadapter.ClientCredentialsOptions{TokenURL: "https://tokens"})
+	assert.Error(t, err)
+	_, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithCredentials(nil))
+	assert.Error(t, err)
+}
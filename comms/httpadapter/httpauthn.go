@@ -0,0 +1,556 @@
+package httpadapter
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request has no credentials it handles,
+// the next authenticator is then tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrInvalidCredentials is answered with 401 when the credentials of a request are rejected
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrForbidden is answered with 403 when the principal isn't allowed to call the endpoint
+var ErrForbidden = errors.New("forbidden")
+
+const (
+	defaultJWKSRefreshInterval    = time.Hour
+	defaultJWKSMinRefreshInterval = 5 * time.Second
+	defaultJWKSTimeout            = 10 * time.Second
+)
+
+// Signing algorithms accepted by default
+var defaultJWTAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Authenticator identifies the caller of a request
+type Authenticator interface {
+	// Authenticate returns the caller, ErrNoCredentials if the request has no credentials for this
+	// authenticator, or another error if the credentials are invalid
+	Authenticate(ctx context.Context, r comms.Request) (*comms.Principal, error)
+}
+
+// Authenticate the requests with the first authenticator that finds credentials, answering 401 when none does
+// or the credentials are invalid. The principal is added to the handler's context, see comms.PrincipalFromContext
+func Authenticate(authenticators ...Authenticator) comms.Middleware {
+	challenge := ""
+	for _, authenticator := range authenticators {
+		if _, ok := authenticator.(*JWTAuthenticator); ok {
+			challenge = "Bearer"
+		}
+	}
+
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(ctx, r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					xTelemetry := telemetry.GetXTelemetryClient(ctx)
+					xTelemetry.Warn(ctx, "HTTPAdapter::Authenticate::Invalid credentials", telemetry.String("Path", r.URL().Path), telemetry.String("Error", err.Error()))
+
+					if challenge != "" {
+						w.Header().Set("WWW-Authenticate", challenge+` error="invalid_token"`)
+					}
+					_ = WriteError(w, StatusUnauthorized, ErrInvalidCredentials)
+					return ctx, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+				}
+
+				return next(comms.WithPrincipal(ctx, principal), w, r)
+			}
+
+			if challenge != "" {
+				w.Header().Set("WWW-Authenticate", challenge)
+			}
+			_ = WriteError(w, StatusUnauthorized, ErrNoCredentials)
+			return ctx, ErrNoCredentials
+		}
+	}
+}
+
+// Allow the request when the rule returns true for the principal, answering 403 otherwise.
+// Requests without a principal are answered with 401, add Authenticate before the rules
+func Authorize(rule func(ctx context.Context, principal *comms.Principal, r comms.Request) bool) comms.Middleware {
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			principal, ok := comms.PrincipalFromContext(ctx)
+			if !ok {
+				_ = WriteError(w, StatusUnauthorized, ErrNoCredentials)
+				return ctx, ErrNoCredentials
+			}
+
+			if !rule(ctx, principal, r) {
+				xTelemetry := telemetry.GetXTelemetryClient(ctx)
+				xTelemetry.Warn(ctx, "HTTPAdapter::Authorize::Access denied", telemetry.String("Path", r.URL().Path), telemetry.String("Subject", principal.Subject))
+
+				_ = WriteError(w, StatusForbidden, ErrForbidden)
+				return ctx, ErrForbidden
+			}
+
+			return next(ctx, w, r)
+		}
+	}
+}
+
+// Allow the request only when the principal has every scope
+func RequireScopes(scopes ...string) comms.Middleware {
+	return Authorize(func(ctx context.Context, principal *comms.Principal, r comms.Request) bool {
+		for _, scope := range scopes {
+			if !principal.HasScope(scope) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Allow the request when the principal has at least one of the roles
+func RequireAnyRole(roles ...string) comms.Middleware {
+	return Authorize(func(ctx context.Context, principal *comms.Principal, r comms.Request) bool {
+		for _, role := range roles {
+			if principal.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// APIKeyAuthenticator authenticates requests with a key in a header
+type APIKeyAuthenticator struct {
+	header string
+	// Principals by the SHA-256 of their key, so that lookups don't depend on the key's content
+	principals map[[sha256.Size]byte]comms.Principal
+}
+
+// Create an authenticator reading the key from the header, e.g. "X-API-Key". Every key maps to the principal
+// it authenticates, with the scopes and roles checked by the authorization rules
+func NewAPIKeyAuthenticator(header string, keys map[string]comms.Principal) (*APIKeyAuthenticator, error) {
+	if header == "" {
+		return nil, errors.New("API key header is empty")
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no API keys")
+	}
+
+	principals := make(map[[sha256.Size]byte]comms.Principal, len(keys))
+	for key, principal := range keys {
+		if key == "" {
+			return nil, errors.New("API key is empty")
+		}
+		principal.Method = "apikey"
+		principals[sha256.Sum256([]byte(key))] = principal
+	}
+
+	return &APIKeyAuthenticator{header: header, principals: principals}, nil
+}
+
+// Find the principal of the key
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, r comms.Request) (*comms.Principal, error) {
+	key := r.Header(a.header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	principal, ok := a.principals[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, errors.New("unknown API key")
+	}
+
+	return &principal, nil
+}
+
+// JWTOptions configures the validation of bearer tokens
+type JWTOptions struct {
+	// Issuer expected in the "iss" claim
+	Issuer string
+	// Audience expected in the "aud" claim
+	Audience string
+	// JWKSURL serves the issuer's signing keys, e.g. "https://login.example.com/.well-known/jwks.json"
+	JWKSURL string
+	// JWKSFile holds the signing keys, instead of JWKSURL
+	JWKSFile string
+	// Algorithms accepted, defaults to the RSA, RSA-PSS, ECDSA and EdDSA algorithms
+	Algorithms []string
+	// Leeway allowed when checking the expiry and not before times
+	Leeway time.Duration
+	// RefreshInterval is how often the keys are loaded again, defaults to an hour
+	RefreshInterval time.Duration
+	// MinRefreshInterval is the shortest time between two loads of the keys, limiting the reloads triggered
+	// by tokens signed with an unknown key or by a failing JWKS URL. Defaults to 5 seconds
+	MinRefreshInterval time.Duration
+	// HTTPClient fetches the JWKS URL, defaults to a client with a 10 seconds timeout
+	HTTPClient *http.Client
+}
+
+// JWTAuthenticator authenticates requests with a signed JWT in the "Authorization: Bearer" header
+type JWTAuthenticator struct {
+	options JWTOptions
+	parser  *jwt.Parser
+	keys    *keySet
+}
+
+// Create a JWT authenticator. The keys are loaded on the first request and cached
+func NewJWTAuthenticator(options JWTOptions) (*JWTAuthenticator, error) {
+	if options.Issuer == "" || options.Audience == "" {
+		return nil, errors.New("issuer and audience are required")
+	}
+	if (options.JWKSURL == "") == (options.JWKSFile == "") {
+		return nil, errors.New("exactly one of JWKS URL and JWKS file is required")
+	}
+	if options.Leeway < 0 || options.RefreshInterval < 0 || options.MinRefreshInterval < 0 {
+		return nil, errors.New("durations can't be negative")
+	}
+	if len(options.Algorithms) == 0 {
+		options.Algorithms = defaultJWTAlgorithms
+	}
+	for _, algorithm := range options.Algorithms {
+		if method := jwt.GetSigningMethod(algorithm); method == nil || algorithm == "none" || strings.HasPrefix(algorithm, "HS") {
+			return nil, errors.New("unsupported signing algorithm: " + algorithm)
+		}
+	}
+	if options.RefreshInterval == 0 {
+		options.RefreshInterval = defaultJWKSRefreshInterval
+	}
+	if options.MinRefreshInterval == 0 {
+		options.MinRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+
+	return &JWTAuthenticator{
+		options: options,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(options.Algorithms),
+			jwt.WithIssuer(options.Issuer),
+			jwt.WithAudience(options.Audience),
+			jwt.WithLeeway(options.Leeway),
+			jwt.WithExpirationRequired(),
+		),
+		keys: &keySet{options: &options},
+	}, nil
+}
+
+// Validate the bearer token and build the principal from its claims
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, r comms.Request) (*comms.Principal, error) {
+	scheme, token, found := strings.Cut(r.Header("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(strings.TrimSpace(token), claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &comms.Principal{
+		Method: "jwt",
+		Claims: claims,
+	}
+	principal.Subject, _ = claims.GetSubject()
+	principal.Issuer, _ = claims.GetIssuer()
+
+	// OAuth2 scopes are a space separated "scope" claim, Microsoft Entra ID uses "scp"
+	for _, claim := range []string{"scope", "scp"} {
+		principal.Scopes = append(principal.Scopes, stringsClaim(claims[claim])...)
+	}
+	principal.Roles = stringsClaim(claims["roles"])
+
+	return principal, nil
+}
+
+// Get the strings of a claim, either a space separated string or an array of strings
+func stringsClaim(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// keySet caches the signing keys of a JWKS, by key ID. Keys are loaded by a single request at a time,
+// without holding the lock, the concurrent requests needing them wait for that load
+type keySet struct {
+	options *JWTOptions
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	loadedAt    time.Time
+	attemptedAt time.Time
+	loadErr     error
+	// Closed once the load in progress is done, nil when there's none
+	loading chan struct{}
+}
+
+// Get the key with the ID, loading the keys again when they're stale or the ID is unknown.
+// Reloads are at least MinRefreshInterval apart, so unknown key IDs or a failing JWKS URL can't cause a fetch per request
+func (s *keySet) key(ctx context.Context, kid string) (interface{}, error) {
+	s.mu.Lock()
+	if s.needsLoad(kid) {
+		if s.loading != nil {
+			// Wait for the load in progress
+			loading := s.loading
+			s.mu.Unlock()
+			select {
+			case <-loading:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			s.mu.Lock()
+		} else if time.Since(s.attemptedAt) >= s.options.MinRefreshInterval {
+			loading := make(chan struct{})
+			s.loading = loading
+			s.attemptedAt = time.Now()
+			s.mu.Unlock()
+
+			// The load is shared, the request being cancelled doesn't cancel it
+			keys, err := s.load(context.WithoutCancel(ctx))
+
+			s.mu.Lock()
+			if err == nil {
+				s.keys = keys
+				s.loadedAt = time.Now()
+			}
+			s.loadErr = err
+			s.loading = nil
+			close(loading)
+		}
+	}
+	defer s.mu.Unlock()
+
+	if s.keys == nil {
+		return nil, s.loadErr
+	}
+
+	if kid == "" {
+		// Tokens without a key ID are accepted only when there's a single key
+		if len(s.keys) == 1 {
+			for _, key := range s.keys {
+				return key, nil
+			}
+		}
+		return nil, errors.New("token has no key ID")
+	}
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key: " + kid)
+	}
+
+	return key, nil
+}
+
+// Check if the keys must be loaded, because they're stale or don't include the key ID. Must be called with the lock held
+func (s *keySet) needsLoad(kid string) bool {
+	if s.keys == nil || time.Since(s.loadedAt) >= s.options.RefreshInterval {
+		return true
+	}
+	if kid == "" {
+		return len(s.keys) != 1
+	}
+	_, known := s.keys[kid]
+
+	return !known
+}
+
+// Load the keys from the JWKS URL or file
+func (s *keySet) load(ctx context.Context) (map[string]interface{}, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	source := s.options.JWKSFile
+	var data []byte
+	var err error
+	if s.options.JWKSURL != "" {
+		source = s.options.JWKSURL
+		data, err = s.fetch(ctx)
+	} else {
+		data, err = os.ReadFile(s.options.JWKSFile)
+	}
+	if err == nil {
+		var keys map[string]interface{}
+		keys, err = parseJWKS(data)
+		if err == nil {
+			xTelemetry.Info(ctx, "HTTPAdapter::JWTAuthenticator::Signing keys loaded", telemetry.String("Source", source), telemetry.String("Keys", fmt.Sprint(len(keys))))
+			return keys, nil
+		}
+	}
+
+	xTelemetry.Error(ctx, "HTTPAdapter::JWTAuthenticator::Failed to load signing keys", telemetry.String("Source", source), telemetry.String("Error", err.Error()))
+	return nil, err
+}
+
+// Fetch the JWKS URL
+func (s *keySet) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.options.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentTypeJSON)
+
+	resp, err := s.options.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS URL returned status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, DefaultMaxBodyBytes))
+}
+
+// jsonWebKey is a public key of a JWKS (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Parse the signing keys of a JWKS. Encryption keys and unsupported key types are skipped
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = jwk.rsaKey()
+		case "EC":
+			key, err = jwk.ecdsaKey()
+		case "OKP":
+			key, err = jwk.ed25519Key()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no signing keys")
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func (k jsonWebKey) ecdsaKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var validator ecdh.Curve
+	switch k.Crv {
+	case "P-256":
+		curve, validator = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, validator = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, validator = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, errors.New("unsupported curve: " + k.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the point is on the curve
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) != size || len(y) != size {
+		return nil, errors.New("invalid EC coordinates")
+	}
+	if _, err := validator.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+func (k jsonWebKey) ed25519Key() (ed25519.PublicKey, error) {
+	if k.Crv != "Ed25519" {
+		return nil, errors.New("unsupported curve: " + k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 key")
+	}
+
+	return ed25519.PublicKey(x), nil
+}
@@ -0,0 +1,287 @@
+package httpadapter_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer   = "https://login.example.com"
+	testAudience = "orders-api"
+)
+
+// Encode a big integer for a JWK
+func jwkInt(i *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, size)))
+}
+
+// Build a JWKS with the public keys
+func jwks(t *testing.T, keys map[string]interface{}) []byte {
+	set := []map[string]string{}
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			set = append(set, map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": jwkInt(k.N, k.Size()), "e": jwkInt(big.NewInt(int64(k.E)), 3)})
+		case *ecdsa.PrivateKey:
+			set = append(set, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": jwkInt(k.X, 32), "y": jwkInt(k.Y, 32)})
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": set})
+	require.NoError(t, err)
+	return data
+}
+
+// Sign a token with the key, with valid issuer, audience and expiry unless overridden by the claims
+func signToken(t *testing.T, kid string, key interface{}, claims jwt.MapClaims) string {
+	all := jwt.MapClaims{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		method = jwt.SigningMethodES256
+	}
+	token := jwt.NewWithClaims(method, all)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+// jwksServer serves a JWKS after the delay, counting the fetches
+type jwksServer struct {
+	server  *httptest.Server
+	mu      sync.Mutex
+	data    []byte
+	delay   time.Duration
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, data []byte) *jwksServer {
+	s := &jwksServer{data: data}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		time.Sleep(s.delay)
+		_, _ = w.Write(s.data)
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// Register an endpoint answering with the principal's subject
+func registerWhoAmI(t *testing.T, ctx context.Context, adapter *httpadapter.HttpReceiver, path string, middlewares ...comms.Middleware) {
+	err := adapter.RegisterEndPoint(ctx, path, func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		principal, ok := comms.PrincipalFromContext(ctx)
+		require.True(t, ok)
+		return ctx, httpadapter.WriteJSON(w, httpadapter.StatusOK, map[string]interface{}{"subject": principal.Subject, "method": principal.Method, "scopes": principal.Scopes})
+	}, middlewares...)
+	require.NoError(t, err)
+}
+
+// Send a GET with the header and return the status
+func getWithHeader(t *testing.T, url string, key string, value string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if key != "" {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAuthenticate_JWT(t *testing.T) {
+	ctx := initializeTelemetry()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keys := newJWKSServer(t, jwks(t, map[string]interface{}{"rsa-1": rsaKey, "ec-1": ecKey}))
+
+	authenticator, err := httpadapter.NewJWTAuthenticator(httpadapter.JWTOptions{Issuer: testIssuer, Audience: testAudience, JWKSURL: keys.server.URL})
+	require.NoError(t, err)
+
+	adapter, server := newMiddlewareServer(t, ctx)
+	registerWhoAmI(t, ctx, adapter, "/me", httpadapter.Authenticate(authenticator))
+
+	// Valid RSA and ECDSA tokens, the keys are fetched once
+	for kid, key := range map[string]interface{}{"rsa-1": rsaKey, "ec-1": ecKey} {
+		resp := getWithHeader(t, server.URL+"/me", "Authorization", "Bearer "+signToken(t, kid, key, jwt.MapClaims{"scope": "orders.read orders.write"}))
+		assert.Equal(t, http.StatusOK, resp.StatusCode, kid)
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "user-1", body["subject"])
+		assert.Equal(t, "jwt", body["method"])
+		assert.Equal(t, []interface{}{"orders.read", "orders.write"}, body["scopes"])
+	}
+	assert.Equal(t, 1, keys.fetches)
+
+	// Missing and invalid tokens are rejected
+	resp := getWithHeader(t, server.URL+"/me", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	invalid := map[string]string{
+		"expired":        signToken(t, "rsa-1", rsaKey, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+		"wrong issuer":   signToken(t, "rsa-1", rsaKey, jwt.MapClaims{"iss": "https://evil.example.com"}),
+		"wrong audience": signToken(t, "rsa-1", rsaKey, jwt.MapClaims{"aud": "billing-api"}),
+		"wrong key":      signToken(t, "rsa-1", otherKey, nil),
+		"unknown key":    signToken(t, "rsa-2", otherKey, nil),
+		"malformed":      "not-a-token",
+	}
+	for name, token := range invalid {
+		resp := getWithHeader(t, server.URL+"/me", "Authorization", "Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, name)
+		assert.Equal(t, `Bearer error="invalid_token"`, resp.Header.Get("WWW-Authenticate"), name)
+	}
+}
+
+func TestAuthenticate_JWTKeyRotation(t *testing.T) {
+	ctx := initializeTelemetry()
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(file, jwks(t, map[string]interface{}{"old": oldKey}), 0o600))
+
+	authenticator, err := httpadapter.NewJWTAuthenticator(httpadapter.JWTOptions{Issuer: testIssuer, Audience: testAudience, JWKSFile: file, MinRefreshInterval: time.Nanosecond})
+	require.NoError(t, err)
+
+	adapter, server := newMiddlewareServer(t, ctx)
+	registerWhoAmI(t, ctx, adapter, "/me", httpadapter.Authenticate(authenticator))
+	assert.Equal(t, http.StatusOK, getWithHeader(t, server.URL+"/me", "Authorization", "Bearer "+signToken(t, "old", oldKey, nil)).StatusCode)
+
+	// A token signed with a new key reloads the keys
+	require.NoError(t, os.WriteFile(file, jwks(t, map[string]interface{}{"old": oldKey, "new": newKey}), 0o600))
+	assert.Equal(t, http.StatusOK, getWithHeader(t, server.URL+"/me", "Authorization", "Bearer "+signToken(t, "new", newKey, nil)).StatusCode)
+}
+
+func TestAuthenticate_JWTConcurrentLoads(t *testing.T) {
+	ctx := initializeTelemetry()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys := newJWKSServer(t, jwks(t, map[string]interface{}{"rsa-1": rsaKey}))
+	keys.delay = 100 * time.Millisecond
+
+	authenticator, err := httpadapter.NewJWTAuthenticator(httpadapter.JWTOptions{Issuer: testIssuer, Audience: testAudience, JWKSURL: keys.server.URL})
+	require.NoError(t, err)
+	adapter, server := newMiddlewareServer(t, ctx)
+	registerWhoAmI(t, ctx, adapter, "/me", httpadapter.Authenticate(authenticator))
+
+	// Concurrent requests share a single fetch
+	token := "Bearer " + signToken(t, "rsa-1", rsaKey, nil)
+	var wg sync.WaitGroup
+	statuses := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- getWithHeader(t, server.URL+"/me", "Authorization", token).StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		assert.Equal(t, http.StatusOK, status)
+	}
+
+	// Tokens with unknown key IDs don't fetch the keys again until the minimum refresh interval
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	for _, kid := range []string{"unknown-1", "unknown-2", "unknown-3"} {
+		resp := getWithHeader(t, server.URL+"/me", "Authorization", "Bearer "+signToken(t, kid, otherKey, nil))
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	assert.Equal(t, 1, keys.fetches)
+}
+
+func TestAuthenticate_APIKey(t *testing.T) {
+	ctx := initializeTelemetry()
+	authenticator, err := httpadapter.NewAPIKeyAuthenticator("X-API-Key", map[string]comms.Principal{
+		"key-1": {Subject: "billing", Roles: []string{"admin"}},
+		"key-2": {Subject: "reports", Roles: []string{"reader"}},
+	})
+	require.NoError(t, err)
+
+	adapter, server := newMiddlewareServer(t, ctx)
+	registerWhoAmI(t, ctx, adapter, "/admin", httpadapter.Authenticate(authenticator), httpadapter.RequireAnyRole("admin"))
+
+	assert.Equal(t, http.StatusOK, getWithHeader(t, server.URL+"/admin", "X-API-Key", "key-1").StatusCode)
+	assert.Equal(t, http.StatusForbidden, getWithHeader(t, server.URL+"/admin", "X-API-Key", "key-2").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, getWithHeader(t, server.URL+"/admin", "X-API-Key", "key-3").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, getWithHeader(t, server.URL+"/admin", "", "").StatusCode)
+}
+
+func TestAuthenticate_MultipleAuthenticatorsAndScopes(t *testing.T) {
+	ctx := initializeTelemetry()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys := newJWKSServer(t, jwks(t, map[string]interface{}{"rsa-1": key}))
+
+	jwtAuthenticator, err := httpadapter.NewJWTAuthenticator(httpadapter.JWTOptions{Issuer: testIssuer, Audience: testAudience, JWKSURL: keys.server.URL})
+	require.NoError(t, err)
+	apiKeyAuthenticator, err := httpadapter.NewAPIKeyAuthenticator("X-API-Key", map[string]comms.Principal{"key-1": {Subject: "billing", Scopes: []string{"orders.write"}}})
+	require.NoError(t, err)
+
+	adapter, server := newMiddlewareServer(t, ctx)
+	adapter.Use(httpadapter.Authenticate(jwtAuthenticator, apiKeyAuthenticator))
+	registerWhoAmI(t, ctx, adapter, "/orders", httpadapter.RequireScopes("orders.write"))
+
+	// Microsoft Entra ID tokens carry the scopes in "scp"
+	writer := signToken(t, "rsa-1", key, jwt.MapClaims{"scp": "orders.write"})
+	reader := signToken(t, "rsa-1", key, jwt.MapClaims{"scp": []string{"orders.read"}})
+	assert.Equal(t, http.StatusOK, getWithHeader(t, server.URL+"/orders", "Authorization", "Bearer "+writer).StatusCode)
+	assert.Equal(t, http.StatusForbidden, getWithHeader(t, server.URL+"/orders", "Authorization", "Bearer "+reader).StatusCode)
+	assert.Equal(t, http.StatusOK, getWithHeader(t, server.URL+"/orders", "X-API-Key", "key-1").StatusCode)
+}
+
+func TestNewJWTAuthenticator_InvalidOptions(t *testing.T) {
+	invalid := []httpadapter.JWTOptions{
+		{Audience: testAudience, JWKSURL: "https://keys"},
+		{Issuer: testIssuer, JWKSURL: "https://keys"},
+		{Issuer: testIssuer, Audience: testAudience},
+		{Issuer: testIssuer, Audience: testAudience, JWKSURL: "https://keys", JWKSFile: "jwks.json"},
+		{Issuer: testIssuer, Audience: testAudience, JWKSURL: "https://keys", Algorithms: []string{"HS256"}},
+		{Issuer: testIssuer, Audience: testAudience, JWKSURL: "https://keys", Algorithms: []string{"none"}},
+	}
+	for _, options := range invalid {
+		_, err := httpadapter.NewJWTAuthenticator(options)
+		assert.Error(t, err, options)
+	}
+}
@@ -0,0 +1,64 @@
+package httpadapter
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Longest body excerpt kept in a StatusError
+const maxErrorBodyExcerpt = 1024
+
+// StatusError is returned when the server answers with a status that isn't accepted as success.
+// Match it with errors.As:
+//
+//	var statusErr *httpadapter.StatusError
+//	if errors.As(err, &statusErr) && statusErr.StatusCode == httpadapter.StatusNotFound { ... }
+type StatusError struct {
+	StatusCode HTTPStatus
+	Header     http.Header
+	// Beginning of the response body, for diagnostics
+	Body string
+}
+
+// Create a status error from a response
+func newStatusError(resp *httpResponse) *StatusError {
+	body := resp.body
+	if len(body) > maxErrorBodyExcerpt {
+		body = body[:maxErrorBodyExcerpt]
+		// Don't cut a multi-byte character in half
+		for i := 0; i < utf8.UTFMax && len(body) > 0 && !utf8.Valid(body); i++ {
+			body = body[:len(body)-1]
+		}
+	}
+
+	return &StatusError{
+		StatusCode: HTTPStatus(resp.statusCode),
+		Header:     resp.header,
+		Body:       string(body),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned status %d %s", int(e.StatusCode), e.StatusCode.String())
+}
+
+// Check if the request can be retried: request timeouts, rate limiting and transient server errors
+func (e *StatusError) Retryable() bool {
+	switch e.StatusCode {
+	case StatusRequestTimeout, StatusTooManyRequests, StatusInternalServerError, StatusBadGateway, StatusServiceUnavailable, StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get the wait requested by the server's Retry-After header, zero if there's none
+func (e *StatusError) RetryAfter() time.Duration {
+	if e.Header == nil {
+		return 0
+	}
+
+	return parseRetryAfter(e.Header.Get("Retry-After"))
+}
@@ -0,0 +1,304 @@
+package httpadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+const (
+	// Paths of the health endpoints, unless set in HealthOptions
+	DefaultLivenessPath  = "/healthz"
+	DefaultReadinessPath = "/readyz"
+	// Longest time a health check can take before it's failed
+	DefaultHealthTimeout = 5 * time.Second
+	// How long the result of a health check is reused
+	DefaultHealthCacheTTL = 5 * time.Second
+)
+
+// Status of a health report and its checks
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+// HealthOptions configures the health endpoints, see WithHealth
+type HealthOptions struct {
+	// Checks of the readiness endpoint by name, e.g. {"eventhub": publisher, "cosmos": repository}
+	Readiness map[string]comms.HealthChecker
+	// Checks of the liveness endpoint by name. Usually none, a failing dependency shouldn't restart the process
+	Liveness map[string]comms.HealthChecker
+	// Paths of the endpoints, default to DefaultLivenessPath and DefaultReadinessPath
+	LivenessPath  string
+	ReadinessPath string
+	// Longest time a check can take, defaults to DefaultHealthTimeout
+	Timeout time.Duration
+	// How long the result of a check is reused, so frequent probes don't load the services.
+	// Defaults to DefaultHealthCacheTTL, negative disables caching
+	CacheTTL time.Duration
+}
+
+// HealthReport is the JSON body of the health endpoints
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+// HealthCheckResult is the outcome of a single check in a HealthReport
+type HealthCheckResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Expose liveness and readiness endpoints for probes, answering GET and HEAD with a HealthReport:
+// 200 when every check passes, 503 otherwise. Checks run concurrently, each one with the timeout
+func WithHealth(options HealthOptions) ReceiverOption {
+	return func(a *HttpReceiver) error {
+		if options.LivenessPath == "" {
+			options.LivenessPath = DefaultLivenessPath
+		}
+		if options.ReadinessPath == "" {
+			options.ReadinessPath = DefaultReadinessPath
+		}
+		if options.LivenessPath == options.ReadinessPath {
+			return errors.New("liveness and readiness paths must be different")
+		}
+		if options.Timeout == 0 {
+			options.Timeout = DefaultHealthTimeout
+		}
+		if options.Timeout < 0 {
+			return fmt.Errorf("invalid health check timeout: %s", options.Timeout)
+		}
+		if options.CacheTTL == 0 {
+			options.CacheTTL = DefaultHealthCacheTTL
+		}
+
+		liveness, err := newHealthChecks(options.Liveness, options)
+		if err != nil {
+			return err
+		}
+		readiness, err := newHealthChecks(options.Readiness, options)
+		if err != nil {
+			return err
+		}
+
+		// Registered once the receiver is initialized
+		a.healthEndpoints = map[string]*healthChecks{
+			options.LivenessPath:  liveness,
+			options.ReadinessPath: readiness,
+		}
+		return nil
+	}
+}
+
+// healthChecks runs a set of named checks for an endpoint
+type healthChecks struct {
+	timeout  time.Duration
+	cacheTTL time.Duration
+	checks   map[string]*cachedCheck
+}
+
+// cachedCheck keeps the last result of a check
+type cachedCheck struct {
+	checker comms.HealthChecker
+	mu      sync.Mutex
+	result  HealthCheckResult
+	err     error
+	checked bool
+}
+
+// Create the checks of an endpoint
+func newHealthChecks(checkers map[string]comms.HealthChecker, options HealthOptions) (*healthChecks, error) {
+	checks := make(map[string]*cachedCheck, len(checkers))
+	for name, checker := range checkers {
+		if name == "" || checker == nil {
+			return nil, fmt.Errorf("invalid health check: %q", name)
+		}
+		checks[name] = &cachedCheck{checker: checker}
+	}
+
+	return &healthChecks{timeout: options.Timeout, cacheTTL: options.CacheTTL, checks: checks}, nil
+}
+
+// Run every check and report the overall status
+func (h *healthChecks) run(ctx context.Context) HealthReport {
+	report := HealthReport{Status: HealthStatusOK}
+	if len(h.checks) == 0 {
+		return report
+	}
+
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]HealthCheckResult, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = h.checks[name].run(ctx, name, h.timeout, h.cacheTTL)
+		}(i, name)
+	}
+	wg.Wait()
+
+	report.Checks = make(map[string]HealthCheckResult, len(names))
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != HealthStatusOK {
+			report.Status = HealthStatusUnavailable
+		}
+	}
+
+	return report
+}
+
+// Run the check, unless its last result is still fresh. Concurrent probes wait for a single run
+func (c *cachedCheck) run(ctx context.Context, name string, timeout time.Duration, cacheTTL time.Duration) HealthCheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.checked && cacheTTL > 0 && time.Since(c.result.CheckedAt) < cacheTTL {
+		return c.result
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	err := checkHealth(checkCtx, c.checker)
+
+	result := HealthCheckResult{Status: HealthStatusOK, Duration: time.Since(startTime).String(), CheckedAt: startTime}
+	if err != nil {
+		result.Status = HealthStatusUnavailable
+		result.Error = err.Error()
+	}
+
+	// Log the changes of status, not every probe
+	if !c.checked || (err == nil) != (c.err == nil) {
+		xTelemetry := telemetry.GetXTelemetryClient(ctx)
+		if err != nil {
+			xTelemetry.Warn(ctx, "HTTPAdapter::Health::Check failed", telemetry.String("Check", name), telemetry.String("Error", err.Error()))
+		} else {
+			xTelemetry.Info(ctx, "HTTPAdapter::Health::Check passed", telemetry.String("Check", name))
+		}
+	}
+
+	c.result, c.err, c.checked = result, err, true
+	return result
+}
+
+// Run a check, failing it when it doesn't return within the context's deadline
+func checkHealth(ctx context.Context, checker comms.HealthChecker) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		done <- checker.CheckHealth(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("health check timed out: %w", ctx.Err())
+	}
+}
+
+// Answer a probe with the report of the checks
+func (h *healthChecks) handle(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+	report := h.run(ctx)
+
+	status := StatusOK
+	if report.Status != HealthStatusOK {
+		status = StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	return ctx, WriteJSON(w, status, report)
+}
+
+// Build the mux of the health endpoints configured with WithHealth. Probes don't go through the global
+// middleware, so authentication or rate limiting added with Use can't fail them, and take precedence
+// over the endpoints registered on the same paths
+func (a *HttpReceiver) buildHealthMux() error {
+	if len(a.healthEndpoints) == 0 {
+		return nil
+	}
+
+	routes := make(map[string]http.Handler, len(a.healthEndpoints))
+	for path, checks := range a.healthEndpoints {
+		handle := checks.handle
+		routes[http.MethodGet+" "+path] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = handle(a.requestContext(r), &responseWriterAdapter{w, http.StatusOK}, newRequestAdapter(w, r, a.maxBodyBytes))
+		})
+	}
+
+	mux, err := buildServeMux(routes)
+	if err != nil {
+		return err
+	}
+	a.healthMux = mux
+
+	return nil
+}
+
+// Answer the request if it's a health probe
+func (a *HttpReceiver) serveHealth(w http.ResponseWriter, r *http.Request) bool {
+	if a.healthMux == nil {
+		return false
+	}
+
+	// Other methods on the health paths are left to the global middleware and the endpoints
+	handler, pattern := a.healthMux.Handler(r)
+	if pattern == "" {
+		return false
+	}
+	handler.ServeHTTP(w, r)
+
+	return true
+}
+
+// Check the sender's connection to a service by calling the endpoint with GET, CheckHealth then passes
+// when the endpoint answers with a 2xx status
+func WithHealthEndpoint(endpoint comms.EndPoint) SenderOption {
+	return func(a *HttpSender) error {
+		if endpoint == nil {
+			return errors.New("health endpoint is nil")
+		}
+
+		a.healthEndpoint = endpoint
+		return nil
+	}
+}
+
+// Check the health endpoint configured with WithHealthEndpoint
+func (a *HttpSender) CheckHealth(ctx context.Context) error {
+	if a.healthEndpoint == nil {
+		return errors.New("no health endpoint configured")
+	}
+
+	resp, err := a.Call(ctx, http.MethodGet, a.healthEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return newStatusError(resp.(*httpResponse))
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package httpadapter_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Start a receiver with the health endpoints
+func newHealthServer(t *testing.T, ctx context.Context, options httpadapter.HealthOptions) *httptest.Server {
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithHealth(options))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(adapter)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Probe a health endpoint and decode its report
+func probe(t *testing.T, url string) (int, httpadapter.HealthReport) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var report httpadapter.HealthReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	return resp.StatusCode, report
+}
+
+func TestHealth_LivenessAndReadiness(t *testing.T) {
+	ctx := initializeTelemetry()
+	var cosmosErr atomic.Value
+	cosmosErr.Store("")
+	server := newHealthServer(t, ctx, httpadapter.HealthOptions{
+		Readiness: map[string]comms.HealthChecker{
+			"eventhub": comms.HealthCheckFunc(func(ctx context.Context) error { return nil }),
+			"cosmos": comms.HealthCheckFunc(func(ctx context.Context) error {
+				if message := cosmosErr.Load().(string); message != "" {
+					return errors.New(message)
+				}
+				return nil
+			}),
+		},
+		CacheTTL: -1,
+	})
+
+	status, report := probe(t, server.URL+httpadapter.DefaultLivenessPath)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, httpadapter.HealthStatusOK, report.Status)
+	assert.Empty(t, report.Checks)
+
+	status, report = probe(t, server.URL+httpadapter.DefaultReadinessPath)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, httpadapter.HealthStatusOK, report.Status)
+	require.Len(t, report.Checks, 2)
+	assert.Equal(t, httpadapter.HealthStatusOK, report.Checks["cosmos"].Status)
+	assert.False(t, report.Checks["cosmos"].CheckedAt.IsZero())
+
+	// A failing dependency makes the service unready, not dead
+	cosmosErr.Store("connection refused")
+	status, report = probe(t, server.URL+httpadapter.DefaultReadinessPath)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, httpadapter.HealthStatusUnavailable, report.Status)
+	assert.Equal(t, httpadapter.HealthStatusUnavailable, report.Checks["cosmos"].Status)
+	assert.Equal(t, "connection refused", report.Checks["cosmos"].Error)
+	assert.Equal(t, httpadapter.HealthStatusOK, report.Checks["eventhub"].Status)
+
+	status, _ = probe(t, server.URL+httpadapter.DefaultLivenessPath)
+	assert.Equal(t, http.StatusOK, status)
+
+	// Probes may use HEAD
+	resp, err := http.Head(server.URL + httpadapter.DefaultReadinessPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestHealth_BypassesGlobalMiddleware(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithHealth(httpadapter.HealthOptions{}))
+	require.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	// A single request allowed, and every request rejected by the authentication
+	limit, err := httpadapter.RateLimit(httpadapter.RateLimitOptions{Rate: 0.01, Burst: 1})
+	require.NoError(t, err)
+	adapter.Use(limit, func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			return ctx, httpadapter.WriteError(w, httpadapter.StatusUnauthorized, nil)
+		}
+	})
+
+	// Probes are neither limited nor authenticated
+	for i := 0; i < 3; i++ {
+		status, _ := probe(t, server.URL+httpadapter.DefaultLivenessPath)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = probe(t, server.URL+httpadapter.DefaultReadinessPath)
+		assert.Equal(t, http.StatusOK, status)
+	}
+
+	// Other requests still are, including other methods on the health paths
+	resp, err := http.Post(server.URL+httpadapter.DefaultReadinessPath, "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, err = http.Get(server.URL + "/orders")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestHealth_TimeoutAndCache(t *testing.T) {
+	ctx := initializeTelemetry()
+	var calls atomic.Int32
+	server := newHealthServer(t, ctx, httpadapter.HealthOptions{
+		Liveness: map[string]comms.HealthChecker{
+			"slow": comms.HealthCheckFunc(func(ctx context.Context) error {
+				calls.Add(1)
+				// Ignores the context, the check is failed anyway
+				time.Sleep(200 * time.Millisecond)
+				return nil
+			}),
+		},
+		LivenessPath: "/live",
+		Timeout:      20 * time.Millisecond,
+		CacheTTL:     time.Minute,
+	})
+
+	startTime := time.Now()
+	status, report := probe(t, server.URL+"/live")
+	assert.Less(t, time.Since(startTime), 150*time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Contains(t, report.Checks["slow"].Error, "timed out")
+
+	// The result is reused until it expires
+	status, _ = probe(t, server.URL+"/live")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestHealth_InvalidOptions(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	for _, options := range []httpadapter.HealthOptions{
+		{LivenessPath: "/health", ReadinessPath: "/health"},
+		{Timeout: -time.Second},
+		{Readiness: map[string]comms.HealthChecker{"cosmos": nil}},
+		{ReadinessPath: "not a path"},
+	} {
+		_, err := httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithHealth(options))
+		assert.Error(t, err)
+	}
+}
+
+func TestSender_CheckHealth(t *testing.T) {
+	ctx := initializeTelemetry()
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		if healthy.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/healthz")
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithHealthEndpoint(endpoint))
+	require.NoError(t, err)
+
+	var statusErr *httpadapter.StatusError
+	require.True(t, errors.As(sender.CheckHealth(ctx), &statusErr))
+	assert.Equal(t, httpadapter.StatusServiceUnavailable, statusErr.StatusCode)
+
+	healthy.Store(true)
+	assert.NoError(t, sender.CheckHealth(ctx))
+
+	// The sender can be a readiness check of a receiver
+	var checker comms.HealthChecker = sender
+	receiver := newHealthServer(t, ctx, httpadapter.HealthOptions{Readiness: map[string]comms.HealthChecker{"orders": checker}})
+	status, _ := probe(t, receiver.URL+httpadapter.DefaultReadinessPath)
+	assert.Equal(t, http.StatusOK, status)
+
+	unconfigured, err := httpadapter.HttpSenderInit(ctx)
+	require.NoError(t, err)
+	assert.Error(t, unconfigured.CheckHealth(ctx))
+	_, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithHealthEndpoint(nil))
+	assert.Error(t, err)
+}
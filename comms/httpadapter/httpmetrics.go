@@ -0,0 +1,101 @@
+package httpadapter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/metrics"
+)
+
+// Path of the metrics endpoint, unless set in MetricsOptions
+const DefaultMetricsPath = "/metrics"
+
+// MetricsOptions configures the metrics of a receiver, see WithMetrics
+type MetricsOptions struct {
+	// Registry the endpoints record into and the metrics endpoint exposes, defaults to metrics.Default,
+	// where the Event Hub and Cosmos DB adapters record
+	Registry *metrics.Registry
+	// Path of the metrics endpoint, defaults to DefaultMetricsPath
+	Path string
+}
+
+// receiverMetrics are the metrics of the receiver's endpoints
+type receiverMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inFlight *metrics.GaugeVec
+}
+
+// Record every request (count and latency per endpoint, method and status, and requests in flight), including
+// the ones rejected by the global middleware or matching no endpoint, and expose the registry in the Prometheus text format, e.g. for a scrape at GET /metrics
+func WithMetrics(options MetricsOptions) ReceiverOption {
+	return func(a *HttpReceiver) error {
+		if options.Registry == nil {
+			options.Registry = metrics.Default
+		}
+		if options.Path == "" {
+			options.Path = DefaultMetricsPath
+		}
+		if options.Path[0] != '/' {
+			return errors.New("metrics path must start with /: " + options.Path)
+		}
+
+		a.metrics = &receiverMetrics{
+			registry: options.Registry,
+			requests: options.Registry.Counter("http_server_requests_total", "Requests handled by the HTTP receiver", "endpoint", "method", "status"),
+			duration: options.Registry.Histogram("http_server_request_duration_seconds", "Latency of the requests handled by the HTTP receiver", nil, "endpoint", "method", "status"),
+			inFlight: options.Registry.Gauge("http_server_requests_in_flight", "Requests being handled by the HTTP receiver", "endpoint"),
+		}
+		a.metricsPath = options.Path
+		return nil
+	}
+}
+
+// Endpoint label of the requests that match no endpoint, e.g. answered with 404 or 405
+const unmatchedEndpoint = "unmatched"
+
+// Start recording a request to an endpoint, labeled with the pattern it was registered with.
+// The returned function records the request once answered
+func (m *receiverMetrics) start(endpoint string, method string) func(status int) {
+	inFlight := m.inFlight.With(endpoint)
+	inFlight.Inc()
+	startTime := time.Now()
+	method = methodLabel(method)
+
+	return func(status int) {
+		inFlight.Dec()
+		m.requests.With(endpoint, method, strconv.Itoa(status)).Inc()
+		m.duration.With(endpoint, method, strconv.Itoa(status)).ObserveSince(startTime)
+	}
+}
+
+// Method label of a request, unknown methods share a label so clients can't add labels at will
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+
+	return "other"
+}
+
+// Answer a scrape with the registry
+func (m *receiverMetrics) handle(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+	var body bytes.Buffer
+	if err := m.registry.Write(&body); err != nil {
+		_ = WriteError(w, StatusInternalServerError, nil)
+		return ctx, err
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(body.Bytes())
+	return ctx, err
+}
@@ -0,0 +1,135 @@
+package httpadapter_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Get the metrics exposed by the receiver
+func scrapeMetrics(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, metrics.ContentType, resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics_Endpoints(t *testing.T) {
+	ctx := initializeTelemetry()
+	registry := metrics.NewRegistry()
+	registry.Counter("orders_created_total", "Orders created").With().Inc()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithMetrics(httpadapter.MetricsOptions{Registry: registry}))
+	require.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	err = adapter.RegisterRoute(ctx, http.MethodGet, "/orders/{id}", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		if r.PathParam("id") == "missing" {
+			return ctx, httpadapter.WriteError(w, httpadapter.StatusNotFound, nil)
+		}
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "missing"} {
+		resp, err := http.Get(server.URL + "/orders/" + id)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Requests are labeled with the registered pattern, not the path
+	output := scrapeMetrics(t, server.URL+httpadapter.DefaultMetricsPath)
+	assert.Contains(t, output, `http_server_requests_total{endpoint="GET /orders/{id}",method="GET",status="200"} 2`)
+	assert.Contains(t, output, `http_server_requests_total{endpoint="GET /orders/{id}",method="GET",status="404"} 1`)
+	assert.Contains(t, output, `http_server_request_duration_seconds_count{endpoint="GET /orders/{id}",method="GET",status="200"} 2`)
+	assert.Contains(t, output, `http_server_requests_in_flight{endpoint="GET /orders/{id}"} 0`)
+	assert.Contains(t, output, "# TYPE http_server_request_duration_seconds histogram")
+	// Metrics recorded by the application are exposed too
+	assert.Contains(t, output, "orders_created_total 1")
+
+	// The scrape itself is counted once it's done
+	output = scrapeMetrics(t, server.URL+httpadapter.DefaultMetricsPath)
+	assert.Contains(t, output, `http_server_requests_total{endpoint="GET /metrics",method="GET",status="200"} 1`)
+}
+
+func TestMetrics_RejectedAndUnmatchedRequests(t *testing.T) {
+	ctx := initializeTelemetry()
+	registry := metrics.NewRegistry()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithMetrics(httpadapter.MetricsOptions{Registry: registry}), httpadapter.WithHealth(httpadapter.HealthOptions{}))
+	require.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	err = adapter.RegisterRoute(ctx, http.MethodPost, "/orders", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusCreated)
+		return ctx, nil
+	})
+	require.NoError(t, err)
+
+	// Requests without a token are rejected by the global middleware
+	adapter.Use(func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			if r.Header("Authorization") == "" && r.URL().Path != httpadapter.DefaultMetricsPath {
+				return ctx, httpadapter.WriteError(w, httpadapter.StatusUnauthorized, nil)
+			}
+			return next(ctx, w, r)
+		}
+	})
+
+	send := func(method string, path string, authorization string) {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		require.NoError(t, err)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	send(http.MethodPost, "/orders", "Bearer token")
+	send(http.MethodPost, "/orders", "")
+	send(http.MethodGet, "/orders", "Bearer token")
+	send(http.MethodGet, "/unknown/1", "Bearer token")
+	send(http.MethodGet, "/unknown/2", "Bearer token")
+	send("BREW", "/orders", "Bearer token")
+	send(http.MethodGet, httpadapter.DefaultLivenessPath, "")
+
+	output := scrapeMetrics(t, server.URL+httpadapter.DefaultMetricsPath)
+	assert.Contains(t, output, `http_server_requests_total{endpoint="POST /orders",method="POST",status="201"} 1`)
+	assert.Contains(t, output, `http_server_requests_total{endpoint="POST /orders",method="POST",status="401"} 1`)
+	// Requests matching no endpoint share a label, whatever their path or method
+	assert.Contains(t, output, `http_server_requests_total{endpoint="unmatched",method="GET",status="405"} 1`)
+	assert.Contains(t, output, `http_server_requests_total{endpoint="unmatched",method="GET",status="404"} 2`)
+	assert.Contains(t, output, `http_server_requests_total{endpoint="unmatched",method="other",status="405"} 1`)
+	// Health probes are counted too
+	assert.Contains(t, output, `http_server_requests_total{endpoint="GET /healthz",method="GET",status="200"} 1`)
+}
+
+func TestMetrics_Options(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithMetrics(httpadapter.MetricsOptions{Registry: metrics.NewRegistry(), Path: "/internal/metrics"}))
+	require.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+	assert.Contains(t, scrapeMetrics(t, server.URL+"/internal/metrics"), "http_server_requests_total")
+
+	_, err = httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithMetrics(httpadapter.MetricsOptions{Path: "metrics"}))
+	assert.Error(t, err)
+}
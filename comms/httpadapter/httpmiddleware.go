@@ -0,0 +1,421 @@
+package httpadapter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// Header carrying the request ID, read from the request and echoed in the response by RequestID
+const RequestIDHeader = "X-Request-ID"
+
+// Log every request to the endpoint as a telemetry request, with the status and the handler's error.
+// The handler's context continues the caller's trace, see comms.ExtractTrace
+func requestTelemetry(endpointPath string) comms.Middleware {
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+			// Continue the caller's trace, keeping the OperationID set by request middleware such as RequestID
+			ctx = comms.ExtractTrace(ctx, r.Headers())
+
+			// Get service name from context, if the application has set it
+			serviceName, _ := ctx.Value(telemetry.ServiceNameContextKey).(string)
+
+			startTime := time.Now()
+			// Call the original handler
+			newCtx, err := next(ctx, w, r)
+			if newCtx == nil {
+				newCtx = ctx
+			}
+
+			// Decide on the message based on the error
+			message := ""
+			if err == nil {
+				message = "Request processed successfully"
+			} else {
+				message = err.Error()
+			}
+
+			// Log telemetry after calling the original handler
+			statusCode := w.Status()
+			success := isSuccess(statusCode)
+			xTelemetry.Request(newCtx, r.Method(), endpointPath, startTime, time.Now(), strconv.Itoa(statusCode), success, serviceName, message)
+
+			return newCtx, err
+		}
+	}
+}
+
+// Recover from panics in the handler, answering 500 (if nothing was written yet) instead of dropping the connection
+func Recover() comms.Middleware {
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (newCtx context.Context, err error) {
+			tw := &trackingWriter{ResponseWriter: w}
+
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("panic: %v", p)
+					newCtx = ctx
+
+					xTelemetry := telemetry.GetXTelemetryClient(ctx)
+					xTelemetry.Error(ctx, "HTTPAdapter::Recover::Handler panicked", telemetry.String("Error", err.Error()), telemetry.String("Stack", string(debug.Stack())))
+
+					if !tw.written {
+						_ = WriteError(w, StatusInternalServerError, nil)
+					}
+				}
+			}()
+
+			return next(ctx, tw, r)
+		}
+	}
+}
+
+// trackingWriter records whether the status or the body were written
+type trackingWriter struct {
+	comms.ResponseWriter
+	written bool
+}
+
+func (t *trackingWriter) Write(data []byte) (int, error) {
+	t.written = true
+	return t.ResponseWriter.Write(data)
+}
+
+func (t *trackingWriter) WriteHeader(statusCode int) {
+	t.written = true
+	t.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Cancel the handler's context after the timeout, answering 503 and returning http.ErrHandlerTimeout.
+// The response is buffered until the handler returns, writes after the timeout fail with http.ErrHandlerTimeout
+func Timeout(timeout time.Duration) comms.Middleware {
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			type result struct {
+				ctx   context.Context
+				err   error
+				panic interface{}
+			}
+
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan result, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						done <- result{panic: p}
+					}
+				}()
+				newCtx, err := next(timeoutCtx, tw, r)
+				done <- result{ctx: newCtx, err: err}
+			}()
+
+			select {
+			case res := <-done:
+				if res.panic != nil {
+					// Raise the panic in the caller's goroutine, so it can be handled by Recover
+					panic(res.panic)
+				}
+				tw.flush(w)
+				return res.ctx, res.err
+
+			case <-timeoutCtx.Done():
+				tw.mu.Lock()
+				tw.timedOut = true
+				tw.mu.Unlock()
+
+				xTelemetry := telemetry.GetXTelemetryClient(ctx)
+				xTelemetry.Error(ctx, "HTTPAdapter::Timeout::Handler timed out", telemetry.String("Path", r.URL().Path), telemetry.String("Timeout", timeout.String()))
+
+				_ = WriteError(w, StatusServiceUnavailable, http.ErrHandlerTimeout)
+				return ctx, http.ErrHandlerTimeout
+			}
+		}
+	}
+}
+
+// timeoutWriter buffers the response of a handler running with a timeout
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (t *timeoutWriter) Header() comms.Header {
+	return t.header
+}
+
+func (t *timeoutWriter) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !t.wroteHeader {
+		t.status = http.StatusOK
+		t.wroteHeader = true
+	}
+
+	return t.body.Write(data)
+}
+
+func (t *timeoutWriter) WriteHeader(statusCode int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timedOut || t.wroteHeader {
+		return
+	}
+	t.status = statusCode
+	t.wroteHeader = true
+}
+
+func (t *timeoutWriter) Status() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.wroteHeader {
+		return http.StatusOK
+	}
+
+	return t.status
+}
+
+// Copy the buffered response to the writer
+func (t *timeoutWriter) flush(w comms.ResponseWriter) {
+	for key, values := range t.header {
+		w.Header().Del(key)
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	if t.wroteHeader {
+		w.WriteHeader(t.status)
+		_, _ = w.Write(t.body.Bytes())
+	}
+}
+
+// Use the request's X-Request-ID as OperationID, generating one when it's missing or invalid (see
+// comms.IsValidOperationID), and echo it in the response
+func RequestID() comms.Middleware {
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			requestID := r.Header(RequestIDHeader)
+			if !comms.IsValidOperationID(requestID) {
+				requestID = uuid.New().String()
+			}
+
+			w.Header().Set(RequestIDHeader, requestID)
+			ctx = comms.WithRequestOperationID(ctx, requestID)
+
+			return next(ctx, w, r)
+		}
+	}
+}
+
+// Log every request with method, path, status and duration
+func AccessLog() comms.Middleware {
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			startTime := time.Now()
+			newCtx, err := next(ctx, w, r)
+			if newCtx == nil {
+				newCtx = ctx
+			}
+
+			errorMessage := ""
+			if err != nil {
+				errorMessage = err.Error()
+			}
+
+			xTelemetry := telemetry.GetXTelemetryClient(newCtx)
+			xTelemetry.Info(newCtx, "HTTPAdapter::AccessLog",
+				telemetry.String("Method", r.Method()),
+				telemetry.String("Path", r.URL().Path),
+				telemetry.String("RemoteAddr", r.RemoteAddr()),
+				telemetry.String("Status", strconv.Itoa(w.Status())),
+				telemetry.String("Duration", time.Since(startTime).String()),
+				telemetry.String("Error", errorMessage))
+
+			return newCtx, err
+		}
+	}
+}
+
+// CORSOptions configures the CORS middleware
+type CORSOptions struct {
+	// Origins allowed to call the receiver, "*" allows any origin
+	AllowedOrigins []string
+	// Methods allowed in preflight requests, defaults to GET, HEAD, POST, PUT, PATCH and DELETE
+	AllowedMethods []string
+	// Headers allowed in preflight requests, defaults to the headers requested by the preflight
+	AllowedHeaders []string
+	// Response headers exposed to the browser
+	ExposedHeaders []string
+	// Allow cookies and credentials, the origin is then echoed instead of "*"
+	AllowCredentials bool
+	// How long the browser can cache a preflight response, not sent if zero
+	MaxAge time.Duration
+}
+
+// Answer CORS preflight requests with 204 and add the CORS headers to the responses of allowed origins.
+// Add it with Use, so preflight requests are answered even for endpoints registered for other methods
+func CORS(options CORSOptions) comms.Middleware {
+	allowedMethods := options.AllowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+
+	allowAnyOrigin := false
+	allowedOrigins := make(map[string]bool, len(options.AllowedOrigins))
+	for _, origin := range options.AllowedOrigins {
+		if origin == "*" {
+			allowAnyOrigin = true
+		}
+		allowedOrigins[strings.ToLower(origin)] = true
+	}
+
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			origin := r.Header("Origin")
+			if origin == "" {
+				return next(ctx, w, r)
+			}
+
+			w.Header().Add("Vary", "Origin")
+			if !allowAnyOrigin && !allowedOrigins[strings.ToLower(origin)] {
+				return next(ctx, w, r)
+			}
+
+			if allowAnyOrigin && !options.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if options.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// Answer the preflight without calling the handler
+			if r.Method() == http.MethodOptions && r.Header("Access-Control-Request-Method") != "" {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+				if len(options.AllowedHeaders) > 0 {
+					w.Header().Set("Access-Control-Allow-Headers", strings.Join(options.AllowedHeaders, ", "))
+				} else if requested := r.Header("Access-Control-Request-Headers"); requested != "" {
+					w.Header().Set("Access-Control-Allow-Headers", requested)
+				}
+				if options.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return ctx, nil
+			}
+
+			if len(options.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+			}
+
+			return next(ctx, w, r)
+		}
+	}
+}
+
+// Limit the request body to maxBytes, answering 413 when the declared length is larger.
+// Reading a larger body through comms.Request fails with comms.ErrBodyTooLarge
+func BodyLimit(maxBytes int64) comms.Middleware {
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			if contentLength, err := strconv.ParseInt(r.Header("Content-Length"), 10, 64); err == nil && contentLength > maxBytes {
+				_ = WriteError(w, StatusRequestEntityTooLarge, comms.ErrBodyTooLarge)
+				return ctx, comms.ErrBodyTooLarge
+			}
+
+			return next(ctx, w, &limitedRequest{Request: r, maxBytes: maxBytes})
+		}
+	}
+}
+
+// limitedRequest limits the body of a request
+type limitedRequest struct {
+	comms.Request
+	maxBytes int64
+	body     []byte
+	bodyErr  error
+	read     bool
+}
+
+// Get the whole body, nil if it can't be read
+func (r *limitedRequest) Body() []byte {
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil
+	}
+
+	return body
+}
+
+// Read the whole body, the result is kept so the body can be read more than once
+func (r *limitedRequest) ReadBody() ([]byte, error) {
+	if !r.read {
+		r.read = true
+		r.body, r.bodyErr = io.ReadAll(&limitedReader{r.Request.BodyReader(), r.maxBytes})
+	}
+
+	return r.body, r.bodyErr
+}
+
+// Stream the body. Once the body has been read with Body or ReadBody, the cached copy is returned
+func (r *limitedRequest) BodyReader() io.Reader {
+	if r.read {
+		return bytes.NewReader(r.body)
+	}
+
+	return &limitedReader{r.Request.BodyReader(), r.maxBytes}
+}
+
+// limitedReader fails with comms.ErrBodyTooLarge when the reader has more than the remaining bytes
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Read one more byte to tell the end of the body from a larger body
+		var probe [1]byte
+		n, err := l.reader.Read(probe[:])
+		if n > 0 {
+			return 0, comms.ErrBodyTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+
+	return n, err
+}
@@ -0,0 +1,263 @@
+package httpadapter_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goutils/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+// Start a receiver behind a test server
+func newMiddlewareServer(t *testing.T, ctx context.Context) (*httpadapter.HttpReceiver, *httptest.Server) {
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "0")
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(adapter)
+	t.Cleanup(server.Close)
+
+	return adapter, server
+}
+
+// Middleware that appends its name to the X-Trace response header
+func traceMiddleware(name string) comms.Middleware {
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			w.Header().Add("X-Trace", name)
+			return next(ctx, w, r)
+		}
+	}
+}
+
+func TestChain_Order(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	adapter.Use(traceMiddleware("global-1"), traceMiddleware("global-2"))
+	err := adapter.RegisterEndPoint(ctx, "/chain", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.Header().Add("X-Trace", "handler")
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	}, traceMiddleware("route-1"), traceMiddleware("route-2"))
+	assert.NoError(t, err)
+
+	resp, err := http.Get(server.URL + "/chain")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"global-1", "global-2", "route-1", "route-2", "handler"}, resp.Header.Values("X-Trace"))
+
+	// Global middleware also runs for requests that match no endpoint
+	notFound, err := http.Get(server.URL + "/missing")
+	assert.NoError(t, err)
+	defer notFound.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, notFound.StatusCode)
+	assert.Equal(t, []string{"global-1", "global-2"}, notFound.Header.Values("X-Trace"))
+}
+
+func TestRecover(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	err := adapter.RegisterEndPoint(ctx, "/panic", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		panic("boom")
+	}, httpadapter.Recover())
+	assert.NoError(t, err)
+
+	resp, err := http.Get(server.URL + "/panic")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, httpadapter.ContentTypeProblem, resp.Header.Get("Content-Type"))
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	err := adapter.RegisterEndPoint(ctx, "/slow", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		<-ctx.Done()
+		w.WriteHeader(http.StatusOK)
+		return ctx, ctx.Err()
+	}, httpadapter.Timeout(50*time.Millisecond))
+	assert.NoError(t, err)
+
+	err = adapter.RegisterEndPoint(ctx, "/fast", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.Header().Set("X-Fast", "true")
+		w.WriteHeader(http.StatusCreated)
+		_, err := w.Write([]byte("done"))
+		return ctx, err
+	}, httpadapter.Timeout(time.Second))
+	assert.NoError(t, err)
+
+	resp, err := http.Get(server.URL + "/slow")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/fast")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("X-Fast"))
+	assert.Equal(t, "done", string(body))
+}
+
+func TestRequestID(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	var operationID string
+	adapter.Use(httpadapter.RequestID())
+	err := adapter.RegisterEndPoint(ctx, "/id", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		operationID, _ = ctx.Value(telemetry.OperationIDKeyContextKey).(string)
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+
+	// The client's request ID is the OperationID
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/id", nil)
+	req.Header.Set(httpadapter.RequestIDHeader, "client-id")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "client-id", resp.Header.Get(httpadapter.RequestIDHeader))
+	assert.Equal(t, "client-id", operationID)
+
+	// Otherwise one is generated
+	resp, err = http.Get(server.URL + "/id")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.NotEmpty(t, resp.Header.Get(httpadapter.RequestIDHeader))
+	assert.Equal(t, resp.Header.Get(httpadapter.RequestIDHeader), operationID)
+
+	// Invalid IDs aren't logged nor echoed
+	for _, invalid := range []string{"id\tforged=1", "id with spaces", "id;evil", strings.Repeat("a", 129)} {
+		req, _ = http.NewRequest(http.MethodGet, server.URL+"/id", nil)
+		req.Header.Set(httpadapter.RequestIDHeader, invalid)
+		resp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.NotEqual(t, invalid, operationID)
+		assert.Equal(t, resp.Header.Get(httpadapter.RequestIDHeader), operationID)
+		_, err = uuid.Parse(operationID)
+		assert.NoError(t, err)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	adapter.Use(httpadapter.AccessLog())
+	err := adapter.RegisterEndPoint(ctx, "/logged", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusBadRequest)
+		return ctx, errors.New("bad request")
+	})
+	assert.NoError(t, err)
+
+	resp, err := http.Get(server.URL + "/logged")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestCORS(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	adapter.Use(httpadapter.CORS(httpadapter.CORSOptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+		ExposedHeaders: []string{"X-Request-ID"},
+		MaxAge:         time.Hour,
+	}))
+	err := adapter.RegisterRoute(ctx, http.MethodPost, "/orders", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusCreated)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+
+	// Preflight is answered, even if the endpoint is only registered for POST
+	req, _ := http.NewRequest(http.MethodOptions, server.URL+"/orders", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, resp.Header.Get("Access-Control-Allow-Methods"), http.MethodPost)
+	assert.Equal(t, "Content-Type", resp.Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", resp.Header.Get("Access-Control-Max-Age"))
+
+	// Actual request
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/orders", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-ID", resp.Header.Get("Access-Control-Expose-Headers"))
+
+	// Other origins get no CORS headers
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/orders", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestBodyLimit(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	var readErr error
+	err := adapter.RegisterEndPoint(ctx, "/upload", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		_, readErr = r.ReadBody()
+		if readErr != nil {
+			return ctx, httpadapter.WriteError(w, httpadapter.StatusRequestEntityTooLarge, readErr)
+		}
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	}, httpadapter.BodyLimit(8))
+	assert.NoError(t, err)
+
+	// Within the limit
+	resp, err := http.Post(server.URL+"/upload", "text/plain", strings.NewReader("12345678"))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, readErr)
+
+	// Declared length over the limit, the handler isn't called
+	readErr = nil
+	resp, err = http.Post(server.URL+"/upload", "text/plain", strings.NewReader("123456789"))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.NoError(t, readErr)
+
+	// Chunked body over the limit, the handler gets comms.ErrBodyTooLarge
+	resp, err = http.Post(server.URL+"/upload", "text/plain", io.MultiReader(strings.NewReader("12345"), strings.NewReader("6789")))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.ErrorIs(t, readErr, comms.ErrBodyTooLarge)
+}
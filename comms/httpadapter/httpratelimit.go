@@ -0,0 +1,352 @@
+package httpadapter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// Returned when a request is rejected, or can't wait for its turn, because of a rate limit
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// How often the receiver drops the buckets of idle clients
+const rateLimitSweepInterval = time.Minute
+
+const (
+	// Longest a sender request waits for a host paused by Retry-After, longer pauses fail the request right away
+	maxRetryAfterWait = 5 * time.Second
+	// Longest pause of a host, longer Retry-After values are capped
+	maxRetryAfterPause = time.Hour
+)
+
+// RateLimitedError is returned by a rate limited sender for the requests that can't wait for their turn,
+// it matches ErrRateLimited. Callers that can reschedule the request (e.g. a webhook dispatcher) get the wait
+type RateLimitedError struct {
+	Wait time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return ErrRateLimited.Error() + ": retry after " + e.Wait.String()
+}
+
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Get the wait before the request can be sent
+func (e *RateLimitedError) RetryAfter() time.Duration {
+	return e.Wait
+}
+
+// tokenBucket allows rate requests per second, with bursts of up to burst requests. It isn't safe for concurrent use
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Create a full bucket
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// Add the tokens earned since the last call
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// Take a token if there's one, otherwise get the wait until there is
+func (b *tokenBucket) allow(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, b.wait()
+}
+
+// Take a token even if it has to be earned first, and get the wait until then
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return b.wait()
+}
+
+// Give back a reserved token that wasn't used
+func (b *tokenBucket) cancel() {
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// Get the wait until the bucket has a whole token
+func (b *tokenBucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Check if the bucket is full, a new bucket would behave the same
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Check the rate and burst of a limit
+func validateRateLimit(rate float64, burst int) error {
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return fmt.Errorf("invalid rate: %v", rate)
+	}
+	if burst < 1 {
+		return fmt.Errorf("invalid burst: %d", burst)
+	}
+
+	return nil
+}
+
+// Format a wait as Retry-After seconds, rounded up
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// Parse a Retry-After header, either seconds or an HTTP date. Zero if missing or invalid
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
+
+// RateLimitOptions configures the RateLimit middleware
+type RateLimitOptions struct {
+	// Requests per second allowed for every key
+	Rate float64
+	// Requests allowed at once, above the rate. Defaults to the rate rounded up
+	Burst int
+	// Key of the client, requests with the same key share a limit, e.g. ClientIPKey or PrincipalKey.
+	// Nil limits all the requests together
+	Key func(ctx context.Context, r comms.Request) string
+}
+
+// Limit the requests with a token bucket per key, answering 429 with Retry-After when the limit is exceeded.
+// Add it with Use to limit every endpoint, or pass it to RegisterEndPoint to limit a single route
+func RateLimit(options RateLimitOptions) (comms.Middleware, error) {
+	if options.Burst == 0 && options.Rate > 0 {
+		options.Burst = int(math.Ceil(options.Rate))
+	}
+	if err := validateRateLimit(options.Rate, options.Burst); err != nil {
+		return nil, err
+	}
+
+	limiter := &keyedLimiter{
+		rate:      options.Rate,
+		burst:     options.Burst,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			key := ""
+			if options.Key != nil {
+				key = options.Key(ctx, r)
+			}
+
+			allowed, wait := limiter.allow(key, time.Now())
+			if !allowed {
+				xTelemetry := telemetry.GetXTelemetryClient(ctx)
+				xTelemetry.Debug(ctx, "HTTPAdapter::RateLimit::Rate limit exceeded", telemetry.String("Path", r.URL().Path), telemetry.String("RetryAfter", wait.String()))
+
+				w.Header().Set("Retry-After", retryAfterSeconds(wait))
+				_ = WriteError(w, StatusTooManyRequests, ErrRateLimited)
+				return ctx, ErrRateLimited
+			}
+
+			return next(ctx, w, r)
+		}
+	}, nil
+}
+
+// keyedLimiter keeps a token bucket per key
+type keyedLimiter struct {
+	rate      float64
+	burst     int
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// Take a token from the key's bucket, otherwise get the wait until there is one
+func (l *keyedLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop the full buckets, so clients that stopped calling don't take memory
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		for k, bucket := range l.buckets {
+			if bucket.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(l.rate, l.burst, now)
+		l.buckets[key] = bucket
+	}
+
+	return bucket.allow(now)
+}
+
+// Limit each client IP, from the connection's remote address. Forwarded headers aren't trusted,
+// behind a proxy use a key reading the header the proxy sets
+func ClientIPKey(ctx context.Context, r comms.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr())
+	if err != nil {
+		return r.RemoteAddr()
+	}
+
+	return host
+}
+
+// Limit each authenticated principal, see Authenticate. Anonymous requests are limited by client IP
+func PrincipalKey(ctx context.Context, r comms.Request) string {
+	if principal, ok := comms.PrincipalFromContext(ctx); ok && principal.Subject != "" {
+		return "principal:" + principal.Issuer + ":" + principal.Subject
+	}
+
+	return "ip:" + ClientIPKey(ctx, r)
+}
+
+// Limit each value of the header, e.g. an API key. Values are hashed, so keys aren't kept in memory.
+// Requests without the header share a single limit
+func HeaderKey(header string) func(ctx context.Context, r comms.Request) string {
+	return func(ctx context.Context, r comms.Request) string {
+		value := r.Header(header)
+		if value == "" {
+			return ""
+		}
+
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// Limit the requests of the sender to rate per second, with bursts of up to burst requests.
+// Requests wait for their turn, failing with a RateLimitedError when the context would end first.
+// Once a server answers 429 or 503 with Retry-After, requests to that host wait until then (up to an hour).
+// Requests that would wait more than 5 seconds for the host fail right away with a RateLimitedError instead
+func WithRateLimit(rate float64, burst int) SenderOption {
+	return func(a *HttpSender) error {
+		if err := validateRateLimit(rate, burst); err != nil {
+			return err
+		}
+
+		a.rateLimiter = &senderLimiter{
+			bucket:      newTokenBucket(rate, burst, time.Now()),
+			pausedUntil: make(map[string]time.Time),
+		}
+		return nil
+	}
+}
+
+// senderLimiter limits the requests of a sender, pausing the hosts that asked to retry later
+type senderLimiter struct {
+	mu          sync.Mutex
+	bucket      *tokenBucket
+	pausedUntil map[string]time.Time
+}
+
+// Wait for the turn of a request to the host
+func (l *senderLimiter) wait(ctx context.Context, host string) error {
+	now := time.Now()
+
+	l.mu.Lock()
+	wait := l.bucket.reserve(now)
+	var paused time.Duration
+	if until, ok := l.pausedUntil[host]; ok {
+		if until.After(now) {
+			paused = until.Sub(now)
+		} else {
+			delete(l.pausedUntil, host)
+		}
+	}
+	l.mu.Unlock()
+
+	// Don't wait for long pauses, the caller can send the request later
+	if paused > maxRetryAfterWait {
+		l.cancel()
+		return &RateLimitedError{Wait: paused}
+	}
+	wait = max(wait, paused)
+	if wait <= 0 {
+		return nil
+	}
+
+	// Don't wait if the request couldn't be sent anyway
+	if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+		l.cancel()
+		return &RateLimitedError{Wait: wait}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// Give back the token of a request that wasn't sent
+func (l *senderLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bucket.cancel()
+}
+
+// Pause the requests to the host for the Retry-After of a rejected response
+func (l *senderLimiter) observe(host string, resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	retryAfter := min(parseRetryAfter(resp.Header.Get("Retry-After")), maxRetryAfterPause)
+	if retryAfter <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(retryAfter); until.After(l.pausedUntil[host]) {
+		l.pausedUntil[host] = until
+	}
+}
@@ -0,0 +1,219 @@
+package httpadapter_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+	w.WriteHeader(http.StatusNoContent)
+	return ctx, nil
+}
+
+func TestRateLimit_PerRoute(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	limit, err := httpadapter.RateLimit(httpadapter.RateLimitOptions{Rate: 0.5, Burst: 2})
+	require.NoError(t, err)
+	require.NoError(t, adapter.RegisterEndPoint(ctx, "/limited", okHandler, limit))
+	require.NoError(t, adapter.RegisterEndPoint(ctx, "/open", okHandler))
+
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/limited", "", "").StatusCode)
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/limited", "", "").StatusCode)
+
+	// The burst is spent, the next token comes in 2 seconds
+	resp := getWithHeader(t, server.URL+"/limited", "", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+	// Other routes aren't limited
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/open", "", "").StatusCode)
+	}
+}
+
+func TestRateLimit_Global(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	limit, err := httpadapter.RateLimit(httpadapter.RateLimitOptions{Rate: 1})
+	require.NoError(t, err)
+	adapter.Use(limit)
+	require.NoError(t, adapter.RegisterEndPoint(ctx, "/first", okHandler))
+	require.NoError(t, adapter.RegisterEndPoint(ctx, "/second", okHandler))
+
+	// Burst defaults to the rate, routes share the limit
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/first", "", "").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, getWithHeader(t, server.URL+"/second", "", "").StatusCode)
+}
+
+func TestRateLimit_PerKey(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	limit, err := httpadapter.RateLimit(httpadapter.RateLimitOptions{Rate: 1, Burst: 1, Key: httpadapter.HeaderKey("X-API-Key")})
+	require.NoError(t, err)
+	require.NoError(t, adapter.RegisterEndPoint(ctx, "/orders", okHandler, limit))
+
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/orders", "X-API-Key", "key-1").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, getWithHeader(t, server.URL+"/orders", "X-API-Key", "key-1").StatusCode)
+	// Every key has its own bucket
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/orders", "X-API-Key", "key-2").StatusCode)
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/orders", "", "").StatusCode)
+}
+
+func TestRateLimit_PrincipalKey(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	authenticate := func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			if subject := r.Header("X-User"); subject != "" {
+				ctx = comms.WithPrincipal(ctx, &comms.Principal{Subject: subject, Issuer: "test"})
+			}
+			return next(ctx, w, r)
+		}
+	}
+	limit, err := httpadapter.RateLimit(httpadapter.RateLimitOptions{Rate: 1, Burst: 1, Key: httpadapter.PrincipalKey})
+	require.NoError(t, err)
+	require.NoError(t, adapter.RegisterEndPoint(ctx, "/orders", okHandler, authenticate, limit))
+
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/orders", "X-User", "alice").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, getWithHeader(t, server.URL+"/orders", "X-User", "alice").StatusCode)
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/orders", "X-User", "bob").StatusCode)
+	// Anonymous requests are limited by client IP
+	assert.Equal(t, http.StatusNoContent, getWithHeader(t, server.URL+"/orders", "", "").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, getWithHeader(t, server.URL+"/orders", "", "").StatusCode)
+}
+
+func TestRateLimit_InvalidOptions(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	for _, options := range []httpadapter.RateLimitOptions{{}, {Rate: -1}, {Rate: 1, Burst: -1}} {
+		_, err := httpadapter.RateLimit(options)
+		assert.Error(t, err)
+	}
+	_, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithRateLimit(0, 1))
+	assert.Error(t, err)
+	_, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithRateLimit(1, 0))
+	assert.Error(t, err)
+}
+
+func TestSender_RateLimit(t *testing.T) {
+	ctx := initializeTelemetry()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/orders")
+	msg := messaging.NewMessage("", nil, "pending", "create", nil)
+
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithRateLimit(20, 2))
+	require.NoError(t, err)
+
+	// The burst goes at once, the next requests wait for their turn
+	startTime := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+	}
+	assert.GreaterOrEqual(t, time.Since(startTime), 90*time.Millisecond)
+
+	// Requests that can't get their turn before the deadline fail at once
+	for i := 0; i < 2; i++ {
+		require.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+	}
+	deadlineCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, sender.SendRequest(deadlineCtx, endpoint, msg), httpadapter.ErrRateLimited)
+}
+
+func TestSender_RetryAfter(t *testing.T) {
+	ctx := initializeTelemetry()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/orders")
+	msg := messaging.NewMessage("", nil, "pending", "create", nil)
+
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithRateLimit(100, 10))
+	require.NoError(t, err)
+
+	err = sender.SendRequest(ctx, endpoint, msg)
+	var statusErr *httpadapter.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, time.Second, statusErr.RetryAfter())
+
+	// The host is paused until the Retry-After ends
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, sender.SendRequest(shortCtx, endpoint, msg), httpadapter.ErrRateLimited)
+	assert.Equal(t, int32(1), calls.Load())
+
+	startTime := time.Now()
+	require.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+	assert.GreaterOrEqual(t, time.Since(startTime), 500*time.Millisecond)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestStatusError_RetryAfter(t *testing.T) {
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	for value, expected := range map[string]time.Duration{"": 0, "30": 30 * time.Second, "-1": 0, "soon": 0} {
+		statusErr := &httpadapter.StatusError{StatusCode: httpadapter.StatusTooManyRequests, Header: http.Header{"Retry-After": {value}}}
+		assert.Equal(t, expected, statusErr.RetryAfter(), "Retry-After: "+strconv.Quote(value))
+	}
+
+	statusErr := &httpadapter.StatusError{StatusCode: httpadapter.StatusServiceUnavailable, Header: http.Header{"Retry-After": {date}}}
+	assert.InDelta(t, time.Hour.Seconds(), statusErr.RetryAfter().Seconds(), 2)
+	assert.Zero(t, (&httpadapter.StatusError{}).RetryAfter())
+}
+
+func TestSender_LongRetryAfterFailsFast(t *testing.T) {
+	ctx := initializeTelemetry()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/orders")
+	msg := messaging.NewMessage("", nil, "pending", "create", nil)
+
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithRateLimit(100, 10))
+	require.NoError(t, err)
+	require.Error(t, sender.SendRequest(ctx, endpoint, msg))
+
+	// The request isn't sent and reports the remaining wait instead of blocking
+	startTime := time.Now()
+	err = sender.SendRequest(ctx, endpoint, msg)
+	assert.Less(t, time.Since(startTime), time.Second)
+	assert.ErrorIs(t, err, httpadapter.ErrRateLimited)
+	var rateLimitedErr *httpadapter.RateLimitedError
+	require.True(t, errors.As(err, &rateLimitedErr))
+	assert.InDelta(t, 120, rateLimitedErr.RetryAfter().Seconds(), 2)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -1,9 +1,47 @@
 package httpadapter
 
-import "net/http"
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
 
+	"github.com/perocha/goadapters/comms"
+)
+
+// requestAdapter implements comms.Request over an http.Request
 type requestAdapter struct {
 	*http.Request
+	body    []byte
+	bodyErr error
+	read    bool
+}
+
+// Create a request adapter, limiting the body to maxBodyBytes (no limit if zero or negative)
+func newRequestAdapter(w http.ResponseWriter, r *http.Request, maxBodyBytes int64) *requestAdapter {
+	if maxBodyBytes > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	}
+
+	return &requestAdapter{
+		Request: r,
+	}
+}
+
+// Get the request method
+func (r *requestAdapter) Method() string {
+	return r.Request.Method
+}
+
+// Get the request URL
+func (r *requestAdapter) URL() *url.URL {
+	return r.Request.URL
+}
+
+// Get the network address of the client
+func (r *requestAdapter) RemoteAddr() string {
+	return r.Request.RemoteAddr
 }
 
 func (r *requestAdapter) Header(key string) string {
@@ -11,10 +49,77 @@ func (r *requestAdapter) Header(key string) string {
 	return r.Request.Header.Get(key)
 }
 
+// Get all the request headers
+func (r *requestAdapter) Headers() comms.Header {
+	return r.Request.Header
+}
+
+// Get a path parameter, e.g. "id" for the pattern "/orders/{id}"
+func (r *requestAdapter) PathParam(name string) string {
+	return r.Request.PathValue(name)
+}
+
+// Get the first value of a query parameter
+func (r *requestAdapter) QueryParam(key string) string {
+	return r.Request.URL.Query().Get(key)
+}
+
+// Get all the query parameters
+func (r *requestAdapter) Query() url.Values {
+	return r.Request.URL.Query()
+}
+
+// Get the whole body, nil if it can't be read
 func (r *requestAdapter) Body() []byte {
-	// Read the body
-	body := make([]byte, r.Request.ContentLength)
-	r.Request.Body.Read(body)
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil
+	}
 
 	return body
 }
+
+// Read the whole body, the result is kept so the body can be read more than once
+func (r *requestAdapter) ReadBody() ([]byte, error) {
+	if !r.read {
+		r.read = true
+		if r.Request.Body != nil {
+			r.body, r.bodyErr = io.ReadAll(r.Request.Body)
+			r.bodyErr = convertBodyError(r.bodyErr)
+		}
+	}
+
+	return r.body, r.bodyErr
+}
+
+// Stream the body. Once the body has been read with Body or ReadBody, the cached copy is returned
+func (r *requestAdapter) BodyReader() io.Reader {
+	if r.read {
+		return bytes.NewReader(r.body)
+	}
+	if r.Request.Body == nil {
+		return bytes.NewReader(nil)
+	}
+
+	return &bodyReader{r.Request.Body}
+}
+
+// bodyReader reports the comms error when the body limit is exceeded
+type bodyReader struct {
+	io.Reader
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	return n, convertBodyError(err)
+}
+
+// Map the net/http body limit error to comms.ErrBodyTooLarge
+func convertBodyError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return comms.ErrBodyTooLarge
+	}
+
+	return err
+}
@@ -0,0 +1,100 @@
+package httpadapter_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/stretchr/testify/assert"
+)
+
+// chunkedReader hides the length of the body, so that the client sends it chunked
+type chunkedReader struct {
+	io.Reader
+}
+
+func TestRequest_Accessors(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "8080")
+	assert.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	var method, path, remoteAddr, body string
+	var tags, query []string
+	err = adapter.RegisterEndPoint(ctx, "/orders/", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		method = r.Method()
+		path = r.URL().Path
+		remoteAddr = r.RemoteAddr()
+		tags = r.Headers().Values("X-Tag")
+		query = r.Query()["id"]
+		body = string(r.Body())
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+
+	// A chunked body (unknown content length) is read completely
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/orders/1?id=1&id=2", &chunkedReader{strings.NewReader(strings.Repeat("a", 100000))})
+	req.Header.Add("X-Tag", "first")
+	req.Header.Add("X-Tag", "second")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/orders/1", path)
+	assert.NotEmpty(t, remoteAddr)
+	assert.Equal(t, []string{"first", "second"}, tags)
+	assert.Equal(t, []string{"1", "2"}, query)
+	assert.Len(t, body, 100000)
+}
+
+func TestRequest_BodyLimit(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "8080", httpadapter.WithMaxBodyBytes(10))
+	assert.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	err = adapter.RegisterEndPoint(ctx, "/read", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		body, err := r.ReadBody()
+		if err != nil {
+			assert.ErrorIs(t, err, comms.ErrBodyTooLarge)
+			assert.Nil(t, r.Body())
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return ctx, err
+		}
+		// The body can be read more than once
+		assert.Equal(t, body, r.Body())
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+	err = adapter.RegisterEndPoint(ctx, "/stream", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		_, err := io.ReadAll(r.BodyReader())
+		assert.ErrorIs(t, err, comms.ErrBodyTooLarge)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return ctx, err
+	})
+	assert.NoError(t, err)
+
+	resp, err := http.Post(server.URL+"/read", "text/plain", strings.NewReader("small"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/read", "text/plain", strings.NewReader("this body is too large"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/stream", "text/plain", strings.NewReader("this body is too large"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
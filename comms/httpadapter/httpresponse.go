@@ -1,12 +1,29 @@
 package httpadapter
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/messaging"
+)
+
+const (
+	// Content types written by the response helpers
+	ContentTypeJSON    = "application/json"
+	ContentTypeProblem = "application/problem+json"
+)
 
 type responseWriterAdapter struct {
 	http.ResponseWriter
 	statusCode int
 }
 
+// Get the response headers, they must be set before calling WriteHeader or Write
+func (r *responseWriterAdapter) Header() comms.Header {
+	return r.ResponseWriter.Header()
+}
+
 func (r *responseWriterAdapter) Write(data []byte) (int, error) {
 	return r.ResponseWriter.Write(data)
 }
@@ -19,3 +36,112 @@ func (r *responseWriterAdapter) WriteHeader(statusCode int) {
 func (r *responseWriterAdapter) Status() int {
 	return r.statusCode
 }
+
+// httpResponse implements comms.Response over a read HTTP response
+type httpResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+// Get the status code
+func (r *httpResponse) StatusCode() int {
+	return r.statusCode
+}
+
+// Get a response header
+func (r *httpResponse) Header(key string) string {
+	return r.header.Get(key)
+}
+
+// Get all the response headers
+func (r *httpResponse) Headers() comms.Header {
+	return r.header
+}
+
+// Get the whole body
+func (r *httpResponse) Body() []byte {
+	return r.body
+}
+
+// Deserialize the body into a message
+func (r *httpResponse) Message() (messaging.Message, error) {
+	msg := messaging.NewMessage("", nil, "", "", nil)
+	if err := msg.Deserialize(r.body); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// ProblemDetails is the RFC 7807 error body written by WriteError and WriteProblem
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// Write a value as JSON with the given status
+func WriteJSON(w comms.ResponseWriter, status HTTPStatus, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return writeBody(w, status, ContentTypeJSON, data)
+}
+
+// Write a serialized message with the given status
+func WriteMessage(w comms.ResponseWriter, status HTTPStatus, msg messaging.Message) error {
+	data, err := msg.Serialize()
+	if err != nil {
+		return err
+	}
+
+	return writeBody(w, status, ContentTypeJSON, data)
+}
+
+// Write an RFC 7807 problem for the status, using the error as detail (if not nil)
+func WriteError(w comms.ResponseWriter, status HTTPStatus, err error) error {
+	problem := ProblemDetails{
+		Type:   "about:blank",
+		Title:  status.String(),
+		Status: int(status),
+	}
+	if err != nil {
+		problem.Detail = err.Error()
+	}
+
+	return WriteProblem(w, problem)
+}
+
+// Write an RFC 7807 problem, missing type, title and status are filled in from the defaults
+func WriteProblem(w comms.ResponseWriter, problem ProblemDetails) error {
+	if problem.Status == 0 {
+		problem.Status = int(StatusInternalServerError)
+	}
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = HTTPStatus(problem.Status).String()
+	}
+
+	data, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+
+	return writeBody(w, HTTPStatus(problem.Status), ContentTypeProblem, data)
+}
+
+// Set the content type, write the status and the body
+func writeBody(w comms.ResponseWriter, status HTTPStatus, contentType string, data []byte) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(int(status))
+	_, err := w.Write(data)
+
+	return err
+}
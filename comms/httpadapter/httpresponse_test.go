@@ -0,0 +1,71 @@
+package httpadapter_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponse_Helpers(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "8080")
+	assert.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	err = adapter.RegisterRoute(ctx, http.MethodPost, "/orders", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.Header().Set("Location", "/orders/42")
+		return ctx, httpadapter.WriteJSON(w, httpadapter.StatusCreated, map[string]string{"id": "42"})
+	})
+	assert.NoError(t, err)
+	err = adapter.RegisterRoute(ctx, http.MethodGet, "/orders/{id}", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		if r.PathParam("id") != "42" {
+			return ctx, httpadapter.WriteError(w, httpadapter.StatusNotFound, errors.New("order not found"))
+		}
+		return ctx, httpadapter.WriteMessage(w, httpadapter.StatusOK, messaging.NewMessage("op-1", nil, "done", "get", []byte("order")))
+	})
+	assert.NoError(t, err)
+
+	// JSON body with custom headers
+	resp, err := http.Post(server.URL+"/orders", "application/json", nil)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, httpadapter.ContentTypeJSON, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "/orders/42", resp.Header.Get("Location"))
+	assert.JSONEq(t, `{"id":"42"}`, string(body))
+
+	// Serialized message
+	resp, err = http.Get(server.URL + "/orders/42")
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	msg := messaging.NewMessage("", nil, "", "", nil)
+	assert.NoError(t, msg.Deserialize(body))
+	assert.Equal(t, "op-1", msg.GetOperationID())
+	assert.Equal(t, []byte("order"), msg.GetData())
+
+	// Problem details
+	resp, err = http.Get(server.URL + "/orders/7")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, httpadapter.ContentTypeProblem, resp.Header.Get("Content-Type"))
+
+	var problem httpadapter.ProblemDetails
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, httpadapter.ProblemDetails{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "order not found"}, problem)
+}
+
+func TestHTTPStatus_String(t *testing.T) {
+	assert.Equal(t, "Too Many Requests", httpadapter.StatusTooManyRequests.String())
+	assert.Equal(t, "Internal Server Error", httpadapter.StatusInternalServerError.String())
+}
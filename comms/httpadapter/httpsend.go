@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -15,16 +17,52 @@ import (
 )
 
 // Initialize the HTTP adapter
-func HttpSenderInit(ctx context.Context) (*HttpSender, error) {
+func HttpSenderInit(ctx context.Context, opts ...SenderOption) (*HttpSender, error) {
 	xTelemetry := telemetry.GetXTelemetryClient(ctx)
 	xTelemetry.Debug(ctx, "HTTPAdapter::HttpSenderInit")
 
-	// Create a new HTTP client
-	httpClient := &http.Client{}
+	sender := &HttpSender{
+		timeout: DefaultSenderTimeout,
+	}
 
-	return &HttpSender{
-		httpClient: httpClient,
-	}, nil
+	// Apply the options
+	for _, opt := range opts {
+		if err := opt(sender); err != nil {
+			xTelemetry.Error(ctx, "HTTPAdapter::HttpSenderInit::Invalid option", telemetry.String("Error", err.Error()))
+			return nil, err
+		}
+	}
+
+	// Create a new HTTP client, with the transport built from the options
+	transport, err := sender.transport()
+	if err != nil {
+		xTelemetry.Error(ctx, "HTTPAdapter::HttpSenderInit::Invalid option", telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+	sender.httpClient = &http.Client{
+		Transport: transport,
+		Timeout:   sender.timeout,
+	}
+
+	return sender, nil
+}
+
+// Accept only the given status codes as success in SendRequest, instead of any 2xx
+func WithSuccessStatuses(statuses ...HTTPStatus) SenderOption {
+	return func(a *HttpSender) error {
+		if len(statuses) == 0 {
+			return errors.New("no success status codes")
+		}
+
+		a.successStatuses = make(map[int]bool, len(statuses))
+		for _, status := range statuses {
+			if status < 100 || status > 999 {
+				return fmt.Errorf("invalid status code: %d", status)
+			}
+			a.successStatuses[int(status)] = true
+		}
+		return nil
+	}
 }
 
 // Send a request
@@ -35,54 +73,207 @@ func (a *HttpSender) SendRequest(ctx context.Context, endpoint comms.EndPoint, d
 	// Get telemetry client
 	xTelemetry := telemetry.GetXTelemetryClient(ctx)
 
-	// Obtain operation id from context
-	operationID := telemetry.GetOperationID(ctx)
-	xTelemetry.Debug(ctx, "HTTPAdapter::Publish", telemetry.String("Command", data.GetCommand()), telemetry.String("Status", data.GetStatus()), telemetry.String("Data", string(data.GetData())), telemetry.String("OperationID", operationID))
-
-	// Set operation ID in the message
-	if operationID != "" {
-		data.SetOperationID(operationID)
+	// Get the endpoint URL
+	endpointURL, done, err := resolveEndPoint(ctx, endpoint)
+	if err != nil {
+		return err
 	}
 
-	// Convert the message to JSON
-	jsonData, err := data.Serialize()
+	// Perform the HTTP request
+	resp, err := a.do(ctx, http.MethodPost, endpointURL, data)
 	if err != nil {
-		xTelemetry.Error(ctx, "HTTPAdapter::Publish::Failed", telemetry.String("Error", err.Error()))
+		done(err)
 		return err
 	}
 
+	// Check the response status code
+	if !a.isSuccessStatus(resp.StatusCode()) {
+		statusErr := newStatusError(resp)
+		done(statusErr)
+		xTelemetry.Error(ctx, "HTTPAdapter::Publish::Server returned an error status code", telemetry.String("StatusCode", strconv.Itoa(resp.StatusCode())), telemetry.String("Response", statusErr.Body))
+		xTelemetry.Request(ctx, http.MethodPost, endpointURL.Redacted(), startTime, time.Now(), strconv.Itoa(resp.StatusCode()), false, endpointURL.Hostname(), statusErr.Error())
+		return statusErr
+	}
+
+	done(nil)
+
+	// Log the telemetry request
+	xTelemetry.Request(ctx, http.MethodPost, endpointURL.Redacted(), startTime, time.Now(), strconv.Itoa(resp.StatusCode()), true, endpointURL.Hostname(), "HTTPAdapter::Publish::Success")
+
+	return nil
+}
+
+// Call the endpoint with any method and return the response, e.g. Call(ctx, http.MethodGet, endpoint, nil).
+// The message is sent as the request body (no body if nil). Only transport failures are returned as errors,
+// the status of the response has to be checked by the caller
+func (a *HttpSender) Call(ctx context.Context, method string, endpoint comms.EndPoint, data messaging.Message) (comms.Response, error) {
+	// Start tracking the time
+	startTime := time.Now()
+
+	// Get telemetry client
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
 	// Get the endpoint URL
-	httpEndPoint, ok := endpoint.(*HTTPEndPoint)
-	if !ok {
-		return errors.New("endpoint is not of type HTTPEndPoint")
+	endpointURL, done, err := resolveEndPoint(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Perform the HTTP request
+	resp, err := a.do(ctx, method, endpointURL, data)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+
+	// Server errors count against the health of balanced endpoints
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		done(newStatusError(resp))
+	} else {
+		done(nil)
+	}
+
+	// Log the telemetry request
+	xTelemetry.Request(ctx, method, endpointURL.Redacted(), startTime, time.Now(), strconv.Itoa(resp.StatusCode()), isSuccess(resp.StatusCode()), endpointURL.Hostname(), "HTTPAdapter::Call")
+
+	return resp, nil
+}
+
+// Build and perform the request, reading the whole response
+func (a *HttpSender) do(ctx context.Context, method string, endpointURL *url.URL, data messaging.Message) (*httpResponse, error) {
+	// Get telemetry client
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	// Obtain operation id from context, it's optional since not every caller starts an operation
+	operationID, _ := ctx.Value(telemetry.OperationIDKeyContextKey).(string)
+
+	var body io.Reader
+	var payload []byte
+	if data != nil {
+		xTelemetry.Debug(ctx, "HTTPAdapter::Publish", telemetry.String("Method", method), telemetry.String("Command", data.GetCommand()), telemetry.String("Status", data.GetStatus()), telemetry.String("Data", string(data.GetData())), telemetry.String("OperationID", operationID))
+
+		// Set operation ID in the message
+		if operationID != "" {
+			data.SetOperationID(operationID)
+		}
+
+		// Convert the message to JSON
+		jsonData, err := data.Serialize()
+		if err != nil {
+			xTelemetry.Error(ctx, "HTTPAdapter::Publish::Failed", telemetry.String("Error", err.Error()))
+			return nil, err
+		}
+		payload = jsonData
+		body = bytes.NewReader(payload)
+	} else {
+		xTelemetry.Debug(ctx, "HTTPAdapter::Publish", telemetry.String("Method", method), telemetry.String("OperationID", operationID))
+	}
+
+	// Wait for the turn of the request, before it's signed
+	if a.rateLimiter != nil {
+		if err := a.rateLimiter.wait(ctx, endpointURL.Host); err != nil {
+			xTelemetry.Error(ctx, "HTTPAdapter::Publish::Rate limited", telemetry.String("Error", err.Error()))
+			return nil, err
+		}
 	}
 
 	// Create a new HTTP request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpEndPoint.GetEndPoint(), bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, method, endpointURL.String(), body)
 	if err != nil {
 		xTelemetry.Error(ctx, "HTTPAdapter::Publish::Failed to create HTTP request", telemetry.String("Error", err.Error()))
-		return err
+		return nil, err
+	}
+	for key, values := range a.defaultHeaders {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", ContentTypeJSON)
+	}
+	req.Header.Set("Accept", ContentTypeJSON)
+
+	// Propagate the operation and the trace to the receiver
+	comms.InjectTrace(ctx, req.Header)
+
+	// Authorize the request
+	if a.credentials != nil {
+		if err := a.credentials.Authorize(ctx, req); err != nil {
+			xTelemetry.Error(ctx, "HTTPAdapter::Publish::Failed to authorize request", telemetry.String("Error", err.Error()))
+			return nil, err
+		}
+	}
+
+	// Sign the request as a webhook
+	if len(a.webhookKeys) > 0 {
+		signWebhook(ctx, a.webhookKeys, req.Header, payload)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	// Perform the HTTP request
 	resp, err := a.httpClient.Do(req)
 	if err != nil {
 		xTelemetry.Error(ctx, "HTTPAdapter::Publish::Failed to make HTTP request", telemetry.String("Error", err.Error()))
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Check the response status code
-	if resp.StatusCode != http.StatusOK {
-		// Read the response body to capture the error message or details
-		respBody, _ := io.ReadAll(resp.Body)
-		xTelemetry.Error(ctx, "HTTPAdapter::Publish::Server returned non-OK status code", telemetry.Int("StatusCode", resp.StatusCode), telemetry.String("Response", string(respBody)))
-		return errors.New("server returned non-OK status code")
+	// Honor the server's Retry-After in the next requests
+	if a.rateLimiter != nil {
+		a.rateLimiter.observe(endpointURL.Host, resp)
 	}
 
-	// Log the telemetry request
-	xTelemetry.Request(ctx, http.MethodPost, httpEndPoint.GetEndPoint(), startTime, time.Now(), strconv.Itoa(http.StatusOK), true, httpEndPoint.GetHost(), "HTTPAdapter::Publish::Success")
+	// The token was rejected, e.g. revoked before it expired. Providers that cache tokens fetch a new one next time
+	if resp.StatusCode == http.StatusUnauthorized {
+		if invalidator, ok := a.credentials.(interface{ Invalidate() }); ok {
+			invalidator.Invalidate()
+		}
+	}
 
-	return nil
+	// Read the response body, up to the size limit
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, DefaultMaxBodyBytes+1))
+	if err != nil {
+		xTelemetry.Error(ctx, "HTTPAdapter::Publish::Failed to read HTTP response", telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+	if int64(len(respBody)) > DefaultMaxBodyBytes {
+		xTelemetry.Error(ctx, "HTTPAdapter::Publish::HTTP response too large", telemetry.String("Error", comms.ErrBodyTooLarge.Error()))
+		return nil, comms.ErrBodyTooLarge
+	}
+
+	return &httpResponse{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       respBody,
+	}, nil
+}
+
+// Check if SendRequest accepts the status code, any 2xx unless configured with WithSuccessStatuses
+func (a *HttpSender) isSuccessStatus(statusCode int) bool {
+	if len(a.successStatuses) > 0 {
+		return a.successStatuses[statusCode]
+	}
+
+	return isSuccess(statusCode)
+}
+
+// Resolve any endpoint to an http or https URL. Balanced endpoints pick a host, done reports the outcome of the request
+func resolveEndPoint(ctx context.Context, endpoint comms.EndPoint) (*url.URL, func(error), error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	var endpointURL *url.URL
+	done := func(error) {}
+	var err error
+	if balanced, ok := endpoint.(comms.BalancedEndPoint); ok {
+		endpointURL, done, err = balanced.Pick(ctx)
+	} else {
+		endpointURL, err = comms.ResolveURL(endpoint)
+	}
+	if err == nil && endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		done(nil)
+		err = errors.New("endpoint scheme must be http or https: " + endpointURL.Scheme)
+	}
+	if err != nil {
+		xTelemetry.Error(ctx, "HTTPAdapter::Publish::Invalid endpoint", telemetry.String("Error", err.Error()))
+		return nil, nil, err
+	}
+
+	return endpointURL, done, nil
 }
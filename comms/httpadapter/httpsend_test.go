@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/perocha/goadapters/comms"
 	"github.com/perocha/goadapters/comms/httpadapter"
@@ -125,7 +126,52 @@ func TestPublish_NonOKResponse(t *testing.T) {
 
 	err := adapter.SendRequest(ctx, endpoint, msg)
 	assert.Error(t, err)
-	assert.Equal(t, "server returned non-OK status code", err.Error())
+
+	var statusErr *httpadapter.StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, httpadapter.StatusInternalServerError, statusErr.StatusCode)
+	assert.True(t, statusErr.Retryable())
+	assert.Equal(t, "server returned status 500 Internal Server Error", err.Error())
+}
+
+func TestPublish_SuccessStatuses(t *testing.T) {
+	ctx := initializeTelemetry()
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reason", "test")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(strings.Repeat("x", 2000)))
+	}))
+	defer server.Close()
+
+	endpoint := httpadapter.NewEndpoint("localhost", strings.Split(server.URL, ":")[2], "/test")
+	msg := messaging.NewMessage("", nil, "success", "test", []byte("test"))
+
+	// Any 2xx is a success by default
+	adapter, err := httpadapter.HttpSenderInit(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, adapter.SendRequest(ctx, endpoint, msg))
+
+	// Only the configured statuses are a success
+	adapter, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithSuccessStatuses(httpadapter.StatusOK, httpadapter.StatusCreated))
+	assert.NoError(t, err)
+	err = adapter.SendRequest(ctx, endpoint, msg)
+
+	var statusErr *httpadapter.StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, httpadapter.StatusAccepted, statusErr.StatusCode)
+	assert.Equal(t, "test", statusErr.Header.Get("X-Reason"))
+	assert.Len(t, statusErr.Body, 1024)
+	assert.False(t, statusErr.Retryable())
+
+	status = http.StatusCreated
+	assert.NoError(t, adapter.SendRequest(ctx, endpoint, msg))
+
+	// At least one valid status is required
+	_, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithSuccessStatuses())
+	assert.Error(t, err)
+	_, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithSuccessStatuses(42))
+	assert.Error(t, err)
 }
 
 func TestPublish_ErrorSerializing(t *testing.T) {
@@ -154,3 +200,199 @@ func TestInterface(t *testing.T) {
 
 	testInterfaceImplementation(t, adapter)
 }
+
+func TestCall(t *testing.T) {
+	ctx := initializeTelemetry()
+	receiver, err := httpadapter.HTTPServerAdapterInit(ctx, "0")
+	assert.NoError(t, err)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// GET answers with a message
+	err = receiver.RegisterRoute(ctx, http.MethodGet, "/orders/{id}", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		assert.Empty(t, r.Body())
+		w.Header().Set("X-Order", r.PathParam("id"))
+		return ctx, httpadapter.WriteMessage(w, httpadapter.StatusOK, messaging.NewMessage("", nil, "found", "get", []byte(r.PathParam("id"))))
+	})
+	assert.NoError(t, err)
+
+	// PUT echoes the request message
+	err = receiver.RegisterRoute(ctx, http.MethodPut, "/orders/{id}", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		msg := messaging.NewMessage("", nil, "", "", nil)
+		if err := msg.Deserialize(r.Body()); err != nil {
+			return ctx, httpadapter.WriteError(w, httpadapter.StatusBadRequest, err)
+		}
+		return ctx, httpadapter.WriteMessage(w, httpadapter.StatusCreated, msg)
+	})
+	assert.NoError(t, err)
+
+	sender, err := httpadapter.HttpSenderInit(ctx)
+	assert.NoError(t, err)
+	port := strings.Split(server.URL, ":")[2]
+
+	resp, err := sender.Call(ctx, http.MethodGet, httpadapter.NewEndpoint("127.0.0.1", port, "/orders/42"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "42", resp.Header("X-Order"))
+	msg, err := resp.Message()
+	assert.NoError(t, err)
+	assert.Equal(t, "found", msg.GetStatus())
+	assert.Equal(t, []byte("42"), msg.GetData())
+
+	resp, err = sender.Call(ctx, http.MethodPut, httpadapter.NewEndpoint("127.0.0.1", port, "/orders/42"), messaging.NewMessage("", nil, "pending", "update", []byte("data")))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	msg, err = resp.Message()
+	assert.NoError(t, err)
+	assert.Equal(t, "update", msg.GetCommand())
+	assert.Equal(t, []byte("data"), msg.GetData())
+
+	// Error statuses are returned as responses
+	resp, err = sender.Call(ctx, http.MethodDelete, httpadapter.NewEndpoint("127.0.0.1", port, "/orders/42"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode())
+	assert.Contains(t, resp.Header("Allow"), http.MethodGet)
+
+	// Transport failures are errors
+	_, err = sender.Call(ctx, http.MethodGet, httpadapter.NewEndpoint("127.0.0.1", "1", "/orders/42"), nil)
+	assert.Error(t, err)
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSender_Timeout(t *testing.T) {
+	ctx := initializeTelemetry()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/slow")
+	msg := messaging.NewMessage("", nil, "pending", "slow", nil)
+
+	adapter, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	assert.Error(t, adapter.SendRequest(ctx, endpoint, msg))
+
+	adapter, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithResponseHeaderTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	assert.Error(t, adapter.SendRequest(ctx, endpoint, msg))
+
+	adapter, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithTimeout(time.Second), httpadapter.WithMaxIdleConns(10, 2), httpadapter.WithMaxConnsPerHost(4), httpadapter.WithKeepAlive(time.Minute), httpadapter.WithNoProxy())
+	assert.NoError(t, err)
+	assert.NoError(t, adapter.SendRequest(ctx, endpoint, msg))
+}
+
+func TestSender_RoundTripperAndDefaultHeaders(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	var received *http.Request
+	roundTripper := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		received = req
+		return &http.Response{
+			StatusCode: http.StatusNoContent,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	})
+
+	adapter, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithRoundTripper(roundTripper), httpadapter.WithDefaultHeader("User-Agent", "orders-service"), httpadapter.WithDefaultHeader("Content-Type", "text/plain"))
+	assert.NoError(t, err)
+
+	err = adapter.SendRequest(ctx, httpadapter.NewEndpoint("orders", "80", "/orders"), messaging.NewMessage("", nil, "pending", "create", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "orders-service", received.Header.Get("User-Agent"))
+	// The sender's own headers take precedence
+	assert.Equal(t, httpadapter.ContentTypeJSON, received.Header.Get("Content-Type"))
+}
+
+func TestSender_Proxy(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	// The proxy receives requests with the absolute URL of the target
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	adapter, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithProxy(proxy.URL))
+	assert.NoError(t, err)
+
+	err = adapter.SendRequest(ctx, httpadapter.NewEndpoint("orders.internal", "8080", "/orders"), messaging.NewMessage("", nil, "pending", "create", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://orders.internal:8080/orders", proxied)
+}
+
+func TestSender_InvalidOptions(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	invalid := map[string]httpadapter.SenderOption{
+		"negative timeout":         httpadapter.WithTimeout(-time.Second),
+		"zero dial timeout":        httpadapter.WithDialTimeout(0),
+		"zero handshake timeout":   httpadapter.WithTLSHandshakeTimeout(0),
+		"zero header timeout":      httpadapter.WithResponseHeaderTimeout(0),
+		"zero idle timeout":        httpadapter.WithIdleConnTimeout(0),
+		"per host over total":      httpadapter.WithMaxIdleConns(2, 10),
+		"negative conns per host":  httpadapter.WithMaxConnsPerHost(-1),
+		"zero keep-alive":          httpadapter.WithKeepAlive(0),
+		"proxy without scheme":     httpadapter.WithProxy("proxy:3128"),
+		"proxy with ftp scheme":    httpadapter.WithProxy("ftp://proxy:3128"),
+		"nil round tripper":        httpadapter.WithRoundTripper(nil),
+		"empty default header key": httpadapter.WithDefaultHeader("", "value"),
+	}
+	for name, option := range invalid {
+		_, err := httpadapter.HttpSenderInit(ctx, option)
+		assert.Error(t, err, name)
+	}
+
+	// A custom round tripper replaces the transport the other options configure
+	_, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithRoundTripper(http.DefaultTransport), httpadapter.WithDialTimeout(time.Second))
+	assert.Error(t, err)
+}
+
+// stringEndPoint is an endpoint implementation other than HTTPEndPoint
+type stringEndPoint struct {
+	endpoint string
+}
+
+func (e *stringEndPoint) GetEndPoint() string {
+	return e.endpoint
+}
+
+func (e *stringEndPoint) SetEndPoint(endpoint string) {
+	e.endpoint = endpoint
+}
+
+func TestSender_AnyEndPoint(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	adapter, err := httpadapter.HttpSenderInit(ctx)
+	assert.NoError(t, err)
+	msg := messaging.NewMessage("", nil, "pending", "create", nil)
+
+	// Any endpoint resolving to an absolute URL is accepted, the query is kept
+	err = adapter.SendRequest(ctx, &stringEndPoint{server.URL + "/orders?tenant=contoso"}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant=contoso", query)
+
+	// Relative and non http URLs are rejected
+	assert.Error(t, adapter.SendRequest(ctx, &stringEndPoint{"/orders"}, msg))
+	assert.Error(t, adapter.SendRequest(ctx, &stringEndPoint{"ftp://orders/file"}, msg))
+	assert.Error(t, adapter.SendRequest(ctx, nil, msg))
+}
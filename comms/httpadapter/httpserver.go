@@ -3,26 +3,134 @@ package httpadapter
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
-	"time"
 
-	"github.com/google/uuid"
 	"github.com/perocha/goadapters/comms"
 	"github.com/perocha/goutils/pkg/telemetry"
 )
 
-func HTTPServerAdapterInit(ctx context.Context, port string) (*HttpReceiver, error) {
+func HTTPServerAdapterInit(ctx context.Context, port string, opts ...ReceiverOption) (*HttpReceiver, error) {
 	xTelemetry := telemetry.GetXTelemetryClient(ctx)
 	xTelemetry.Debug(ctx, "HTTPAdapter::HTTPServerAdapterInit", telemetry.String("Port", port))
 
-	// Create a new server
-	httpServer := &http.Server{Addr: ":" + port}
+	receiver := &HttpReceiver{
+		portNumber:   port,
+		routes:       make(map[string]http.Handler),
+		mux:          http.NewServeMux(),
+		maxBodyBytes: DefaultMaxBodyBytes,
+		baseCtx:      ctx,
+	}
+
+	// Apply the options
+	for _, opt := range opts {
+		if err := opt(receiver); err != nil {
+			xTelemetry.Error(ctx, "HTTPAdapter::HTTPServerAdapterInit::Invalid option", telemetry.String("Error", err.Error()))
+			return nil, err
+		}
+	}
+
+	// Serving TLS requires a certificate
+	if receiver.tlsConfig != nil && len(receiver.tlsConfig.Certificates) == 0 && receiver.tlsConfig.GetCertificate == nil && receiver.tlsConfig.GetConfigForClient == nil {
+		err := errors.New("TLS enabled without a server certificate")
+		xTelemetry.Error(ctx, "HTTPAdapter::HTTPServerAdapterInit::Invalid option", telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+
+	// Expose the health endpoints
+	if err := receiver.buildHealthMux(); err != nil {
+		xTelemetry.Error(ctx, "HTTPAdapter::HTTPServerAdapterInit::Failed to register health endpoints", telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+
+	// Expose the metrics endpoint
+	if receiver.metrics != nil {
+		if err := receiver.RegisterRoute(ctx, http.MethodGet, receiver.metricsPath, receiver.metrics.handle); err != nil {
+			xTelemetry.Error(ctx, "HTTPAdapter::HTTPServerAdapterInit::Failed to register metrics endpoint", telemetry.String("Error", err.Error()))
+			return nil, err
+		}
+	}
+
+	// Create a new server, routing requests to the receiver's own routes
+	receiver.httpServer = &http.Server{
+		Addr:      ":" + port,
+		Handler:   receiver,
+		TLSConfig: receiver.tlsConfig,
+	}
 
-	return &HttpReceiver{
-		httpServer: httpServer,
-		portNumber: port,
-	}, nil
+	return receiver, nil
+}
+
+// Limit the size of request bodies read through comms.Request, zero or negative disables the limit
+func WithMaxBodyBytes(maxBodyBytes int64) ReceiverOption {
+	return func(a *HttpReceiver) error {
+		a.maxBodyBytes = maxBodyBytes
+		return nil
+	}
+}
+
+// ServeHTTP runs the global middleware and dispatches the request to the registered endpoints,
+// so the receiver can also be mounted on any http.Server. Health probes are answered before the global middleware
+func (a *HttpReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Convert http.ResponseWriter to comms.ResponseWriter
+	commsWriter := &responseWriterAdapter{
+		w,
+		http.StatusOK,
+	}
+
+	// Record the request whatever answers it: a health endpoint, the global middleware or an endpoint
+	if a.metrics != nil {
+		done := a.metrics.start(a.routePattern(r), r.Method)
+		defer func() { done(commsWriter.Status()) }()
+	}
+
+	if a.serveHealth(&httpWriter{commsWriter, w}, r) {
+		return
+	}
+
+	a.mu.RLock()
+	mux := a.mux
+	middlewares := a.middlewares
+	a.mu.RUnlock()
+
+	// Convert *http.Request to comms.Request
+	commsReq := newRequestAdapter(w, r, a.maxBodyBytes)
+
+	// The mux is the last handler of the chain, the endpoint gets the context, writer and request passed down by the middleware
+	dispatch := func(ctx context.Context, cw comms.ResponseWriter, cr comms.Request) (context.Context, error) {
+		state := &routeState{ctx: ctx, w: cw, r: cr}
+		mux.ServeHTTP(&httpWriter{cw, w}, r.WithContext(context.WithValue(r.Context(), routeStateKey{}, state)))
+		return state.ctx, state.err
+	}
+
+	_, _ = comms.Chain(dispatch, middlewares...)(a.requestContext(r), commsWriter, commsReq)
+}
+
+// Get the pattern of the endpoint a request is routed to, or unmatchedEndpoint
+func (a *HttpReceiver) routePattern(r *http.Request) string {
+	if a.healthMux != nil {
+		if _, pattern := a.healthMux.Handler(r); pattern != "" {
+			return pattern
+		}
+	}
+
+	a.mu.RLock()
+	mux := a.mux
+	a.mu.RUnlock()
+	if _, pattern := mux.Handler(r); pattern != "" {
+		return pattern
+	}
+
+	return unmatchedEndpoint
+}
+
+// Context of a request, with the values of the context given at init
+func (a *HttpReceiver) requestContext(r *http.Request) context.Context {
+	if a.baseCtx != nil {
+		return valuesContext{r.Context(), a.baseCtx}
+	}
+
+	return r.Context()
 }
 
 // Start the HTTP server
@@ -44,7 +152,13 @@ func (a *HttpReceiver) Start(ctx context.Context) error {
 
 	// Start the server
 	go func() {
-		err := a.httpServer.ListenAndServe()
+		var err error
+		if a.tlsConfig != nil {
+			// The certificate comes from the TLS config
+			err = a.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = a.httpServer.ListenAndServe()
+		}
 		if err != nil {
 			xTelemetry.Error(ctx, "HTTPAdapter::Start::Failed to start HTTP server", telemetry.String("Error", err.Error()))
 		}
@@ -68,71 +182,172 @@ func (a *HttpReceiver) Stop(ctx context.Context) error {
 	return nil
 }
 
-// Register a new endpoint
-func (a *HttpReceiver) RegisterEndPoint(ctx context.Context, endpointPath string, handler comms.HandlerFunc) error {
+// Register a new endpoint, endpoints can be registered before or after the receiver is started.
+// The path accepts any method, unless it's a method qualified pattern such as "GET /orders/{id}".
+// The middleware only runs for this endpoint, inside the global middleware added with Use
+func (a *HttpReceiver) RegisterEndPoint(ctx context.Context, endpointPath string, handler comms.HandlerFunc, middlewares ...comms.Middleware) error {
 	xTelemetry := telemetry.GetXTelemetryClient(ctx)
 	xTelemetry.Debug(ctx, "HTTPAdapter::RegisterEndPoint", telemetry.String("endpointPath", endpointPath))
 
+	// Wrap the handler with the telemetry logging and the endpoint's middleware
+	routeHandler := comms.Chain(handler, append([]comms.Middleware{requestTelemetry(endpointPath)}, middlewares...)...)
+
 	// Register the endpoint with the adapter function
-	http.HandleFunc(endpointPath, func(w http.ResponseWriter, r *http.Request) {
-		// Convert http.ResponseWriter to comms.ResponseWriter
-		commsWriter := &responseWriterAdapter{
-			w,
-			http.StatusOK,
-		}
+	httpHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Pick the context, writer and request passed down by the global middleware
+		state := r.Context().Value(routeStateKey{}).(*routeState)
+
+		// Values set by the middleware take precedence over the ones of the registration context
+		routeCtx := valuesContext{state.ctx, ctx}
+
+		state.ctx, state.err = routeHandler(routeCtx, state.w, &routedRequest{state.r, r})
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.routes[endpointPath]; ok {
+		err := errors.New("endpoint already registered: " + endpointPath)
+		xTelemetry.Error(ctx, "HTTPAdapter::RegisterEndPoint::Failed", telemetry.String("Error", err.Error()))
+		return err
+	}
+
+	routes := make(map[string]http.Handler, len(a.routes)+1)
+	for path, h := range a.routes {
+		routes[path] = h
+	}
+	routes[endpointPath] = httpHandler
 
-		// Convert *http.Request to comms.Request
-		commsReq := &requestAdapter{
-			r,
+	mux, err := buildServeMux(routes)
+	if err != nil {
+		xTelemetry.Error(ctx, "HTTPAdapter::RegisterEndPoint::Failed", telemetry.String("Error", err.Error()))
+		return err
+	}
+	a.routes = routes
+	a.mux = mux
+
+	return nil
+}
+
+// Register a handler for a method and path pattern, e.g. RegisterRoute(ctx, http.MethodGet, "/orders/{id}", handler).
+// Path parameters are available through comms.Request.PathParam. Requests to the pattern with another method
+// are answered with 405 and an Allow header listing the registered methods
+func (a *HttpReceiver) RegisterRoute(ctx context.Context, method string, pattern string, handler comms.HandlerFunc, middlewares ...comms.Middleware) error {
+	if method == "" {
+		return a.RegisterEndPoint(ctx, pattern, handler, middlewares...)
+	}
+
+	return a.RegisterEndPoint(ctx, method+" "+pattern, handler, middlewares...)
+}
+
+// Add global middleware, run in the given order for every request, including the ones that match no endpoint
+func (a *HttpReceiver) Use(middlewares ...comms.Middleware) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.middlewares = append(a.middlewares[:len(a.middlewares):len(a.middlewares)], middlewares...)
+}
+
+// Unregister an endpoint, using the same path or method qualified pattern it was registered with
+func (a *HttpReceiver) UnregisterEndPoint(ctx context.Context, endpointPath string) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+	xTelemetry.Debug(ctx, "HTTPAdapter::UnregisterEndPoint", telemetry.String("endpointPath", endpointPath))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.routes[endpointPath]; !ok {
+		err := errors.New("endpoint not registered: " + endpointPath)
+		xTelemetry.Error(ctx, "HTTPAdapter::UnregisterEndPoint::Failed", telemetry.String("Error", err.Error()))
+		return err
+	}
+
+	routes := make(map[string]http.Handler, len(a.routes))
+	for path, h := range a.routes {
+		if path != endpointPath {
+			routes[path] = h
 		}
+	}
+
+	mux, err := buildServeMux(routes)
+	if err != nil {
+		return err
+	}
+	a.routes = routes
+	a.mux = mux
 
-		// Call the handler function, wrapping the handler with telemetry logging
-		wrappedHandler := func(ctx context.Context, w comms.ResponseWriter, r comms.Request) {
-			// Generate a new OperationID and append to the context
-			operationID := uuid.New().String()
-			ctx = telemetry.SetOperationID(ctx, operationID)
-
-			// Get service name from context
-			serviceName := telemetry.GetServiceName(ctx)
-
-			startTime := time.Now()
-			// Call the original handler
-			newCtx, err := handler(ctx, w, r)
-
-			// Decide on the message based on the error
-			message := ""
-			if err == nil {
-				message = "Request processed successfully"
-			} else {
-				message = err.Error()
-			}
-
-			// Log telemetry after calling the original handler
-			statusCode := w.Status()
-			success := isSuccess(statusCode)
-			xTelemetry.Request(newCtx, http.MethodPost, endpointPath, startTime, time.Now(), strconv.Itoa(statusCode), success, serviceName, message)
+	return nil
+}
+
+// Build a new ServeMux with the given routes. http.ServeMux can't remove routes and panics on
+// invalid or conflicting patterns, so the mux is rebuilt on every change and panics are returned as errors
+func buildServeMux(routes map[string]http.Handler) (mux *http.ServeMux, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			mux = nil
+			err = fmt.Errorf("invalid endpoint: %v", r)
 		}
+	}()
 
-		// Call the wrapped handler
-		wrappedHandler(ctx, commsWriter, commsReq)
+	mux = http.NewServeMux()
+	for path, handler := range routes {
+		mux.Handle(path, handler)
+	}
 
-	})
+	return mux, nil
+}
 
-	return nil
+// Key of the routeState in the http.Request context
+type routeStateKey struct{}
+
+// routeState carries the middleware's context, writer and request through the mux to the endpoint, and the endpoint's result back
+type routeState struct {
+	ctx context.Context
+	w   comms.ResponseWriter
+	r   comms.Request
+	err error
 }
 
-// Check if the status code is a success status code
-func isSuccess(statusCode int) bool {
-	switch statusCode {
-	case http.StatusOK:
-		return true
-	case http.StatusCreated:
-		return true
-	case http.StatusAccepted:
-		return true
-	case http.StatusNoContent:
-		return true
-	default:
-		return false
+// valuesContext takes deadline and cancellation from the embedded context, and values from both contexts
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	if value := c.Context.Value(key); value != nil {
+		return value
 	}
+
+	return c.values.Value(key)
+}
+
+// routedRequest adds the path parameters matched by the mux to a request, possibly wrapped by middleware
+type routedRequest struct {
+	comms.Request
+	httpRequest *http.Request
+}
+
+// Get a path parameter, e.g. "id" for the pattern "/orders/{id}"
+func (r *routedRequest) PathParam(name string) string {
+	return r.httpRequest.PathValue(name)
+}
+
+// httpWriter exposes a comms.ResponseWriter, possibly wrapped by middleware, as an http.ResponseWriter for the mux
+type httpWriter struct {
+	comms.ResponseWriter
+	w http.ResponseWriter
+}
+
+func (h *httpWriter) Header() http.Header {
+	if header, ok := h.ResponseWriter.Header().(http.Header); ok {
+		return header
+	}
+
+	return h.w.Header()
+}
+
+// Check if the status code is a success status code, any 2xx
+func isSuccess(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
 }
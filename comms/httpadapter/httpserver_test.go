@@ -2,12 +2,17 @@ package httpadapter_test
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/perocha/goadapters/comms"
 	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -84,3 +89,197 @@ func TestHttpAdapter_RegisterEndPoint(t *testing.T) {
 	err = adapter.Stop(ctx)
 	assert.NoError(t, err)
 }
+
+func TestHttpAdapter_MultipleReceivers(t *testing.T) {
+	ctx := initializeTelemetry()
+	path := "/test"
+
+	// Two receivers in the same process can register the same path
+	first, err := httpadapter.HTTPServerAdapterInit(ctx, "8081")
+	assert.NoError(t, err)
+	second, err := httpadapter.HTTPServerAdapterInit(ctx, "8082")
+	assert.NoError(t, err)
+
+	err = first.RegisterEndPoint(ctx, path, func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+	err = second.RegisterEndPoint(ctx, path, func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusAccepted)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+
+	firstServer := httptest.NewServer(first)
+	defer firstServer.Close()
+	secondServer := httptest.NewServer(second)
+	defer secondServer.Close()
+
+	resp, err := http.Post(firstServer.URL+path, "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Post(secondServer.URL+path, "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+}
+
+func TestHttpAdapter_RegisterEndPoint_Duplicate(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "8080")
+	assert.NoError(t, err)
+
+	testHandler := func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		return ctx, nil
+	}
+
+	assert.NoError(t, adapter.RegisterEndPoint(ctx, "/test", testHandler))
+	assert.Error(t, adapter.RegisterEndPoint(ctx, "/test", testHandler))
+
+	// Invalid patterns are reported instead of panicking
+	assert.Error(t, adapter.RegisterEndPoint(ctx, "test", testHandler))
+}
+
+func TestHttpAdapter_UnregisterEndPoint(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "8080")
+	assert.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	// Routes can be added while serving
+	err = adapter.RegisterEndPoint(ctx, "/test", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+
+	resp, err := http.Post(server.URL+"/test", "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.NoError(t, adapter.UnregisterEndPoint(ctx, "/test"))
+	assert.Error(t, adapter.UnregisterEndPoint(ctx, "/test"))
+
+	resp, err = http.Post(server.URL+"/test", "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHttpAdapter_RegisterRoute(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "8080")
+	assert.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	err = adapter.RegisterRoute(ctx, http.MethodGet, "/orders/{id}", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(r.PathParam("id") + ":" + r.QueryParam("expand")))
+		return ctx, err
+	})
+	assert.NoError(t, err)
+	err = adapter.RegisterRoute(ctx, http.MethodDelete, "/orders/{id}", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusNoContent)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+
+	// Path and query parameters are exposed to the handler
+	resp, err := http.Get(server.URL + "/orders/42?expand=items")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "42:items", string(body))
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/orders/42", nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Other methods get 405 with the allowed methods
+	resp, err = http.Post(server.URL+"/orders/42", "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Allow"), http.MethodGet)
+	assert.Contains(t, resp.Header.Get("Allow"), http.MethodDelete)
+
+	// Routes are unregistered with their method qualified pattern
+	assert.NoError(t, adapter.UnregisterEndPoint(ctx, "DELETE /orders/{id}"))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestHttpAdapter_ContinuesSenderTrace(t *testing.T) {
+	ctx := initializeTelemetry()
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "0")
+	assert.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	var operationID string
+	var trace comms.TraceContext
+	err = adapter.RegisterEndPoint(ctx, "/trace", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		operationID, _ = ctx.Value(telemetry.OperationIDKeyContextKey).(string)
+		trace, _ = comms.TraceContextFromContext(ctx)
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+
+	sender, err := httpadapter.HttpSenderInit(ctx)
+	assert.NoError(t, err)
+
+	// The receiver continues the sender's operation and trace
+	caller := comms.NewTraceContext()
+	sendCtx := telemetry.SetOperationID(comms.WithTraceContext(ctx, caller), "operation-1")
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/trace")
+	err = sender.SendRequest(sendCtx, endpoint, messaging.NewMessage("", nil, "pending", "trace", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "operation-1", operationID)
+	assert.Equal(t, caller.TraceID, trace.TraceID)
+
+	// Requests without trace headers start a new operation
+	resp, err := http.Get(server.URL + "/trace")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.NotEmpty(t, operationID)
+	assert.NotEqual(t, "operation-1", operationID)
+	assert.NotEqual(t, caller.TraceID, trace.TraceID)
+}
+
+func TestHttpAdapter_OperationIDPerRequest(t *testing.T) {
+	// The application's startup OperationID is in the init and registration contexts
+	ctx := telemetry.SetOperationID(initializeTelemetry(), "startup")
+	adapter, err := httpadapter.HTTPServerAdapterInit(ctx, "0")
+	assert.NoError(t, err)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	operationIDs := []string{}
+	err = adapter.RegisterEndPoint(ctx, "/orders", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		operationID, _ := ctx.Value(telemetry.OperationIDKeyContextKey).(string)
+		operationIDs = append(operationIDs, operationID)
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(server.URL + "/orders")
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Every request gets its own OperationID
+	assert.Len(t, operationIDs, 2)
+	assert.NotEqual(t, "startup", operationIDs[0])
+	assert.NotEqual(t, "startup", operationIDs[1])
+	assert.NotEqual(t, operationIDs[0], operationIDs[1])
+}
@@ -0,0 +1,226 @@
+package httpadapter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Use the TLS configuration for https endpoints, e.g. to trust a private CA or present a client certificate.
+// It's combined with the other TLS options in order: the CAs and certificates set by earlier options
+// are kept unless the configuration sets its own
+func WithClientTLSConfig(config *tls.Config) SenderOption {
+	return func(a *HttpSender) error {
+		if config == nil {
+			return errors.New("TLS config is nil")
+		}
+
+		merged := config.Clone()
+		if previous := a.tlsConfig; previous != nil {
+			if merged.RootCAs == nil {
+				merged.RootCAs = previous.RootCAs
+			}
+			if len(merged.Certificates) == 0 && merged.GetClientCertificate == nil {
+				merged.Certificates = previous.Certificates
+			}
+			if merged.MinVersion == 0 {
+				merged.MinVersion = previous.MinVersion
+			}
+		}
+		a.tlsConfig = merged
+		return nil
+	}
+}
+
+// Trust the CA certificates in the PEM file, instead of the system roots, when calling https endpoints
+func WithRootCAFile(caFile string) SenderOption {
+	return func(a *HttpSender) error {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return err
+		}
+		a.clientTLSConfig().RootCAs = pool
+		return nil
+	}
+}
+
+// Present the client certificate when calling https endpoints that require mutual TLS
+func WithClientCertificate(certFile string, keyFile string) SenderOption {
+	return func(a *HttpSender) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config := a.clientTLSConfig()
+		config.Certificates = append(config.Certificates, cert)
+		return nil
+	}
+}
+
+// Get the sender's TLS config, creating it if needed
+func (a *HttpSender) clientTLSConfig() *tls.Config {
+	if a.tlsConfig == nil {
+		a.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return a.tlsConfig
+}
+
+// Serve https with the certificate and key PEM files. The files are reloaded when they change on disk,
+// so certificates can be renewed without restarting the receiver
+func WithServerTLS(certFile string, keyFile string) ReceiverOption {
+	return func(a *HttpReceiver) error {
+		reloader, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		a.serverTLSConfig().GetCertificate = reloader.GetCertificate
+		return nil
+	}
+}
+
+// Serve https with the TLS configuration. It's combined with the other TLS options in order: the certificate
+// and client verification set by earlier options are kept unless the configuration sets its own
+func WithServerTLSConfig(config *tls.Config) ReceiverOption {
+	return func(a *HttpReceiver) error {
+		if config == nil {
+			return errors.New("TLS config is nil")
+		}
+
+		merged := config.Clone()
+		if previous := a.tlsConfig; previous != nil {
+			if len(merged.Certificates) == 0 && merged.GetCertificate == nil && merged.GetConfigForClient == nil {
+				merged.Certificates = previous.Certificates
+				merged.GetCertificate = previous.GetCertificate
+			}
+			if merged.ClientCAs == nil && merged.ClientAuth == tls.NoClientCert {
+				merged.ClientCAs = previous.ClientCAs
+				merged.ClientAuth = previous.ClientAuth
+			}
+			if merged.MinVersion == 0 {
+				merged.MinVersion = previous.MinVersion
+			}
+		}
+		a.tlsConfig = merged
+		return nil
+	}
+}
+
+// Verify client certificates against the CA certificates in the PEM file (mutual TLS).
+// If required, clients without a valid certificate are rejected during the handshake
+func WithClientCAFile(caFile string, required bool) ReceiverOption {
+	return func(a *HttpReceiver) error {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return err
+		}
+		config := a.serverTLSConfig()
+		config.ClientCAs = pool
+		if required {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		return nil
+	}
+}
+
+// Get the receiver's TLS config, creating it if needed
+func (a *HttpReceiver) serverTLSConfig() *tls.Config {
+	if a.tlsConfig == nil {
+		a.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return a.tlsConfig
+}
+
+// Get the TLS configuration the receiver serves with, nil if it serves plain http
+func (a *HttpReceiver) TLSConfig() *tls.Config {
+	return a.tlsConfig
+}
+
+// Load the certificates of a PEM file into a pool
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in CA file: " + caFile)
+	}
+
+	return pool, nil
+}
+
+// certReloader serves a certificate from disk, reloading it when the files change
+type certReloader struct {
+	certFile    string
+	keyFile     string
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// Create a reloader, the certificate must be valid
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	reloader.cert = &cert
+	reloader.certModTime = certModTime
+	reloader.keyModTime = keyModTime
+
+	return reloader, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. The previous certificate is kept if the files can't be loaded,
+// e.g. while they are being replaced
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certModTime, keyModTime, err := r.modTimes()
+	if err != nil || (certModTime.Equal(r.certModTime) && keyModTime.Equal(r.keyModTime)) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err == nil {
+		r.cert = &cert
+		r.certModTime = certModTime
+		r.keyModTime = keyModTime
+	}
+
+	return r.cert, nil
+}
+
+// Get the modification times of the certificate and key files
+func (r *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
@@ -0,0 +1,266 @@
+package httpadapter_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+// Create a CA and write its certificate to the directory
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "goadapters test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	file := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// Issue a server or client certificate and write it to the directory, returning the certificate and key files
+func (ca *testCA) issue(t *testing.T, dir string, name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	return certFile, keyFile
+}
+
+// Find a free local port
+func freePort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Wait until the port accepts connections
+func waitForPort(t *testing.T, port string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.FailNow(t, "server didn't start")
+}
+
+// Get the serial number of the certificate served on the port
+func servedSerial(t *testing.T, caFile string, port string) int64 {
+	pem, err := os.ReadFile(caFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+
+	conn, err := tls.Dial("tcp", "127.0.0.1:"+port, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestHttpAdapter_MutualTLS(t *testing.T) {
+	ctx := initializeTelemetry()
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", 10, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", 20, x509.ExtKeyUsageClientAuth)
+
+	// Receiver serving https, requiring client certificates
+	port := freePort(t)
+	receiver, err := httpadapter.HTTPServerAdapterInit(ctx, port, httpadapter.WithServerTLS(serverCert, serverKey), httpadapter.WithClientCAFile(ca.file, true))
+	require.NoError(t, err)
+	assert.NotNil(t, receiver.TLSConfig())
+
+	err = receiver.RegisterEndPoint(ctx, "/secure", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, receiver.Start(ctx))
+	defer receiver.Stop(ctx)
+	waitForPort(t, port)
+
+	endpoint := httpadapter.NewEndpointWithScheme("https", "127.0.0.1", port, "/secure")
+	assert.Equal(t, "https://127.0.0.1:"+port+"/secure", endpoint.GetEndPoint())
+	msg := messaging.NewMessage("", nil, "pending", "secure", nil)
+
+	// Trusting the CA and presenting a client certificate
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithRootCAFile(ca.file), httpadapter.WithClientCertificate(clientCert, clientKey))
+	require.NoError(t, err)
+	assert.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+
+	// Without a client certificate the handshake fails
+	sender, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithRootCAFile(ca.file))
+	require.NoError(t, err)
+	assert.Error(t, sender.SendRequest(ctx, endpoint, msg))
+
+	// Without trusting the CA the server certificate is rejected
+	sender, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithClientCertificate(clientCert, clientKey))
+	require.NoError(t, err)
+	assert.Error(t, sender.SendRequest(ctx, endpoint, msg))
+
+	// Plain http to the https receiver fails
+	sender, err = httpadapter.HttpSenderInit(ctx)
+	require.NoError(t, err)
+	assert.Error(t, sender.SendRequest(ctx, httpadapter.NewEndpoint("127.0.0.1", port, "/secure"), msg))
+}
+
+func TestHttpAdapter_TLSOptionOrder(t *testing.T) {
+	ctx := initializeTelemetry()
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", 10, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", 20, x509.ExtKeyUsageClientAuth)
+
+	// A TLS config given after the certificate and client verification options keeps them
+	port := freePort(t)
+	receiver, err := httpadapter.HTTPServerAdapterInit(ctx, port,
+		httpadapter.WithServerTLS(serverCert, serverKey),
+		httpadapter.WithClientCAFile(ca.file, true),
+		httpadapter.WithServerTLSConfig(&tls.Config{MinVersion: tls.VersionTLS13}))
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, receiver.TLSConfig().ClientAuth)
+	assert.Equal(t, uint16(tls.VersionTLS13), receiver.TLSConfig().MinVersion)
+
+	err = receiver.RegisterEndPoint(ctx, "/secure", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, receiver.Start(ctx))
+	defer receiver.Stop(ctx)
+	waitForPort(t, port)
+
+	endpoint := httpadapter.NewEndpointWithScheme("https", "127.0.0.1", port, "/secure")
+	msg := messaging.NewMessage("", nil, "pending", "secure", nil)
+
+	// Same for the sender, in either order
+	for name, options := range map[string][]httpadapter.SenderOption{
+		"config last": {
+			httpadapter.WithRootCAFile(ca.file),
+			httpadapter.WithClientCertificate(clientCert, clientKey),
+			httpadapter.WithClientTLSConfig(&tls.Config{ServerName: "localhost"}),
+		},
+		"config first": {
+			httpadapter.WithClientTLSConfig(&tls.Config{ServerName: "localhost"}),
+			httpadapter.WithRootCAFile(ca.file),
+			httpadapter.WithClientCertificate(clientCert, clientKey),
+		},
+	} {
+		sender, err := httpadapter.HttpSenderInit(ctx, options...)
+		require.NoError(t, err, name)
+		assert.NoError(t, sender.SendRequest(ctx, endpoint, msg), name)
+	}
+
+	// The client certificate is still required
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithRootCAFile(ca.file), httpadapter.WithClientTLSConfig(&tls.Config{ServerName: "localhost"}))
+	require.NoError(t, err)
+	assert.Error(t, sender.SendRequest(ctx, endpoint, msg))
+}
+
+func TestHttpAdapter_TLSCertificateReload(t *testing.T) {
+	ctx := initializeTelemetry()
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", 10, x509.ExtKeyUsageServerAuth)
+
+	port := freePort(t)
+	receiver, err := httpadapter.HTTPServerAdapterInit(ctx, port, httpadapter.WithServerTLS(serverCert, serverKey))
+	require.NoError(t, err)
+	require.NoError(t, receiver.Start(ctx))
+	defer receiver.Stop(ctx)
+	waitForPort(t, port)
+
+	assert.Equal(t, int64(10), servedSerial(t, ca.file, port))
+
+	// Renew the certificate on disk, new connections get the new certificate
+	ca.issue(t, dir, "server", 11, x509.ExtKeyUsageServerAuth)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(serverCert, future, future))
+	require.NoError(t, os.Chtimes(serverKey, future, future))
+
+	assert.Equal(t, int64(11), servedSerial(t, ca.file, port))
+}
+
+func TestHttpAdapter_TLSOptionErrors(t *testing.T) {
+	ctx := initializeTelemetry()
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+
+	_, err := httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithServerTLS(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing-key.pem")))
+	assert.Error(t, err)
+
+	// Client verification alone doesn't provide a server certificate
+	_, err = httpadapter.HTTPServerAdapterInit(ctx, "0", httpadapter.WithClientCAFile(ca.file, true))
+	assert.Error(t, err)
+
+	_, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithRootCAFile(filepath.Join(dir, "missing.pem")))
+	assert.Error(t, err)
+
+	_, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithClientTLSConfig(nil))
+	assert.Error(t, err)
+}
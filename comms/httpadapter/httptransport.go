@@ -0,0 +1,253 @@
+package httpadapter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Default dial timeout and TCP keep-alive period, the same as http.DefaultTransport
+const defaultDialTimeout = 30 * time.Second
+
+// transportOptions tunes the sender's transport, zero values keep the http.DefaultTransport settings
+type transportOptions struct {
+	customized            bool
+	dialTimeout           time.Duration
+	keepAlive             time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	maxConnsPerHost       int
+	disableKeepAlives     bool
+	proxySet              bool
+	proxy                 func(*http.Request) (*url.URL, error)
+}
+
+// Limit the whole request, from dialing to reading the response body. Zero disables the timeout,
+// requests are then only limited by the context. Defaults to DefaultSenderTimeout
+func WithTimeout(timeout time.Duration) SenderOption {
+	return func(a *HttpSender) error {
+		if timeout < 0 {
+			return errors.New("timeout must not be negative")
+		}
+		a.timeout = timeout
+		return nil
+	}
+}
+
+// Limit the time to establish a connection
+func WithDialTimeout(timeout time.Duration) SenderOption {
+	return func(a *HttpSender) error {
+		if timeout <= 0 {
+			return errors.New("dial timeout must be positive")
+		}
+		a.transportOptions.customized = true
+		a.transportOptions.dialTimeout = timeout
+		return nil
+	}
+}
+
+// Limit the time of the TLS handshake
+func WithTLSHandshakeTimeout(timeout time.Duration) SenderOption {
+	return func(a *HttpSender) error {
+		if timeout <= 0 {
+			return errors.New("TLS handshake timeout must be positive")
+		}
+		a.transportOptions.customized = true
+		a.transportOptions.tlsHandshakeTimeout = timeout
+		return nil
+	}
+}
+
+// Limit the time waiting for the response headers, once the request is written
+func WithResponseHeaderTimeout(timeout time.Duration) SenderOption {
+	return func(a *HttpSender) error {
+		if timeout <= 0 {
+			return errors.New("response header timeout must be positive")
+		}
+		a.transportOptions.customized = true
+		a.transportOptions.responseHeaderTimeout = timeout
+		return nil
+	}
+}
+
+// Close idle pooled connections after the timeout
+func WithIdleConnTimeout(timeout time.Duration) SenderOption {
+	return func(a *HttpSender) error {
+		if timeout <= 0 {
+			return errors.New("idle connection timeout must be positive")
+		}
+		a.transportOptions.customized = true
+		a.transportOptions.idleConnTimeout = timeout
+		return nil
+	}
+}
+
+// Size the pool of idle connections, in total and per host
+func WithMaxIdleConns(total int, perHost int) SenderOption {
+	return func(a *HttpSender) error {
+		if total < 0 || perHost < 0 {
+			return errors.New("idle connection limits must not be negative")
+		}
+		if total > 0 && perHost > total {
+			return fmt.Errorf("idle connections per host (%d) exceed the total (%d)", perHost, total)
+		}
+		a.transportOptions.customized = true
+		a.transportOptions.maxIdleConns = total
+		a.transportOptions.maxIdleConnsPerHost = perHost
+		return nil
+	}
+}
+
+// Limit the connections per host, including the ones in use. Zero means no limit
+func WithMaxConnsPerHost(maxConns int) SenderOption {
+	return func(a *HttpSender) error {
+		if maxConns < 0 {
+			return errors.New("connections per host must not be negative")
+		}
+		a.transportOptions.customized = true
+		a.transportOptions.maxConnsPerHost = maxConns
+		return nil
+	}
+}
+
+// Set the TCP keep-alive period of the connections, a negative period disables TCP keep-alives
+func WithKeepAlive(period time.Duration) SenderOption {
+	return func(a *HttpSender) error {
+		if period == 0 {
+			return errors.New("keep-alive period must not be zero")
+		}
+		a.transportOptions.customized = true
+		a.transportOptions.keepAlive = period
+		return nil
+	}
+}
+
+// Disable HTTP keep-alives, every request uses a new connection
+func WithDisableKeepAlives() SenderOption {
+	return func(a *HttpSender) error {
+		a.transportOptions.customized = true
+		a.transportOptions.disableKeepAlives = true
+		return nil
+	}
+}
+
+// Send the requests through the proxy, e.g. "http://proxy:3128". By default the proxy is taken from the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+func WithProxy(proxyURL string) SenderOption {
+	return func(a *HttpSender) error {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch parsed.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return errors.New("proxy URL scheme must be http, https or socks5: " + proxyURL)
+		}
+		if parsed.Host == "" {
+			return errors.New("proxy URL has no host: " + proxyURL)
+		}
+		a.transportOptions.customized = true
+		a.transportOptions.proxySet = true
+		a.transportOptions.proxy = http.ProxyURL(parsed)
+		return nil
+	}
+}
+
+// Connect directly, ignoring the proxy environment variables
+func WithNoProxy() SenderOption {
+	return func(a *HttpSender) error {
+		a.transportOptions.customized = true
+		a.transportOptions.proxySet = true
+		a.transportOptions.proxy = nil
+		return nil
+	}
+}
+
+// Send the requests through a custom round tripper, e.g. for instrumentation or tests.
+// It can't be combined with the TLS and transport options, which configure the default transport
+func WithRoundTripper(roundTripper http.RoundTripper) SenderOption {
+	return func(a *HttpSender) error {
+		if roundTripper == nil {
+			return errors.New("round tripper is nil")
+		}
+		a.roundTripper = roundTripper
+		return nil
+	}
+}
+
+// Add a header to every request, e.g. a User-Agent. Headers set by the sender itself take precedence
+func WithDefaultHeader(key string, value string) SenderOption {
+	return func(a *HttpSender) error {
+		if key == "" {
+			return errors.New("header name is empty")
+		}
+		if a.defaultHeaders == nil {
+			a.defaultHeaders = make(http.Header)
+		}
+		a.defaultHeaders.Add(key, value)
+		return nil
+	}
+}
+
+// Build the transport of the sender's client, nil to use the default transport
+func (a *HttpSender) transport() (http.RoundTripper, error) {
+	options := a.transportOptions
+
+	if a.roundTripper != nil {
+		if options.customized || a.tlsConfig != nil {
+			return nil, errors.New("a custom round tripper can't be combined with TLS or transport options")
+		}
+		return a.roundTripper, nil
+	}
+	if !options.customized && a.tlsConfig == nil {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = a.tlsConfig
+
+	if options.dialTimeout > 0 || options.keepAlive != 0 {
+		dialer := &net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: defaultDialTimeout,
+		}
+		if options.dialTimeout > 0 {
+			dialer.Timeout = options.dialTimeout
+		}
+		if options.keepAlive != 0 {
+			dialer.KeepAlive = options.keepAlive
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	if options.tlsHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = options.tlsHandshakeTimeout
+	}
+	if options.responseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = options.responseHeaderTimeout
+	}
+	if options.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = options.idleConnTimeout
+	}
+	if options.maxIdleConns > 0 {
+		transport.MaxIdleConns = options.maxIdleConns
+	}
+	if options.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = options.maxIdleConnsPerHost
+	}
+	if options.maxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = options.maxConnsPerHost
+	}
+	transport.DisableKeepAlives = options.disableKeepAlives
+	if options.proxySet {
+		transport.Proxy = options.proxy
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,346 @@
+package httpadapter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// Headers of a signed webhook, as defined by Standard Webhooks (https://www.standardwebhooks.com)
+const (
+	WebhookIDHeader        = "webhook-id"
+	WebhookTimestampHeader = "webhook-timestamp"
+	WebhookSignatureHeader = "webhook-signature"
+)
+
+// Prefix of the webhook secrets
+const webhookSecretPrefix = "whsec_"
+
+// Default tolerance between the webhook timestamp and the receiver's clock
+const DefaultWebhookTolerance = 5 * time.Minute
+
+// ErrInvalidWebhookSignature is answered with 401 when a webhook isn't signed with any of the secrets,
+// is missing the signature headers or its timestamp is outside the tolerance
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// ErrWebhookReplayed is returned when a webhook ID was already processed, the request is acknowledged
+// with 200 without calling the handler
+var ErrWebhookReplayed = errors.New("webhook already processed")
+
+// ErrWebhookInProgress is returned when another delivery of the webhook ID is being processed, the request
+// is answered with 503 and a Retry-After, so the sender tries again once the first delivery completed or failed
+var ErrWebhookInProgress = errors.New("webhook is being processed")
+
+// Retry-After answered to the duplicates of a webhook being processed
+const webhookInProgressRetryAfter = 5 * time.Second
+
+// Generate a random webhook secret, "whsec_" followed by 32 base64 encoded bytes
+func NewWebhookSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return webhookSecretPrefix + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Decode "whsec_" prefixed (or bare) base64 secrets
+func parseWebhookSecrets(secrets []string) ([][]byte, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("no webhook secrets")
+	}
+
+	keys := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, webhookSecretPrefix))
+		if err != nil {
+			return nil, errors.New("webhook secret must be base64 encoded")
+		}
+		if len(key) == 0 {
+			return nil, errors.New("webhook secret is empty")
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Compute the signature of a webhook, HMAC-SHA256 over "id.timestamp.body"
+func webhookSignature(key []byte, id string, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	mac.Write([]byte("."))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Key of the webhook ID in the context
+type webhookIDContextKey struct{}
+
+// Set the ID of the webhook sent with the context. Retries of a delivery must keep the same ID, so the
+// receiver can detect duplicates. Without it, every request gets a new ID
+func WithWebhookID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, webhookIDContextKey{}, id)
+}
+
+// Sign every request as a Standard Webhooks webhook with the secrets ("whsec_..."). During a key rotation
+// pass both the old and the new secret, the request carries a signature for each
+func WithWebhookSigning(secrets ...string) SenderOption {
+	return func(a *HttpSender) error {
+		keys, err := parseWebhookSecrets(secrets)
+		if err != nil {
+			return err
+		}
+		a.webhookKeys = keys
+		return nil
+	}
+}
+
+// Set the webhook headers of the request
+func signWebhook(ctx context.Context, keys [][]byte, header http.Header, body []byte) {
+	id, _ := ctx.Value(webhookIDContextKey{}).(string)
+	if id == "" {
+		id = "msg_" + uuid.New().String()
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signatures := make([]string, len(keys))
+	for i, key := range keys {
+		signatures[i] = "v1," + base64.StdEncoding.EncodeToString(webhookSignature(key, id, timestamp, body))
+	}
+
+	header.Set(WebhookIDHeader, id)
+	header.Set(WebhookTimestampHeader, timestamp)
+	header.Set(WebhookSignatureHeader, strings.Join(signatures, " "))
+}
+
+// WebhookClaim is the outcome of claiming a webhook ID
+type WebhookClaim int
+
+const (
+	// WebhookClaimed means the ID is new, the webhook must be processed
+	WebhookClaimed WebhookClaim = iota
+	// WebhookInProgress means another delivery of the ID is being processed
+	WebhookInProgress
+	// WebhookCompleted means the ID was processed successfully
+	WebhookCompleted
+)
+
+// ReplayCache remembers the claimed webhook IDs. The default cache is in memory, replicated
+// receivers need a shared cache, e.g. Redis SET NX with an expiry
+type ReplayCache interface {
+	// Claim the webhook ID until it expires (its timestamp is then outside the tolerance), or report whether
+	// it's being or was processed. Claims must be atomic, so concurrent duplicates are processed once
+	Claim(ctx context.Context, id string, expiresAt time.Time) (WebhookClaim, error)
+	// Mark the claimed webhook as processed successfully, keeping its expiry
+	Complete(ctx context.Context, id string) error
+	// Release the claim of a webhook that failed, so it can be retried with the same ID
+	Release(ctx context.Context, id string) error
+}
+
+// WebhookVerifyOptions configures the verification of signed webhooks
+type WebhookVerifyOptions struct {
+	// Secrets accepted ("whsec_..."), a webhook signed with any of them is valid
+	Secrets []string
+	// Tolerance between the webhook timestamp and the receiver's clock, defaults to DefaultWebhookTolerance
+	Tolerance time.Duration
+	// ReplayCache remembers the webhook IDs being or already processed, defaults to an in-memory cache
+	ReplayCache ReplayCache
+}
+
+// Verify the Standard Webhooks signature of the requests, answering 401 when it's invalid. Webhooks
+// whose ID was processed successfully are acknowledged with 200 without calling the handler, so both replays
+// and duplicate deliveries are ignored. Duplicates arriving while the ID is being processed are answered with
+// 503 and a Retry-After, since the first delivery may still fail
+func VerifyWebhook(options WebhookVerifyOptions) (comms.Middleware, error) {
+	keys, err := parseWebhookSecrets(options.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	if options.Tolerance < 0 {
+		return nil, errors.New("tolerance can't be negative")
+	}
+	if options.Tolerance == 0 {
+		options.Tolerance = DefaultWebhookTolerance
+	}
+	if options.ReplayCache == nil {
+		options.ReplayCache = newMemoryReplayCache()
+	}
+
+	return func(next comms.HandlerFunc) comms.HandlerFunc {
+		return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+			xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+			body, err := r.ReadBody()
+			if err != nil {
+				status := StatusBadRequest
+				if errors.Is(err, comms.ErrBodyTooLarge) {
+					status = StatusRequestEntityTooLarge
+				}
+				_ = WriteError(w, status, err)
+				return ctx, err
+			}
+
+			id := r.Header(WebhookIDHeader)
+			timestamp, err := verifyWebhookSignature(keys, options.Tolerance, id, r.Header(WebhookTimestampHeader), r.Header(WebhookSignatureHeader), body)
+			if err != nil {
+				xTelemetry.Warn(ctx, "HTTPAdapter::VerifyWebhook::Invalid signature", telemetry.String("Path", r.URL().Path), telemetry.String("WebhookID", id), telemetry.String("Error", err.Error()))
+				_ = WriteError(w, StatusUnauthorized, ErrInvalidWebhookSignature)
+				return ctx, ErrInvalidWebhookSignature
+			}
+
+			claim, err := options.ReplayCache.Claim(ctx, id, timestamp.Add(options.Tolerance))
+			if err != nil {
+				xTelemetry.Error(ctx, "HTTPAdapter::VerifyWebhook::Failed to claim webhook", telemetry.String("WebhookID", id), telemetry.String("Error", err.Error()))
+				_ = WriteError(w, StatusServiceUnavailable, nil)
+				return ctx, err
+			}
+			switch claim {
+			case WebhookCompleted:
+				xTelemetry.Info(ctx, "HTTPAdapter::VerifyWebhook::Webhook already processed", telemetry.String("Path", r.URL().Path), telemetry.String("WebhookID", id))
+				w.WriteHeader(http.StatusOK)
+				return ctx, ErrWebhookReplayed
+			case WebhookInProgress:
+				xTelemetry.Info(ctx, "HTTPAdapter::VerifyWebhook::Webhook being processed", telemetry.String("Path", r.URL().Path), telemetry.String("WebhookID", id))
+				w.Header().Set("Retry-After", strconv.Itoa(int(webhookInProgressRetryAfter.Seconds())))
+				_ = WriteError(w, StatusServiceUnavailable, ErrWebhookInProgress)
+				return ctx, ErrWebhookInProgress
+			}
+
+			// Complete the claim only once processed, failed (or panicking) deliveries can be retried with the same ID
+			processed := false
+			defer func() {
+				releaseCtx := context.WithoutCancel(ctx)
+				if processed {
+					if completeErr := options.ReplayCache.Complete(releaseCtx, id); completeErr != nil {
+						xTelemetry.Error(ctx, "HTTPAdapter::VerifyWebhook::Failed to complete webhook", telemetry.String("WebhookID", id), telemetry.String("Error", completeErr.Error()))
+					}
+					return
+				}
+				if releaseErr := options.ReplayCache.Release(releaseCtx, id); releaseErr != nil {
+					xTelemetry.Error(ctx, "HTTPAdapter::VerifyWebhook::Failed to release webhook", telemetry.String("WebhookID", id), telemetry.String("Error", releaseErr.Error()))
+				}
+			}()
+
+			newCtx, err := next(ctx, w, r)
+			processed = err == nil && isSuccess(w.Status())
+
+			return newCtx, err
+		}
+	}, nil
+}
+
+// Check the headers and the signature of a webhook, returning its timestamp
+func verifyWebhookSignature(keys [][]byte, tolerance time.Duration, id string, rawTimestamp string, signatures string, body []byte) (time.Time, error) {
+	if id == "" || rawTimestamp == "" || signatures == "" {
+		return time.Time{}, errors.New("missing webhook headers")
+	}
+
+	seconds, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("invalid webhook timestamp")
+	}
+	timestamp := time.Unix(seconds, 0)
+	if age := time.Since(timestamp); age > tolerance || age < -tolerance {
+		return time.Time{}, errors.New("webhook timestamp outside the tolerance")
+	}
+
+	for _, signature := range strings.Fields(signatures) {
+		version, encoded, found := strings.Cut(signature, ",")
+		if !found || version != "v1" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			if hmac.Equal(decoded, webhookSignature(key, id, rawTimestamp, body)) {
+				return timestamp, nil
+			}
+		}
+	}
+
+	return time.Time{}, errors.New("no matching signature")
+}
+
+// How often expired IDs are removed from the in-memory replay cache
+const replayCachePruneInterval = time.Minute
+
+// memoryReplayCache remembers webhook IDs in memory
+type memoryReplayCache struct {
+	mu       sync.Mutex
+	ids      map[string]replayEntry
+	prunedAt time.Time
+}
+
+// replayEntry is a claimed webhook ID
+type replayEntry struct {
+	expiresAt time.Time
+	completed bool
+}
+
+func newMemoryReplayCache() *memoryReplayCache {
+	return &memoryReplayCache{
+		ids:      make(map[string]replayEntry),
+		prunedAt: time.Now(),
+	}
+}
+
+func (c *memoryReplayCache) Claim(ctx context.Context, id string, expiresAt time.Time) (WebhookClaim, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.prunedAt) >= replayCachePruneInterval {
+		for key, entry := range c.ids {
+			if !now.Before(entry.expiresAt) {
+				delete(c.ids, key)
+			}
+		}
+		c.prunedAt = now
+	}
+
+	if entry, ok := c.ids[id]; ok && now.Before(entry.expiresAt) {
+		if entry.completed {
+			return WebhookCompleted, nil
+		}
+		return WebhookInProgress, nil
+	}
+	c.ids[id] = replayEntry{expiresAt: expiresAt}
+
+	return WebhookClaimed, nil
+}
+
+func (c *memoryReplayCache) Complete(ctx context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.ids[id]; ok {
+		entry.completed = true
+		c.ids[id] = entry
+	}
+	return nil
+}
+
+func (c *memoryReplayCache) Release(ctx context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.ids, id)
+	return nil
+}
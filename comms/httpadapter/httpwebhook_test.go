@@ -0,0 +1,218 @@
+package httpadapter_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Start a receiver verifying webhooks on /webhooks, the handler fails while fail is set
+func newWebhookServer(t *testing.T, ctx context.Context, options httpadapter.WebhookVerifyOptions, calls *atomic.Int32, fail *atomic.Bool) string {
+	adapter, server := newMiddlewareServer(t, ctx)
+
+	verify, err := httpadapter.VerifyWebhook(options)
+	require.NoError(t, err)
+	err = adapter.RegisterEndPoint(ctx, "/webhooks", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		calls.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return ctx, errors.New("processing failed")
+		}
+		// The body can still be read by the handler
+		assert.NotEmpty(t, r.Body())
+		w.WriteHeader(http.StatusNoContent)
+		return ctx, nil
+	}, verify)
+	require.NoError(t, err)
+
+	return strings.Split(server.URL, ":")[2]
+}
+
+func TestWebhook_SignAndVerify(t *testing.T) {
+	ctx := initializeTelemetry()
+	secret, err := httpadapter.NewWebhookSecret()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, "whsec_"))
+
+	var calls atomic.Int32
+	var fail atomic.Bool
+	port := newWebhookServer(t, ctx, httpadapter.WebhookVerifyOptions{Secrets: []string{secret}}, &calls, &fail)
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", port, "/webhooks")
+	msg := messaging.NewMessage("", nil, "pending", "create", []byte("order"))
+
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithWebhookSigning(secret))
+	require.NoError(t, err)
+	assert.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+	assert.NoError(t, sender.SendRequest(ctx, endpoint, msg))
+	assert.Equal(t, int32(2), calls.Load())
+
+	// A failed delivery can be retried with the same ID, once processed duplicates are acknowledged without calling the handler
+	deliveryCtx := httpadapter.WithWebhookID(ctx, "msg_1")
+	fail.Store(true)
+	assert.Error(t, sender.SendRequest(deliveryCtx, endpoint, msg))
+	fail.Store(false)
+	assert.NoError(t, sender.SendRequest(deliveryCtx, endpoint, msg))
+	assert.NoError(t, sender.SendRequest(deliveryCtx, endpoint, msg))
+	assert.Equal(t, int32(4), calls.Load())
+
+	// Unsigned and wrongly signed requests are rejected
+	unsigned, err := httpadapter.HttpSenderInit(ctx)
+	require.NoError(t, err)
+	var statusErr *httpadapter.StatusError
+	require.True(t, errors.As(unsigned.SendRequest(ctx, endpoint, msg), &statusErr))
+	assert.Equal(t, httpadapter.StatusUnauthorized, statusErr.StatusCode)
+
+	otherSecret, err := httpadapter.NewWebhookSecret()
+	require.NoError(t, err)
+	wrong, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithWebhookSigning(otherSecret))
+	require.NoError(t, err)
+	require.True(t, errors.As(wrong.SendRequest(ctx, endpoint, msg), &statusErr))
+	assert.Equal(t, httpadapter.StatusUnauthorized, statusErr.StatusCode)
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestWebhook_KeyRotation(t *testing.T) {
+	ctx := initializeTelemetry()
+	oldSecret, err := httpadapter.NewWebhookSecret()
+	require.NoError(t, err)
+	newSecret, err := httpadapter.NewWebhookSecret()
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	var fail atomic.Bool
+	msg := messaging.NewMessage("", nil, "pending", "create", []byte("order"))
+
+	// The sender signs with both secrets while receivers move from the old to the new one
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithWebhookSigning(oldSecret, newSecret))
+	require.NoError(t, err)
+	for _, secret := range []string{oldSecret, newSecret} {
+		port := newWebhookServer(t, ctx, httpadapter.WebhookVerifyOptions{Secrets: []string{secret}}, &calls, &fail)
+		assert.NoError(t, sender.SendRequest(ctx, httpadapter.NewEndpoint("127.0.0.1", port, "/webhooks"), msg))
+	}
+
+	// A receiver accepting both secrets takes webhooks signed with either
+	port := newWebhookServer(t, ctx, httpadapter.WebhookVerifyOptions{Secrets: []string{oldSecret, newSecret}}, &calls, &fail)
+	for _, secret := range []string{oldSecret, newSecret} {
+		sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithWebhookSigning(secret))
+		require.NoError(t, err)
+		assert.NoError(t, sender.SendRequest(ctx, httpadapter.NewEndpoint("127.0.0.1", port, "/webhooks"), msg))
+	}
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestWebhook_Tolerance(t *testing.T) {
+	ctx := initializeTelemetry()
+	secret, err := httpadapter.NewWebhookSecret()
+	require.NoError(t, err)
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	var fail atomic.Bool
+	port := newWebhookServer(t, ctx, httpadapter.WebhookVerifyOptions{Secrets: []string{secret}, Tolerance: time.Minute}, &calls, &fail)
+
+	// Send a webhook signed at the given time
+	send := func(signedAt time.Time) int {
+		body := `{"order":"42"}`
+		timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("msg_" + timestamp + "." + timestamp + "." + body))
+
+		req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:"+port+"/webhooks", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set(httpadapter.WebhookIDHeader, "msg_"+timestamp)
+		req.Header.Set(httpadapter.WebhookTimestampHeader, timestamp)
+		req.Header.Set(httpadapter.WebhookSignatureHeader, "v1,invalid v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusNoContent, send(time.Now().Add(-30*time.Second)))
+	assert.Equal(t, http.StatusUnauthorized, send(time.Now().Add(-2*time.Minute)))
+	assert.Equal(t, http.StatusUnauthorized, send(time.Now().Add(2*time.Minute)))
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestWebhook_ConcurrentDuplicates(t *testing.T) {
+	ctx := initializeTelemetry()
+	secret, err := httpadapter.NewWebhookSecret()
+	require.NoError(t, err)
+
+	// The handler blocks until the duplicates are answered, then fails the first time
+	adapter, server := newMiddlewareServer(t, ctx)
+	verify, err := httpadapter.VerifyWebhook(httpadapter.WebhookVerifyOptions{Secrets: []string{secret}})
+	require.NoError(t, err)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	err = adapter.RegisterEndPoint(ctx, "/webhooks", func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		if calls.Add(1) == 1 {
+			<-release
+			w.WriteHeader(http.StatusInternalServerError)
+			return ctx, nil
+		}
+		w.WriteHeader(http.StatusNonAuthoritativeInfo)
+		return ctx, nil
+	}, verify)
+	require.NoError(t, err)
+
+	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithWebhookSigning(secret))
+	require.NoError(t, err)
+	endpoint := httpadapter.NewEndpoint("127.0.0.1", strings.Split(server.URL, ":")[2], "/webhooks")
+	deliveryCtx := httpadapter.WithWebhookID(ctx, "msg_1")
+	msg := messaging.NewMessage("", nil, "pending", "create", []byte("order"))
+
+	const duplicates = 5
+	results := make(chan error, duplicates)
+	for i := 0; i < duplicates; i++ {
+		go func() { results <- sender.SendRequest(deliveryCtx, endpoint, msg) }()
+	}
+
+	// Duplicates arriving while the first delivery is processed are asked to retry, since it may still fail
+	for i := 0; i < duplicates-1; i++ {
+		select {
+		case err := <-results:
+			var statusErr *httpadapter.StatusError
+			require.True(t, errors.As(err, &statusErr))
+			assert.Equal(t, httpadapter.StatusServiceUnavailable, statusErr.StatusCode)
+			assert.Equal(t, 5*time.Second, statusErr.RetryAfter())
+			assert.True(t, statusErr.Retryable())
+		case <-time.After(5 * time.Second):
+			t.Fatal("duplicate not answered")
+		}
+	}
+	close(release)
+	assert.Error(t, <-results)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// The failed delivery is retried, any 2xx completes it and later duplicates are acknowledged
+	assert.NoError(t, sender.SendRequest(deliveryCtx, endpoint, msg))
+	assert.NoError(t, sender.SendRequest(deliveryCtx, endpoint, msg))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestWebhook_InvalidOptions(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	_, err := httpadapter.VerifyWebhook(httpadapter.WebhookVerifyOptions{})
+	assert.Error(t, err)
+	_, err = httpadapter.VerifyWebhook(httpadapter.WebhookVerifyOptions{Secrets: []string{"whsec_not base64"}})
+	assert.Error(t, err)
+	_, err = httpadapter.HttpSenderInit(ctx, httpadapter.WithWebhookSigning())
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package comms
+
+import "context"
+
+// Principal is the authenticated caller of a request, set in the handler's context by authentication middleware
+type Principal struct {
+	// Subject identifies the caller, e.g. the "sub" claim of a token or the name of an API key
+	Subject string
+	// Issuer of the credentials, empty for API keys
+	Issuer string
+	// Method used to authenticate, e.g. "jwt" or "apikey"
+	Method string
+	Scopes []string
+	Roles  []string
+	// Claims of the token, nil for API keys
+	Claims map[string]interface{}
+}
+
+// Check if the principal was granted the scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if the principal has the role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Key of the principal in the context
+type principalContextKey struct{}
+
+// Add the principal to the context
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// Get the principal from the context, false if the request wasn't authenticated
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
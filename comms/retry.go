@@ -0,0 +1,18 @@
+package comms
+
+import "errors"
+
+// Check if retrying could fix the error. Errors reporting it with a Retryable() bool method decide
+// (e.g. a 4xx status isn't retryable, see httpadapter.StatusError), every other error is retryable
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var retryable interface{ Retryable() bool }
+	if errors.As(err, &retryable) {
+		return retryable.Retryable()
+	}
+
+	return true
+}
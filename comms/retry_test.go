@@ -0,0 +1,30 @@
+package comms_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/stretchr/testify/assert"
+)
+
+// classifiedError reports whether it's retryable
+type classifiedError bool
+
+func (e classifiedError) Error() string {
+	return "classified"
+}
+
+func (e classifiedError) Retryable() bool {
+	return bool(e)
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.False(t, comms.IsRetryable(nil))
+	assert.True(t, comms.IsRetryable(errors.New("connection reset")))
+	assert.True(t, comms.IsRetryable(context.DeadlineExceeded))
+	assert.True(t, comms.IsRetryable(fmt.Errorf("send: %w", classifiedError(true))))
+	assert.False(t, comms.IsRetryable(fmt.Errorf("send: %w", classifiedError(false))))
+}
@@ -0,0 +1,196 @@
+package comms
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+const (
+	// W3C trace context headers, see https://www.w3.org/TR/trace-context/
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+	// Header carrying the OperationID of the caller
+	OperationIDHeader = "X-Operation-ID"
+)
+
+// Longest OperationID accepted from a caller's header
+const maxOperationIDLength = 128
+
+// ErrInvalidTraceParent is returned when parsing a malformed traceparent header
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+// TraceContext identifies a span of a distributed trace
+type TraceContext struct {
+	// 32 lowercase hex characters, shared by every span of the trace
+	TraceID string
+	// 16 lowercase hex characters, identifying the span
+	SpanID string
+	// Trace flags, 01 when the trace is sampled
+	Flags byte
+	// Vendor specific tracestate, propagated as is
+	State string
+}
+
+// Key of the TraceContext in the context
+type traceContextKey struct{}
+
+// Key of the OperationID set for the current request, see WithRequestOperationID
+type requestOperationIDKey struct{}
+
+// Start a new trace, with random trace and span IDs
+func NewTraceContext() TraceContext {
+	return TraceContext{
+		TraceID: randomHex(16),
+		SpanID:  randomHex(8),
+		Flags:   1,
+	}
+}
+
+// Start a child span in the same trace
+func (t TraceContext) NewChild() TraceContext {
+	t.SpanID = randomHex(8)
+	return t
+}
+
+// Format the traceparent header value, e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+func (t TraceContext) TraceParent() string {
+	return "00-" + t.TraceID + "-" + t.SpanID + "-" + hex.EncodeToString([]byte{t.Flags})
+}
+
+// Parse a traceparent header value. Future versions are accepted as long as they start with the version 00 fields
+func ParseTraceParent(value string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, ErrInvalidTraceParent
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return TraceContext{}, ErrInvalidTraceParent
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return TraceContext{}, ErrInvalidTraceParent
+	}
+	if !isLowerHex(spanID, 16) || spanID == strings.Repeat("0", 16) {
+		return TraceContext{}, ErrInvalidTraceParent
+	}
+	if !isLowerHex(flags, 2) {
+		return TraceContext{}, ErrInvalidTraceParent
+	}
+
+	flagBytes, _ := hex.DecodeString(flags)
+	return TraceContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Flags:   flagBytes[0],
+	}, nil
+}
+
+// Add the trace context to the context
+func WithTraceContext(ctx context.Context, trace TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// Get the trace context from the context
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	trace, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return trace, ok
+}
+
+// Set the trace headers of an outgoing request: a child span of the context's trace (or a new trace) and
+// the context's OperationID. A new trace reuses the OperationID as trace ID when it's a valid one
+func InjectTrace(ctx context.Context, header Header) {
+	operationID, _ := ctx.Value(telemetry.OperationIDKeyContextKey).(string)
+
+	trace, ok := TraceContextFromContext(ctx)
+	if ok {
+		trace = trace.NewChild()
+	} else {
+		trace = NewTraceContext()
+		if isLowerHex(operationID, 32) {
+			trace.TraceID = operationID
+		}
+	}
+
+	header.Set(TraceParentHeader, trace.TraceParent())
+	if trace.State != "" {
+		header.Set(TraceStateHeader, trace.State)
+	}
+	if operationID != "" {
+		header.Set(OperationIDHeader, operationID)
+	}
+}
+
+// Set the OperationID of the current request, e.g. from request ID middleware. Unlike an OperationID
+// inherited from a longer lived context, it's kept by ExtractTrace
+func WithRequestOperationID(ctx context.Context, operationID string) context.Context {
+	ctx = telemetry.SetOperationID(ctx, operationID)
+	return context.WithValue(ctx, requestOperationIDKey{}, operationID)
+}
+
+// Continue the trace of an incoming request, the context gets a child span of the caller's trace (or a new trace)
+// and an OperationID. The OperationID set for the request with WithRequestOperationID is kept, otherwise it's
+// the caller's OperationID (if it's valid), the incoming trace ID or a new trace ID, in this order.
+// Any other OperationID in the context, e.g. the one of the application's startup, is replaced
+func ExtractTrace(ctx context.Context, header Header) context.Context {
+	trace, err := ParseTraceParent(header.Get(TraceParentHeader))
+	incoming := err == nil
+	if incoming {
+		trace = trace.NewChild()
+		trace.State = strings.Join(header.Values(TraceStateHeader), ",")
+	} else {
+		trace = NewTraceContext()
+	}
+	ctx = WithTraceContext(ctx, trace)
+
+	operationID, _ := ctx.Value(telemetry.OperationIDKeyContextKey).(string)
+	if requestOperationID, _ := ctx.Value(requestOperationIDKey{}).(string); operationID != "" && operationID == requestOperationID {
+		return ctx
+	}
+	if operationID := header.Get(OperationIDHeader); IsValidOperationID(operationID) {
+		return telemetry.SetOperationID(ctx, operationID)
+	}
+
+	return telemetry.SetOperationID(ctx, trace.TraceID)
+}
+
+// Check an OperationID received from a caller is short and made of letters, digits and "-", "_", ".", ":",
+// so it can be logged and echoed as is
+func IsValidOperationID(value string) bool {
+	if value == "" || len(value) > maxOperationIDLength {
+		return false
+	}
+	for _, c := range value {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check the value is made of n lowercase hex characters
+func isLowerHex(value string, n int) bool {
+	if len(value) != n {
+		return false
+	}
+	for _, c := range value {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Generate n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,99 @@
+package comms_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goutils/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	trace, err := comms.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", trace.SpanID)
+	assert.Equal(t, byte(1), trace.Flags)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", trace.TraceParent())
+
+	// Future versions may append fields
+	_, err = comms.ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.NoError(t, err)
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	}
+	for _, value := range invalid {
+		_, err := comms.ParseTraceParent(value)
+		assert.ErrorIs(t, err, comms.ErrInvalidTraceParent, value)
+	}
+}
+
+func TestInjectExtractTrace(t *testing.T) {
+	// The caller is in the middle of a trace
+	caller := comms.NewTraceContext()
+	caller.State = "vendor=value"
+	ctx := comms.WithTraceContext(context.Background(), caller)
+	ctx = telemetry.SetOperationID(ctx, "operation-1")
+
+	header := http.Header{}
+	comms.InjectTrace(ctx, header)
+	assert.Equal(t, "operation-1", header.Get(comms.OperationIDHeader))
+	assert.Equal(t, "vendor=value", header.Get(comms.TraceStateHeader))
+
+	// The receiver continues the trace in a child span
+	received := comms.ExtractTrace(context.Background(), header)
+	trace, ok := comms.TraceContextFromContext(received)
+	assert.True(t, ok)
+	assert.Equal(t, caller.TraceID, trace.TraceID)
+	assert.NotEqual(t, caller.SpanID, trace.SpanID)
+	assert.Equal(t, "vendor=value", trace.State)
+	assert.Equal(t, "operation-1", received.Value(telemetry.OperationIDKeyContextKey))
+
+	// Without an OperationID header, the trace ID is the OperationID
+	header.Del(comms.OperationIDHeader)
+	received = comms.ExtractTrace(context.Background(), header)
+	assert.Equal(t, caller.TraceID, received.Value(telemetry.OperationIDKeyContextKey))
+
+	// The OperationID set for the request is kept, others in the context aren't
+	received = comms.ExtractTrace(comms.WithRequestOperationID(context.Background(), "local"), header)
+	assert.Equal(t, "local", received.Value(telemetry.OperationIDKeyContextKey))
+	received = comms.ExtractTrace(telemetry.SetOperationID(context.Background(), "startup"), header)
+	assert.Equal(t, caller.TraceID, received.Value(telemetry.OperationIDKeyContextKey))
+
+	// Invalid OperationID headers are ignored
+	for _, operationID := range []string{strings.Repeat("a", 129), "id with spaces", "id\nforged-log-line"} {
+		header.Set(comms.OperationIDHeader, operationID)
+		received = comms.ExtractTrace(context.Background(), header)
+		assert.Equal(t, caller.TraceID, received.Value(telemetry.OperationIDKeyContextKey))
+	}
+	header.Del(comms.OperationIDHeader)
+
+	// Without trace headers a new trace is started
+	received = comms.ExtractTrace(context.Background(), http.Header{})
+	trace, ok = comms.TraceContextFromContext(received)
+	assert.True(t, ok)
+	assert.NotEqual(t, caller.TraceID, trace.TraceID)
+	assert.Equal(t, trace.TraceID, received.Value(telemetry.OperationIDKeyContextKey))
+}
+
+func TestInjectTrace_NewTraceFromOperationID(t *testing.T) {
+	ctx := telemetry.SetOperationID(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	header := http.Header{}
+	comms.InjectTrace(ctx, header)
+
+	trace, err := comms.ParseTraceParent(header.Get(comms.TraceParentHeader))
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.TraceID)
+}
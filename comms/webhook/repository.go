@@ -0,0 +1,260 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/perocha/goadapters/database"
+)
+
+// Index documents of the repository store
+const (
+	pendingIndexID          = "webhook-pending"
+	subscriberIndexIDPrefix = "webhook-subscriber-"
+)
+
+// Deliveries kept in a subscriber's history index, older ones are dropped from it so the index document
+// stays well below the item size limit. The dropped deliveries can still be read with Get
+const maxSubscriberHistory = 1000
+
+// RepositoryStore keeps the deliveries as documents of a database.DBRepository, e.g. a Cosmos DB container.
+// Repositories can't be queried, so the store also keeps index documents with the IDs of the pending deliveries
+// and of every subscriber's latest deliveries. Every document has a "partitionKey" field with the store's partition key,
+// the container must be partitioned on /partitionKey.
+// The indexes are updated by a single process, only one dispatcher can use a partition key at a time
+type RepositoryStore struct {
+	repository   database.DBRepository
+	partitionKey string
+	mu           sync.Mutex
+}
+
+// indexDocument lists delivery IDs
+type indexDocument struct {
+	ID           string   `json:"id"`
+	PartitionKey string   `json:"partitionKey"`
+	DeliveryIDs  []string `json:"deliveryIDs"`
+}
+
+// deliveryDocument is a delivery with its partition key
+type deliveryDocument struct {
+	*Delivery
+	PartitionKey string `json:"partitionKey"`
+}
+
+// Create a store keeping its documents under the partition key
+func NewRepositoryStore(repository database.DBRepository, partitionKey string) (*RepositoryStore, error) {
+	if repository == nil {
+		return nil, errors.New("repository is nil")
+	}
+	if partitionKey == "" {
+		return nil, errors.New("partition key is empty")
+	}
+
+	return &RepositoryStore{repository: repository, partitionKey: partitionKey}, nil
+}
+
+// Save a delivery and update the indexes. The documents can't be written atomically, so a pending delivery
+// is indexed before it's written and a completed one is removed from the index after: a crash in between
+// leaves a stale ID in the pending index, never a pending delivery out of it. Pending drops the stale IDs
+func (s *RepositoryStore) Save(ctx context.Context, delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if delivery.Status == Pending {
+		if err := s.addToIndex(ctx, pendingIndexID, delivery.ID, 0); err != nil {
+			return err
+		}
+	}
+	if err := s.addToIndex(ctx, subscriberIndexIDPrefix+delivery.SubscriberID, delivery.ID, maxSubscriberHistory); err != nil {
+		return err
+	}
+
+	if err := s.put(ctx, delivery.ID, deliveryDocument{Delivery: delivery, PartitionKey: s.partitionKey}); err != nil {
+		return err
+	}
+
+	if delivery.Status != Pending {
+		return s.removeFromIndex(ctx, pendingIndexID, delivery.ID)
+	}
+
+	return nil
+}
+
+func (s *RepositoryStore) Get(ctx context.Context, id string) (*Delivery, error) {
+	var delivery Delivery
+	if err := s.get(ctx, id, &delivery); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrDeliveryNotFound
+		}
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (s *RepositoryStore) Pending(ctx context.Context) ([]*Delivery, error) {
+	deliveries, stale, err := s.deliveries(ctx, pendingIndexID, func(d *Delivery) bool { return d.Status == Pending })
+	if err != nil {
+		return nil, err
+	}
+	if len(stale) != 0 {
+		if err := s.dropStale(ctx, stale); err != nil {
+			return nil, err
+		}
+	}
+
+	return deliveries, nil
+}
+
+// History returns the subscriber's latest deliveries, up to maxSubscriberHistory
+func (s *RepositoryStore) History(ctx context.Context, subscriberID string) ([]*Delivery, error) {
+	deliveries, _, err := s.deliveries(ctx, subscriberIndexIDPrefix+subscriberID, nil)
+	return deliveries, err
+}
+
+// Get the deliveries listed in an index that match, oldest first, and the IDs that are missing or don't match
+func (s *RepositoryStore) deliveries(ctx context.Context, indexID string, match func(d *Delivery) bool) ([]*Delivery, []string, error) {
+	s.mu.Lock()
+	index, err := s.index(ctx, indexID)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deliveries := make([]*Delivery, 0, len(index.DeliveryIDs))
+	var stale []string
+	for _, id := range index.DeliveryIDs {
+		delivery, err := s.Get(ctx, id)
+		if errors.Is(err, ErrDeliveryNotFound) {
+			// Never written, or deleted from the repository
+			stale = append(stale, id)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if match != nil && !match(delivery) {
+			stale = append(stale, id)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sortDeliveries(deliveries)
+
+	return deliveries, stale, nil
+}
+
+// Remove the IDs a crash left in the pending index. They're checked again while no delivery is being saved
+func (s *RepositoryStore) dropStale(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var drop []string
+	for _, id := range ids {
+		delivery, err := s.Get(ctx, id)
+		if err != nil && !errors.Is(err, ErrDeliveryNotFound) {
+			return err
+		}
+		if err != nil || delivery.Status != Pending {
+			drop = append(drop, id)
+		}
+	}
+
+	return s.removeFromIndex(ctx, pendingIndexID, drop...)
+}
+
+// Add an ID to an index, keeping only the latest limit IDs when limit isn't zero
+func (s *RepositoryStore) addToIndex(ctx context.Context, indexID string, id string, limit int) error {
+	index, err := s.index(ctx, indexID)
+	if err != nil {
+		return err
+	}
+	if contains(index.DeliveryIDs, id) {
+		return nil
+	}
+
+	index.DeliveryIDs = append(index.DeliveryIDs, id)
+	if limit != 0 && len(index.DeliveryIDs) > limit {
+		index.DeliveryIDs = index.DeliveryIDs[len(index.DeliveryIDs)-limit:]
+	}
+
+	return s.put(ctx, index.ID, index)
+}
+
+// Remove IDs from an index
+func (s *RepositoryStore) removeFromIndex(ctx context.Context, indexID string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	index, err := s.index(ctx, indexID)
+	if err != nil {
+		return err
+	}
+
+	kept := index.DeliveryIDs[:0]
+	for _, existing := range index.DeliveryIDs {
+		if !contains(ids, existing) {
+			kept = append(kept, existing)
+		}
+	}
+	if len(kept) == len(index.DeliveryIDs) {
+		return nil
+	}
+	index.DeliveryIDs = kept
+
+	return s.put(ctx, index.ID, index)
+}
+
+// Get an index document, empty if it doesn't exist yet
+func (s *RepositoryStore) index(ctx context.Context, id string) (*indexDocument, error) {
+	index := &indexDocument{}
+	if err := s.get(ctx, id, index); err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+	index.ID = id
+	index.PartitionKey = s.partitionKey
+
+	return index, nil
+}
+
+// Read a document into the value
+func (s *RepositoryStore) get(ctx context.Context, id string, value interface{}) error {
+	document, err := s.repository.GetDocument(ctx, s.partitionKey, id)
+	if err != nil {
+		return err
+	}
+
+	// Repositories return the document as a generic value, e.g. a map
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+// Create or replace a document. Documents are passed as maps, the form repositories expect
+func (s *RepositoryStore) put(ctx context.Context, id string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return err
+	}
+
+	return s.repository.UpdateDocument(ctx, s.partitionKey, id, document)
+}
+
+func contains(ids []string, id string) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,358 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists the deliveries. Save creates or replaces a delivery
+type Store interface {
+	Save(ctx context.Context, delivery *Delivery) error
+	// Get returns ErrDeliveryNotFound for unknown IDs
+	Get(ctx context.Context, id string) (*Delivery, error)
+	// Pending returns the deliveries waiting for an attempt
+	Pending(ctx context.Context) ([]*Delivery, error)
+	// History returns the deliveries of a subscriber, oldest first. Stores may keep only the latest ones
+	History(ctx context.Context, subscriberID string) ([]*Delivery, error)
+}
+
+// Sort deliveries by creation time
+func sortDeliveries(deliveries []*Delivery) {
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+}
+
+// MemoryStore keeps the deliveries in memory, they're lost on restart. Meant for tests
+type MemoryStore struct {
+	mu         sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+// Create an in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{deliveries: make(map[string]*Delivery)}
+}
+
+func (s *MemoryStore) Save(ctx context.Context, delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deliveries[delivery.ID] = delivery.clone()
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery, ok := s.deliveries[id]
+	if !ok {
+		return nil, ErrDeliveryNotFound
+	}
+
+	return delivery.clone(), nil
+}
+
+func (s *MemoryStore) Pending(ctx context.Context) ([]*Delivery, error) {
+	return s.filter(func(d *Delivery) bool { return d.Status == Pending }), nil
+}
+
+func (s *MemoryStore) History(ctx context.Context, subscriberID string) ([]*Delivery, error) {
+	return s.filter(func(d *Delivery) bool { return d.SubscriberID == subscriberID }), nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, completedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for id, delivery := range s.deliveries {
+		if delivery.Status != Pending && delivery.CompletedAt.Before(completedBefore) {
+			delete(s.deliveries, id)
+			pruned++
+		}
+	}
+
+	return pruned, nil
+}
+
+// Get copies of the matching deliveries, oldest first
+func (s *MemoryStore) filter(match func(d *Delivery) bool) []*Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := []*Delivery{}
+	for _, delivery := range s.deliveries {
+		if match(delivery) {
+			deliveries = append(deliveries, delivery.clone())
+		}
+	}
+	sortDeliveries(deliveries)
+
+	return deliveries
+}
+
+// Subdirectory of a FileStore with the pending deliveries, so polls don't read the completed ones
+const pendingDir = "pending"
+
+// FileStore keeps every delivery as a JSON file in a local directory, so pending deliveries survive restarts.
+// Pending deliveries are kept in a subdirectory, moved out of it once completed.
+// Files are replaced atomically, a crash never leaves a partial delivery
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Create a store in the directory, created if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, pendingDir), 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Get the files of a delivery once completed and while pending, rejecting IDs that would escape the directory
+func (s *FileStore) paths(id string) (completed string, pending string, err error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return "", "", errors.New("invalid delivery ID: " + id)
+	}
+
+	return filepath.Join(s.dir, id+".json"), filepath.Join(s.dir, pendingDir, id+".json"), nil
+}
+
+func (s *FileStore) Save(ctx context.Context, delivery *Delivery) error {
+	completed, pending, err := s.paths(delivery.ID)
+	if err != nil {
+		return err
+	}
+	path, stale := completed, pending
+	if delivery.Status == Pending {
+		path, stale = pending, completed
+	}
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(s.dir, ".delivery-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Moved to the other directory, a crash before the removal is handled by current
+	if err := os.Remove(stale); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.current(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return readDelivery(path)
+}
+
+// Pending only reads the files of the pending subdirectory
+func (s *FileStore) Pending(ctx context.Context) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := deliveryIDs(filepath.Join(s.dir, pendingDir))
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []*Delivery{}
+	for _, id := range ids {
+		path, err := s.current(id)
+		if errors.Is(err, ErrDeliveryNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		delivery, err := readDelivery(path)
+		if err != nil {
+			return nil, err
+		}
+		if delivery.Status == Pending {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sortDeliveries(deliveries)
+
+	return deliveries, nil
+}
+
+// History reads every delivery, pending and completed
+func (s *FileStore) History(ctx context.Context, subscriberID string) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := []*Delivery{}
+	for _, dir := range []string{s.dir, filepath.Join(s.dir, pendingDir)} {
+		ids, err := deliveryIDs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			delivery, err := readDelivery(filepath.Join(dir, id+".json"))
+			if err != nil {
+				return nil, err
+			}
+			if delivery.SubscriberID == subscriberID && !containsDelivery(deliveries, id) {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+	}
+	sortDeliveries(deliveries)
+
+	return deliveries, nil
+}
+
+// Prune deletes the completed deliveries' files
+func (s *FileStore) Prune(ctx context.Context, completedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := deliveryIDs(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return pruned, err
+		}
+		path := filepath.Join(s.dir, id+".json")
+		delivery, err := readDelivery(path)
+		if err != nil {
+			return pruned, err
+		}
+		if delivery.Status == Pending || !delivery.CompletedAt.Before(completedBefore) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return pruned, err
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// Get the file of a delivery. A crash while a delivery moves between directories leaves it in both,
+// the file saved last is kept and the other one removed
+func (s *FileStore) current(id string) (string, error) {
+	completed, pending, err := s.paths(id)
+	if err != nil {
+		return "", err
+	}
+
+	completedInfo, completedErr := os.Stat(completed)
+	pendingInfo, pendingErr := os.Stat(pending)
+	switch {
+	case completedErr == nil && pendingErr == nil:
+		path, stale := completed, pending
+		if pendingInfo.ModTime().After(completedInfo.ModTime()) {
+			path, stale = pending, completed
+		}
+		if err := os.Remove(stale); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		return path, nil
+	case completedErr == nil:
+		return completed, nil
+	case pendingErr == nil:
+		return pending, nil
+	case !errors.Is(completedErr, os.ErrNotExist):
+		return "", completedErr
+	case !errors.Is(pendingErr, os.ErrNotExist):
+		return "", pendingErr
+	default:
+		return "", ErrDeliveryNotFound
+	}
+}
+
+// List the IDs of the delivery files in a directory
+func deliveryIDs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+
+	return ids, nil
+}
+
+func containsDelivery(deliveries []*Delivery, id string) bool {
+	for _, delivery := range deliveries {
+		if delivery.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Read a delivery file
+func readDelivery(path string) (*Delivery, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var delivery Delivery
+	if err := json.Unmarshal(data, &delivery); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &delivery, nil
+}
@@ -0,0 +1,506 @@
+// Package webhook delivers messages to subscribers' webhooks without losing them. Every delivery is saved
+// in a Store before it's sent, failed deliveries are retried with exponential backoff until the retry horizon,
+// and deliveries that keep failing are dead-lettered. The attempts of every delivery are kept, so the status
+// and history of a subscriber's deliveries can be queried:
+//
+//	sender, err := httpadapter.HttpSenderInit(ctx, httpadapter.WithWebhookSigning(secret))
+//	store, err := webhook.NewFileStore("/var/lib/orders/webhooks")
+//	dispatcher, err := webhook.New(sender, store, webhook.Options{})
+//	go dispatcher.Run(ctx)
+//	delivery, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: "https://partner.example.com/hooks"}, msg)
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perocha/goadapters/bridge"
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+const (
+	defaultInitialBackoff = 5 * time.Second
+	defaultMaxBackoff     = time.Hour
+	defaultRetryHorizon   = 24 * time.Hour
+	defaultPollInterval   = time.Second
+	defaultConcurrency    = 4
+
+	// Attempts kept in a delivery, older ones are dropped
+	maxAttemptHistory = 100
+	// Longest subscriber ID, it's part of document IDs and file names
+	maxSubscriberIDLength = 128
+	// Longest wait between two prunes of the completed deliveries
+	maxPruneInterval = time.Hour
+)
+
+// ErrDeliveryNotFound is returned for unknown delivery IDs
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// Status of a delivery
+type Status string
+
+const (
+	// Pending deliveries are waiting for their next attempt
+	Pending Status = "pending"
+	// Delivered deliveries were accepted by the subscriber
+	Delivered Status = "delivered"
+	// DeadLettered deliveries failed permanently or until the retry horizon
+	DeadLettered Status = "dead-lettered"
+)
+
+// Subscriber receives webhooks at its URL
+type Subscriber struct {
+	// ID of the subscriber, stores use it in document IDs: up to 128 characters, without /, \, ?, # or control characters
+	ID  string
+	URL string
+}
+
+// Check that a subscriber ID can be used in document IDs
+func validateSubscriberID(id string) error {
+	if id == "" {
+		return errors.New("subscriber ID is empty")
+	}
+	if len(id) > maxSubscriberIDLength {
+		return errors.New("subscriber ID is longer than " + strconv.Itoa(maxSubscriberIDLength) + " characters")
+	}
+	for _, c := range id {
+		if c < ' ' || c == 0x7f || strings.ContainsRune(`/\?#`, c) {
+			return fmt.Errorf("invalid character %q in subscriber ID", c)
+		}
+	}
+
+	return nil
+}
+
+// Attempt to deliver a webhook
+type Attempt struct {
+	At         time.Time     `json:"at"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"statusCode,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// Delivery of a message to a subscriber. Its ID is sent as the webhook ID, so the subscriber can detect
+// duplicates across retries
+type Delivery struct {
+	ID           string `json:"id"`
+	SubscriberID string `json:"subscriberID"`
+	URL          string `json:"url"`
+
+	// The message, kept field by field since messages can't be deserialized generically
+	OperationID string            `json:"operationID,omitempty"`
+	Command     string            `json:"command"`
+	MsgStatus   string            `json:"msgStatus,omitempty"`
+	Data        []byte            `json:"data,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+
+	Status        Status    `json:"status"`
+	CreatedAt     time.Time `json:"createdAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	// RetryUntil is the retry horizon, the delivery is dead-lettered when it fails after it
+	RetryUntil  time.Time `json:"retryUntil"`
+	CompletedAt time.Time `json:"completedAt,omitempty"`
+	// AttemptCount counts every attempt, Attempts keeps the latest ones
+	AttemptCount int       `json:"attemptCount"`
+	Attempts     []Attempt `json:"attempts,omitempty"`
+	LastError    string    `json:"lastError,omitempty"`
+}
+
+// Build the message of the delivery
+func (d *Delivery) Message() messaging.Message {
+	msg := messaging.NewMessage(d.OperationID, nil, d.MsgStatus, d.Command, d.Data)
+	if carrier, ok := msg.(messaging.HeaderCarrier); ok {
+		for key, value := range d.Headers {
+			carrier.SetHeader(key, value)
+		}
+	}
+
+	return msg
+}
+
+// Copy the delivery, so that stores don't share it with the dispatcher
+func (d *Delivery) clone() *Delivery {
+	clone := *d
+	clone.Data = append([]byte(nil), d.Data...)
+	clone.Attempts = append([]Attempt(nil), d.Attempts...)
+	if d.Headers != nil {
+		clone.Headers = make(map[string]string, len(d.Headers))
+		for key, value := range d.Headers {
+			clone.Headers[key] = value
+		}
+	}
+
+	return &clone
+}
+
+// Options to configure a Dispatcher
+type Options struct {
+	// InitialBackoff is the wait before the first retry, doubled on every attempt up to MaxBackoff. Defaults to 5 seconds
+	InitialBackoff time.Duration
+	// MaxBackoff defaults to an hour
+	MaxBackoff time.Duration
+	// RetryHorizon is how long a delivery is retried, from when it's enqueued. Defaults to 24 hours
+	RetryHorizon time.Duration
+	// PollInterval is how often the store is checked for deliveries due, defaults to a second
+	PollInterval time.Duration
+	// Concurrency is the number of deliveries sent at the same time, defaults to 4
+	Concurrency int
+	// DeadLetter receives the messages of dead-lettered deliveries, with the bridge dead-letter headers
+	DeadLetter bridge.Sink
+	// Retention is how long completed deliveries are kept, when the store implements Pruner.
+	// Zero keeps them forever
+	Retention time.Duration
+}
+
+// Pruner is implemented by the stores that can delete completed deliveries
+type Pruner interface {
+	// Prune deletes the deliveries completed before the time and returns how many were deleted
+	Prune(ctx context.Context, completedBefore time.Time) (int, error)
+}
+
+// Dispatcher delivers webhooks through a sender, usually an httpadapter.HttpSender with webhook signing
+type Dispatcher struct {
+	sender  comms.CommsSender
+	store   Store
+	options Options
+
+	// Deliveries being sent, so they aren't picked twice
+	mu       sync.Mutex
+	inFlight map[string]bool
+	wake     chan struct{}
+}
+
+// Create a dispatcher, call Run to start delivering
+func New(sender comms.CommsSender, store Store, options Options) (*Dispatcher, error) {
+	if sender == nil {
+		return nil, errors.New("sender is nil")
+	}
+	if store == nil {
+		return nil, errors.New("store is nil")
+	}
+	if options.InitialBackoff < 0 || options.MaxBackoff < 0 || options.RetryHorizon < 0 || options.PollInterval < 0 || options.Concurrency < 0 || options.Retention < 0 {
+		return nil, errors.New("options can't be negative")
+	}
+	if options.InitialBackoff == 0 {
+		options.InitialBackoff = defaultInitialBackoff
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = defaultMaxBackoff
+	}
+	if options.RetryHorizon == 0 {
+		options.RetryHorizon = defaultRetryHorizon
+	}
+	if options.PollInterval == 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	if options.Concurrency == 0 {
+		options.Concurrency = defaultConcurrency
+	}
+
+	return &Dispatcher{
+		sender:   sender,
+		store:    store,
+		options:  options,
+		inFlight: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}, nil
+}
+
+// Save a delivery of the message to the subscriber, it's sent by Run as soon as possible
+func (d *Dispatcher) Enqueue(ctx context.Context, subscriber Subscriber, msg messaging.Message) (*Delivery, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	if err := validateSubscriberID(subscriber.ID); err != nil {
+		return nil, err
+	}
+	if _, err := httpadapter.ParseEndpoint(subscriber.URL); err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, errors.New("message is nil")
+	}
+
+	now := time.Now()
+	delivery := &Delivery{
+		ID:            "msg_" + uuid.New().String(),
+		SubscriberID:  subscriber.ID,
+		URL:           subscriber.URL,
+		OperationID:   msg.GetOperationID(),
+		Command:       msg.GetCommand(),
+		MsgStatus:     msg.GetStatus(),
+		Data:          msg.GetData(),
+		Status:        Pending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+		RetryUntil:    now.Add(d.options.RetryHorizon),
+	}
+	if carrier, ok := msg.(messaging.HeaderCarrier); ok && len(carrier.GetHeaders()) > 0 {
+		delivery.Headers = make(map[string]string, len(carrier.GetHeaders()))
+		for key, value := range carrier.GetHeaders() {
+			delivery.Headers[key] = value
+		}
+	}
+
+	if err := d.store.Save(ctx, delivery); err != nil {
+		xTelemetry.Error(ctx, "Webhook::Enqueue::Failed to save delivery", telemetry.String("SubscriberID", subscriber.ID), telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+	xTelemetry.Debug(ctx, "Webhook::Enqueue", telemetry.String("DeliveryID", delivery.ID), telemetry.String("SubscriberID", subscriber.ID))
+
+	d.signal()
+	return delivery.clone(), nil
+}
+
+// Get a delivery with its status and attempts
+func (d *Dispatcher) Delivery(ctx context.Context, id string) (*Delivery, error) {
+	return d.store.Get(ctx, id)
+}
+
+// Get the deliveries of a subscriber, oldest first
+func (d *Dispatcher) History(ctx context.Context, subscriberID string) ([]*Delivery, error) {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return nil, err
+	}
+
+	return d.store.History(ctx, subscriberID)
+}
+
+// Retry a dead-lettered delivery, with a new retry horizon
+func (d *Dispatcher) Redeliver(ctx context.Context, id string) error {
+	delivery, err := d.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if delivery.Status != DeadLettered {
+		return errors.New("only dead-lettered deliveries can be redelivered, delivery is " + string(delivery.Status))
+	}
+
+	now := time.Now()
+	delivery.Status = Pending
+	delivery.NextAttemptAt = now
+	delivery.RetryUntil = now.Add(d.options.RetryHorizon)
+	delivery.CompletedAt = time.Time{}
+	if err := d.store.Save(ctx, delivery); err != nil {
+		return err
+	}
+
+	d.signal()
+	return nil
+}
+
+// Wake Run up to check for deliveries due
+func (d *Dispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Send the deliveries due until the context is cancelled, waiting for the attempts in progress before returning.
+// Pending deliveries left in the store are sent when Run is called again, e.g. after a restart.
+// Completed deliveries older than the retention are pruned as well
+func (d *Dispatcher) Run(ctx context.Context) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+	xTelemetry.Info(ctx, "Webhook::Run::Starting")
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, d.options.Concurrency)
+	ticker := time.NewTicker(d.options.PollInterval)
+	defer ticker.Stop()
+	var lastPrune time.Time
+
+	for {
+		if d.options.Retention != 0 && time.Since(lastPrune) >= min(d.options.Retention, maxPruneInterval) {
+			lastPrune = time.Now()
+			d.prune(ctx)
+		}
+
+		pending, err := d.store.Pending(ctx)
+		if err != nil && ctx.Err() == nil {
+			xTelemetry.Error(ctx, "Webhook::Run::Failed to get pending deliveries", telemetry.String("Error", err.Error()))
+		}
+
+		now := time.Now()
+		for _, delivery := range pending {
+			if delivery.NextAttemptAt.After(now) || !d.claim(delivery.ID) {
+				continue
+			}
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				d.release(delivery.ID)
+				wg.Wait()
+				xTelemetry.Info(ctx, "Webhook::Run::Stopped")
+				return ctx.Err()
+			}
+
+			wg.Add(1)
+			go func(delivery *Delivery) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				defer d.release(delivery.ID)
+				d.attempt(ctx, delivery)
+			}(delivery)
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			xTelemetry.Info(ctx, "Webhook::Run::Stopped")
+			return ctx.Err()
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Delete the deliveries completed before the retention, if the store supports it
+func (d *Dispatcher) prune(ctx context.Context) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	pruner, ok := d.store.(Pruner)
+	if !ok {
+		return
+	}
+	pruned, err := pruner.Prune(ctx, time.Now().Add(-d.options.Retention))
+	if err != nil {
+		if ctx.Err() == nil {
+			xTelemetry.Error(ctx, "Webhook::Prune::Failed to prune deliveries", telemetry.String("Error", err.Error()))
+		}
+		return
+	}
+	if pruned != 0 {
+		xTelemetry.Info(ctx, "Webhook::Prune::Deliveries pruned", telemetry.String("Pruned", strconv.Itoa(pruned)))
+	}
+}
+
+// Mark a delivery as in progress, false if it already is
+func (d *Dispatcher) claim(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.inFlight[id] {
+		return false
+	}
+	d.inFlight[id] = true
+	return true
+}
+
+func (d *Dispatcher) release(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.inFlight, id)
+}
+
+// Send a delivery once and save the outcome
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	operationID := delivery.OperationID
+	if operationID == "" {
+		operationID = delivery.ID
+	}
+	ctx = telemetry.SetOperationID(ctx, operationID)
+
+	endpoint, err := httpadapter.ParseEndpoint(delivery.URL)
+	startTime := time.Now()
+	if err == nil {
+		err = d.sender.SendRequest(httpadapter.WithWebhookID(ctx, delivery.ID), endpoint, delivery.Message())
+	}
+	if err != nil && ctx.Err() != nil {
+		// Stopping, the attempt is retried on the next run
+		return
+	}
+
+	now := time.Now()
+	attempt := Attempt{At: startTime, Duration: now.Sub(startTime)}
+	var statusErr *httpadapter.StatusError
+	if errors.As(err, &statusErr) {
+		attempt.StatusCode = int(statusErr.StatusCode)
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	delivery.AttemptCount++
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if len(delivery.Attempts) > maxAttemptHistory {
+		delivery.Attempts = delivery.Attempts[len(delivery.Attempts)-maxAttemptHistory:]
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = Delivered
+		delivery.CompletedAt = now
+		delivery.LastError = ""
+		xTelemetry.Dependency(ctx, "Webhook", delivery.SubscriberID, true, startTime, now, "Webhook::Attempt::Delivered", telemetry.String("DeliveryID", delivery.ID), telemetry.String("Attempt", strconv.Itoa(delivery.AttemptCount)))
+
+	default:
+		delivery.LastError = err.Error()
+		next := now.Add(d.backoff(delivery.AttemptCount, err))
+		xTelemetry.Dependency(ctx, "Webhook", delivery.SubscriberID, false, startTime, now, "Webhook::Attempt::Failed", telemetry.String("DeliveryID", delivery.ID), telemetry.String("Attempt", strconv.Itoa(delivery.AttemptCount)), telemetry.String("Error", err.Error()))
+
+		if !comms.IsRetryable(err) || next.After(delivery.RetryUntil) {
+			delivery.Status = DeadLettered
+			delivery.CompletedAt = now
+		} else {
+			delivery.NextAttemptAt = next
+		}
+	}
+
+	if err := d.store.Save(ctx, delivery); err != nil {
+		// The delivery stays pending in the store and is attempted again
+		xTelemetry.Error(ctx, "Webhook::Attempt::Failed to save delivery", telemetry.String("DeliveryID", delivery.ID), telemetry.String("Error", err.Error()))
+		return
+	}
+
+	// Only once saved, otherwise the next attempt would dead-letter the delivery again
+	if delivery.Status == DeadLettered {
+		d.deadLetter(ctx, delivery)
+	}
+}
+
+// Get the wait before the next attempt, honoring the subscriber's Retry-After and the sender's rate limit
+func (d *Dispatcher) backoff(attempts int, err error) time.Duration {
+	backoff := d.options.InitialBackoff
+	for i := 1; i < attempts && backoff < d.options.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, d.options.MaxBackoff)
+
+	var retryAfter interface{ RetryAfter() time.Duration }
+	if errors.As(err, &retryAfter) {
+		backoff = max(backoff, retryAfter.RetryAfter())
+	}
+
+	return backoff
+}
+
+// Send the message of a dead-lettered delivery to the dead-letter sink
+func (d *Dispatcher) deadLetter(ctx context.Context, delivery *Delivery) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+	xTelemetry.Warn(ctx, "Webhook::DeadLetter::Delivery dead-lettered", telemetry.String("DeliveryID", delivery.ID), telemetry.String("SubscriberID", delivery.SubscriberID), telemetry.String("Error", delivery.LastError))
+
+	if d.options.DeadLetter == nil {
+		return
+	}
+
+	msg := delivery.Message()
+	if carrier, ok := msg.(messaging.HeaderCarrier); ok {
+		carrier.SetHeader(bridge.DeadLetterReasonHeader, delivery.LastError)
+		carrier.SetHeader(bridge.DeadLetterAttemptsHeader, strconv.Itoa(delivery.AttemptCount))
+	}
+	if err := d.options.DeadLetter.Send(ctx, msg); err != nil {
+		xTelemetry.Error(ctx, "Webhook::DeadLetter::Failed to dead-letter message", telemetry.String("DeliveryID", delivery.ID), telemetry.String("Error", err.Error()))
+	}
+}
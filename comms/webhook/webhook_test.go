@@ -0,0 +1,501 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/bridge"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/comms/webhook"
+	"github.com/perocha/goadapters/database"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initializeTelemetry() context.Context {
+	// Initialize telemetry package
+	serviceName := "webhook"
+	telemetryConfig := telemetry.NewXTelemetryConfig("", serviceName, "info", 1)
+	xTelemetry, err := telemetry.NewXTelemetry(telemetryConfig)
+	if err != nil {
+		log.Fatalf("Main::Fatal error::Failed to initialize XTelemetry %s\n", err.Error())
+	}
+	// Add telemetry object to the context, so that it can be reused across the application
+	ctx := context.WithValue(context.Background(), telemetry.TelemetryContextKey, xTelemetry)
+	return ctx
+}
+
+// subscriber is a webhook endpoint answering with the queued statuses, then 204
+type subscriber struct {
+	server     *httptest.Server
+	mu         sync.Mutex
+	statuses   []int
+	headers    http.Header
+	webhookIDs []string
+}
+
+func newSubscriber(t *testing.T, statuses ...int) *subscriber {
+	s := &subscriber{statuses: statuses, headers: http.Header{}}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.webhookIDs = append(s.webhookIDs, r.Header.Get(httpadapter.WebhookIDHeader))
+
+		status := http.StatusNoContent
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		for key, values := range s.headers {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *subscriber) ids() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.webhookIDs...)
+}
+
+// Start the dispatcher until the test ends
+func runDispatcher(t *testing.T, ctx context.Context, dispatcher *webhook.Dispatcher) {
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = dispatcher.Run(runCtx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// Wait for the delivery to reach the status
+func waitForStatus(t *testing.T, ctx context.Context, dispatcher *webhook.Dispatcher, id string, status webhook.Status) *webhook.Delivery {
+	var delivery *webhook.Delivery
+	require.Eventually(t, func() bool {
+		var err error
+		delivery, err = dispatcher.Delivery(ctx, id)
+		require.NoError(t, err)
+		return delivery.Status == status
+	}, 5*time.Second, 5*time.Millisecond)
+	return delivery
+}
+
+func newSender(t *testing.T, ctx context.Context, options ...httpadapter.SenderOption) *httpadapter.HttpSender {
+	sender, err := httpadapter.HttpSenderInit(ctx, options...)
+	require.NoError(t, err)
+	return sender
+}
+
+func TestDispatcher_RetriesUntilDelivered(t *testing.T) {
+	ctx := initializeTelemetry()
+	sub := newSubscriber(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	secret, err := httpadapter.NewWebhookSecret()
+	require.NoError(t, err)
+
+	dispatcher, err := webhook.New(newSender(t, ctx, httpadapter.WithWebhookSigning(secret)), webhook.NewMemoryStore(), webhook.Options{InitialBackoff: 10 * time.Millisecond, PollInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	runDispatcher(t, ctx, dispatcher)
+
+	msg := messaging.NewMessage("op-1", nil, "pending", "create", []byte("order"))
+	msg.(messaging.HeaderCarrier).SetHeader("Tenant", "contoso")
+	delivery, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL + "/hooks"}, msg)
+	require.NoError(t, err)
+	assert.Equal(t, webhook.Pending, delivery.Status)
+
+	delivered := waitForStatus(t, ctx, dispatcher, delivery.ID, webhook.Delivered)
+	assert.Equal(t, 3, delivered.AttemptCount)
+	require.Len(t, delivered.Attempts, 3)
+	assert.Equal(t, http.StatusServiceUnavailable, delivered.Attempts[0].StatusCode)
+	assert.Equal(t, http.StatusBadGateway, delivered.Attempts[1].StatusCode)
+	assert.Empty(t, delivered.Attempts[2].Error)
+	assert.Equal(t, "contoso", delivered.Headers["Tenant"])
+
+	// Every signed attempt carries the delivery ID as webhook ID
+	assert.Equal(t, []string{delivery.ID, delivery.ID, delivery.ID}, sub.ids())
+
+	history, err := dispatcher.History(ctx, "partner-1")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, webhook.Delivered, history[0].Status)
+}
+
+func TestDispatcher_DeadLetterAndRedeliver(t *testing.T) {
+	ctx := initializeTelemetry()
+	sub := newSubscriber(t, http.StatusBadRequest)
+
+	var mu sync.Mutex
+	deadLettered := []messaging.Message{}
+	sink := bridge.SinkFunc(func(ctx context.Context, msg messaging.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		deadLettered = append(deadLettered, msg)
+		return nil
+	})
+
+	dispatcher, err := webhook.New(newSender(t, ctx), webhook.NewMemoryStore(), webhook.Options{InitialBackoff: 10 * time.Millisecond, PollInterval: 5 * time.Millisecond, DeadLetter: sink})
+	require.NoError(t, err)
+	runDispatcher(t, ctx, dispatcher)
+
+	// Client errors aren't retried
+	delivery, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL}, messaging.NewMessage("", nil, "pending", "create", []byte("order")))
+	require.NoError(t, err)
+	failed := waitForStatus(t, ctx, dispatcher, delivery.ID, webhook.DeadLettered)
+	assert.Equal(t, 1, failed.AttemptCount)
+	assert.Contains(t, failed.LastError, "400")
+
+	mu.Lock()
+	require.Len(t, deadLettered, 1)
+	assert.Equal(t, []byte("order"), deadLettered[0].GetData())
+	assert.Equal(t, "1", deadLettered[0].(messaging.HeaderCarrier).GetHeader(bridge.DeadLetterAttemptsHeader))
+	mu.Unlock()
+
+	// Once the subscriber is fixed the delivery can be retried
+	require.NoError(t, dispatcher.Redeliver(ctx, delivery.ID))
+	redelivered := waitForStatus(t, ctx, dispatcher, delivery.ID, webhook.Delivered)
+	assert.Equal(t, 2, redelivered.AttemptCount)
+	assert.Error(t, dispatcher.Redeliver(ctx, delivery.ID))
+}
+
+// flakyStore fails the first save of a dead-lettered delivery
+type flakyStore struct {
+	webhook.Store
+	failed atomic.Bool
+}
+
+func (s *flakyStore) Save(ctx context.Context, delivery *webhook.Delivery) error {
+	if delivery.Status == webhook.DeadLettered && s.failed.CompareAndSwap(false, true) {
+		return errors.New("request timed out")
+	}
+	return s.Store.Save(ctx, delivery)
+}
+
+func TestDispatcher_DeadLetterAfterSave(t *testing.T) {
+	ctx := initializeTelemetry()
+	sub := newSubscriber(t, http.StatusBadRequest, http.StatusBadRequest)
+
+	var deadLettered atomic.Int32
+	sink := bridge.SinkFunc(func(ctx context.Context, msg messaging.Message) error {
+		deadLettered.Add(1)
+		return nil
+	})
+
+	dispatcher, err := webhook.New(newSender(t, ctx), &flakyStore{Store: webhook.NewMemoryStore()}, webhook.Options{InitialBackoff: 10 * time.Millisecond, PollInterval: 5 * time.Millisecond, DeadLetter: sink})
+	require.NoError(t, err)
+	runDispatcher(t, ctx, dispatcher)
+
+	// The delivery whose dead-lettering couldn't be saved stays pending, it's sent to the sink once saved
+	delivery, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL}, messaging.NewMessage("", nil, "pending", "create", []byte("order")))
+	require.NoError(t, err)
+	waitForStatus(t, ctx, dispatcher, delivery.ID, webhook.DeadLettered)
+	assert.Len(t, sub.ids(), 2)
+	assert.Equal(t, int32(1), deadLettered.Load())
+}
+
+func TestDispatcher_RetryHorizon(t *testing.T) {
+	ctx := initializeTelemetry()
+	sub := newSubscriber(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	dispatcher, err := webhook.New(newSender(t, ctx), webhook.NewMemoryStore(), webhook.Options{InitialBackoff: 20 * time.Millisecond, RetryHorizon: 50 * time.Millisecond, PollInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	runDispatcher(t, ctx, dispatcher)
+
+	delivery, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL}, messaging.NewMessage("", nil, "pending", "create", nil))
+	require.NoError(t, err)
+	failed := waitForStatus(t, ctx, dispatcher, delivery.ID, webhook.DeadLettered)
+	assert.GreaterOrEqual(t, failed.AttemptCount, 2)
+	assert.Less(t, failed.AttemptCount, 5)
+}
+
+func TestDispatcher_RetryAfter(t *testing.T) {
+	ctx := initializeTelemetry()
+	sub := newSubscriber(t, http.StatusTooManyRequests)
+	sub.headers.Set("Retry-After", "60")
+
+	store := webhook.NewMemoryStore()
+	dispatcher, err := webhook.New(newSender(t, ctx), store, webhook.Options{InitialBackoff: 10 * time.Millisecond, PollInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	runDispatcher(t, ctx, dispatcher)
+
+	delivery, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL}, messaging.NewMessage("", nil, "pending", "create", nil))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		d, err := store.Get(ctx, delivery.ID)
+		require.NoError(t, err)
+		return d.AttemptCount == 1
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// The next attempt waits for the subscriber's Retry-After instead of the backoff
+	pending, err := store.Get(ctx, delivery.ID)
+	require.NoError(t, err)
+	assert.Equal(t, webhook.Pending, pending.Status)
+	assert.Greater(t, time.Until(pending.NextAttemptAt), 50*time.Second)
+}
+
+func TestDispatcher_FileStoreSurvivesRestart(t *testing.T) {
+	ctx := initializeTelemetry()
+	sub := newSubscriber(t)
+	dir := t.TempDir()
+
+	// Enqueued while no dispatcher is running
+	store, err := webhook.NewFileStore(dir)
+	require.NoError(t, err)
+	dispatcher, err := webhook.New(newSender(t, ctx), store, webhook.Options{})
+	require.NoError(t, err)
+	delivery, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL}, messaging.NewMessage("", nil, "pending", "create", []byte("order")))
+	require.NoError(t, err)
+
+	// A new dispatcher on the same directory delivers it
+	store, err = webhook.NewFileStore(dir)
+	require.NoError(t, err)
+	restarted, err := webhook.New(newSender(t, ctx), store, webhook.Options{PollInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	runDispatcher(t, ctx, restarted)
+
+	delivered := waitForStatus(t, ctx, restarted, delivery.ID, webhook.Delivered)
+	assert.Equal(t, []byte("order"), delivered.Data)
+
+	pending, err := store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	_, err = store.Get(ctx, "../escape")
+	assert.Error(t, err)
+	_, err = store.Get(ctx, "msg_unknown")
+	assert.ErrorIs(t, err, webhook.ErrDeliveryNotFound)
+}
+
+// memoryRepository implements database.DBRepository, keeping documents as JSON like a document database
+type memoryRepository struct {
+	mu        sync.Mutex
+	documents map[string][]byte
+	// Documents whose updates fail
+	failing map[string]bool
+}
+
+func (r *memoryRepository) CreateDocument(ctx context.Context, partitionKey string, document interface{}) error {
+	return r.UpdateDocument(ctx, partitionKey, "", document)
+}
+
+func (r *memoryRepository) UpdateDocument(ctx context.Context, partitionKey string, id string, document interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		id = document.(map[string]interface{})["id"].(string)
+	}
+	if r.failing[id] {
+		return errors.New("request timed out")
+	}
+	r.documents[partitionKey+"/"+id] = data
+	return nil
+}
+
+func (r *memoryRepository) DeleteDocument(ctx context.Context, partitionKey string, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.documents, partitionKey+"/"+id)
+	return nil
+}
+
+func (r *memoryRepository) GetDocument(ctx context.Context, partitionKey string, id string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.documents[partitionKey+"/"+id]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	var document map[string]interface{}
+	err := json.Unmarshal(data, &document)
+	return document, err
+}
+
+func TestDispatcher_RepositoryStore(t *testing.T) {
+	ctx := initializeTelemetry()
+	sub := newSubscriber(t, http.StatusServiceUnavailable)
+	repository := &memoryRepository{documents: map[string][]byte{}}
+
+	store, err := webhook.NewRepositoryStore(repository, "webhooks")
+	require.NoError(t, err)
+	dispatcher, err := webhook.New(newSender(t, ctx), store, webhook.Options{InitialBackoff: 10 * time.Millisecond, PollInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	runDispatcher(t, ctx, dispatcher)
+
+	first, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL}, messaging.NewMessage("", nil, "pending", "create", []byte("1")))
+	require.NoError(t, err)
+	second, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL}, messaging.NewMessage("", nil, "pending", "create", []byte("2")))
+	require.NoError(t, err)
+	waitForStatus(t, ctx, dispatcher, first.ID, webhook.Delivered)
+	waitForStatus(t, ctx, dispatcher, second.ID, webhook.Delivered)
+
+	history, err := dispatcher.History(ctx, "partner-1")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, first.ID, history[0].ID)
+	assert.Equal(t, second.ID, history[1].ID)
+
+	pending, err := store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Documents carry the partition key
+	document, err := repository.GetDocument(ctx, "webhooks", first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "webhooks", document.(map[string]interface{})["partitionKey"])
+}
+
+func TestRepositoryStore_DropsStalePendingIDs(t *testing.T) {
+	ctx := initializeTelemetry()
+	repository := &memoryRepository{documents: map[string][]byte{}, failing: map[string]bool{"msg_lost": true}}
+	store, err := webhook.NewRepositoryStore(repository, "webhooks")
+	require.NoError(t, err)
+
+	// The delivery is indexed as pending before it fails to be written
+	err = store.Save(ctx, &webhook.Delivery{ID: "msg_lost", SubscriberID: "partner-1", Status: webhook.Pending})
+	assert.Error(t, err)
+
+	// A crash after a completed delivery was written, before the pending index was updated
+	require.NoError(t, store.Save(ctx, &webhook.Delivery{ID: "msg_done", SubscriberID: "partner-1", Status: webhook.Pending}))
+	require.NoError(t, repository.UpdateDocument(ctx, "webhooks", "msg_done", map[string]interface{}{"id": "msg_done", "subscriberID": "partner-1", "status": "delivered"}))
+	require.NoError(t, store.Save(ctx, &webhook.Delivery{ID: "msg_waiting", SubscriberID: "partner-1", Status: webhook.Pending}))
+
+	pending, err := store.Pending(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "msg_waiting", pending[0].ID)
+
+	// The stale IDs are dropped from the index
+	index, err := repository.GetDocument(ctx, "webhooks", "webhook-pending")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"msg_waiting"}, index.(map[string]interface{})["deliveryIDs"])
+}
+
+func TestRepositoryStore_TrimsHistory(t *testing.T) {
+	ctx := initializeTelemetry()
+	store, err := webhook.NewRepositoryStore(&memoryRepository{documents: map[string][]byte{}}, "webhooks")
+	require.NoError(t, err)
+
+	createdAt := time.Now()
+	for i := 0; i < 1005; i++ {
+		delivery := &webhook.Delivery{ID: "msg_" + strconv.Itoa(i), SubscriberID: "partner-1", Status: webhook.Delivered, CreatedAt: createdAt.Add(time.Duration(i))}
+		require.NoError(t, store.Save(ctx, delivery))
+	}
+
+	// The latest deliveries are kept in the history, the older ones can still be read
+	history, err := store.History(ctx, "partner-1")
+	require.NoError(t, err)
+	require.Len(t, history, 1000)
+	assert.Equal(t, "msg_5", history[0].ID)
+	assert.Equal(t, "msg_1004", history[999].ID)
+	_, err = store.Get(ctx, "msg_0")
+	assert.NoError(t, err)
+}
+
+func TestFileStore_PendingDirectoryAndRetention(t *testing.T) {
+	ctx := initializeTelemetry()
+	sub := newSubscriber(t)
+	dir := t.TempDir()
+
+	store, err := webhook.NewFileStore(dir)
+	require.NoError(t, err)
+	dispatcher, err := webhook.New(newSender(t, ctx), store, webhook.Options{PollInterval: 5 * time.Millisecond, Retention: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	// Pending deliveries are kept apart, and moved once delivered
+	delivery, err := dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: sub.server.URL}, messaging.NewMessage("", nil, "pending", "create", nil))
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "pending", delivery.ID+".json"))
+
+	runDispatcher(t, ctx, dispatcher)
+	waitForStatus(t, ctx, dispatcher, delivery.ID, webhook.Delivered)
+	assert.NoFileExists(t, filepath.Join(dir, "pending", delivery.ID+".json"))
+
+	// Deleted once older than the retention
+	require.Eventually(t, func() bool {
+		_, err := dispatcher.Delivery(ctx, delivery.ID)
+		return errors.Is(err, webhook.ErrDeliveryNotFound)
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestFileStore_CrashWhileMoving(t *testing.T) {
+	ctx := initializeTelemetry()
+	dir := t.TempDir()
+	store, err := webhook.NewFileStore(dir)
+	require.NoError(t, err)
+
+	delivery := &webhook.Delivery{ID: "msg_1", SubscriberID: "partner-1", Status: webhook.Pending}
+	require.NoError(t, store.Save(ctx, delivery))
+	pendingFile := filepath.Join(dir, "pending", "msg_1.json")
+	stale, err := os.ReadFile(pendingFile)
+	require.NoError(t, err)
+
+	// Completed, but the pending file is left behind
+	delivery.Status = webhook.Delivered
+	require.NoError(t, store.Save(ctx, delivery))
+	require.NoError(t, os.WriteFile(pendingFile, stale, 0o600))
+	require.NoError(t, os.Chtimes(pendingFile, time.Now().Add(-time.Minute), time.Now().Add(-time.Minute)))
+
+	// The file saved last wins, the other one is removed
+	pending, err := store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.NoFileExists(t, pendingFile)
+	saved, err := store.Get(ctx, "msg_1")
+	require.NoError(t, err)
+	assert.Equal(t, webhook.Delivered, saved.Status)
+
+	history, err := store.History(ctx, "partner-1")
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	ctx := initializeTelemetry()
+
+	_, err := webhook.New(nil, webhook.NewMemoryStore(), webhook.Options{})
+	assert.Error(t, err)
+	_, err = webhook.New(newSender(t, ctx), nil, webhook.Options{})
+	assert.Error(t, err)
+	_, err = webhook.New(newSender(t, ctx), webhook.NewMemoryStore(), webhook.Options{Concurrency: -1})
+	assert.Error(t, err)
+
+	dispatcher, err := webhook.New(newSender(t, ctx), webhook.NewMemoryStore(), webhook.Options{})
+	require.NoError(t, err)
+	_, err = dispatcher.Enqueue(ctx, webhook.Subscriber{ID: "partner-1", URL: "ftp://partner"}, messaging.NewMessage("", nil, "", "create", nil))
+	assert.Error(t, err)
+	_, err = dispatcher.Enqueue(ctx, webhook.Subscriber{URL: "https://partner"}, messaging.NewMessage("", nil, "", "create", nil))
+	assert.Error(t, err)
+
+	// Subscriber IDs become part of document IDs
+	for _, id := range []string{"partner/1", `partner\1`, "partner?1", "partner#1", "partner\n1", strings.Repeat("p", 129)} {
+		_, err = dispatcher.Enqueue(ctx, webhook.Subscriber{ID: id, URL: "https://partner"}, messaging.NewMessage("", nil, "", "create", nil))
+		assert.Error(t, err, id)
+		_, err = dispatcher.History(ctx, id)
+		assert.Error(t, err, id)
+	}
+	_, err = webhook.New(newSender(t, ctx), webhook.NewMemoryStore(), webhook.Options{Retention: -time.Hour})
+	assert.Error(t, err)
+}
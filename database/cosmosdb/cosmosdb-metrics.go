@@ -0,0 +1,23 @@
+package cosmosdb
+
+import (
+	"time"
+
+	"github.com/perocha/goadapters/metrics"
+)
+
+// Latency of the container operations, recorded into metrics.Default
+var operationLatency = metrics.Default.Histogram("cosmosdb_operation_duration_seconds", "Latency of Cosmos DB operations", nil, "operation", "result")
+
+// Record an operation, missing items are counted apart from failures
+func observeOperation(operation string, startTime time.Time, err error) {
+	result := "success"
+	switch {
+	case isItemNotFound(err):
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	}
+
+	operationLatency.With(operation, result).ObserveSince(startTime)
+}
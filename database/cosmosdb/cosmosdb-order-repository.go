@@ -3,11 +3,16 @@ package cosmosdb
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/mitchellh/mapstructure"
+	"github.com/perocha/goadapters/database"
 	"github.com/perocha/goutils/pkg/telemetry"
 )
 
@@ -86,6 +91,7 @@ func (r *CosmosdbRepository) CreateDocument(ctx context.Context, partitionKey st
 
 	// Create an item
 	_, err = r.container.CreateItem(ctx, pk, docJson, nil)
+	observeOperation("CreateDocument", startTime, err)
 	if err != nil {
 		xTelemetry.Error(ctx, "CosmosdbRepository::CreateDocument::Error creating item", telemetry.String("Error", err.Error()))
 		return err
@@ -121,6 +127,7 @@ func (r *CosmosdbRepository) UpdateDocument(ctx context.Context, partitionKey st
 
 	// Update an item
 	_, err = r.container.UpsertItem(ctx, pk, docJson, nil)
+	observeOperation("UpdateDocument", startTime, err)
 	if err != nil {
 		xTelemetry.Error(ctx, "CosmosdbRepository::UpdateDocument::Error updating item", telemetry.String("Error", err.Error()))
 		return err
@@ -142,6 +149,7 @@ func (r *CosmosdbRepository) DeleteDocument(ctx context.Context, partitionKey st
 
 	// Delete an item
 	_, err := r.container.DeleteItem(ctx, pk, id, nil)
+	observeOperation("DeleteDocument", startTime, err)
 	if err != nil {
 		xTelemetry.Error(ctx, "CosmosdbRepository::DeleteDocument::Error deleting item", telemetry.String("Error", err.Error()))
 		return err
@@ -163,8 +171,12 @@ func (r *CosmosdbRepository) GetDocument(ctx context.Context, partitionKey strin
 
 	// Retrieve an item
 	item, err := r.container.ReadItem(ctx, pk, id, nil)
+	observeOperation("GetDocument", startTime, err)
 	if err != nil {
 		xTelemetry.Error(ctx, "CosmosdbRepository::GetDocument::Error reading item", telemetry.String("Error", err.Error()))
+		if isItemNotFound(err) {
+			return nil, fmt.Errorf("%w: %w", database.ErrNotFound, err)
+		}
 		return nil, err
 	}
 
@@ -180,3 +192,37 @@ func (r *CosmosdbRepository) GetDocument(ctx context.Context, partitionKey strin
 
 	return readDoc, nil
 }
+
+// Check the connection to the container by reading its properties, implements comms.HealthChecker.
+// A missing database or container fails the check
+func (r *CosmosdbRepository) CheckHealth(ctx context.Context) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	startTime := time.Now()
+
+	_, err := r.container.Read(ctx, nil)
+	observeOperation("CheckHealth", startTime, err)
+	if err != nil {
+		xTelemetry.Dependency(ctx, "CosmosDB", r.client.Endpoint(), false, startTime, time.Now(), "CheckHealth failed", telemetry.String("Error", err.Error()))
+		return err
+	}
+
+	xTelemetry.Dependency(ctx, "CosmosDB", r.client.Endpoint(), true, startTime, time.Now(), "CheckHealth success")
+
+	return nil
+}
+
+// Check if an error is Cosmos DB answering that an item doesn't exist. A missing database or container
+// is answered with 404 too, but with a sub-status (1003) telling them apart
+func isItemNotFound(err error) bool {
+	var responseErr *azcore.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+	if responseErr.RawResponse == nil {
+		return true
+	}
+
+	subStatus := responseErr.RawResponse.Header.Get("x-ms-substatus")
+	return subStatus == "" || subStatus == "0"
+}
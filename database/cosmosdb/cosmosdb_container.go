@@ -13,6 +13,7 @@ type ContainerClientInterface interface {
 	UpsertItem(ctx context.Context, partitionKey azcosmos.PartitionKey, item interface{}, options *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
 	DeleteItem(ctx context.Context, partitionKey azcosmos.PartitionKey, id string, options *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
 	ReadItem(ctx context.Context, partitionKey azcosmos.PartitionKey, id string, options *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
+	Read(ctx context.Context, options *azcosmos.ReadContainerOptions) (azcosmos.ContainerResponse, error)
 }
 
 type CosmosContainer struct {
@@ -42,3 +43,7 @@ func (c *CosmosContainer) DeleteItem(ctx context.Context, partitionKey azcosmos.
 func (c *CosmosContainer) ReadItem(ctx context.Context, partitionKey azcosmos.PartitionKey, id string, options *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
 	return c.container.ReadItem(ctx, partitionKey, id, options)
 }
+
+func (c *CosmosContainer) Read(ctx context.Context, options *azcosmos.ReadContainerOptions) (azcosmos.ContainerResponse, error) {
+	return c.container.Read(ctx, options)
+}
@@ -2,8 +2,12 @@ package database
 
 import (
 	"context"
+	"errors"
 )
 
+// ErrNotFound is matched by the error returned when a document doesn't exist, errors.Is(err, ErrNotFound)
+var ErrNotFound = errors.New("document not found")
+
 // Database represents the interface for interacting with the database.
 type Database interface {
 	Repository() DBRepository
@@ -0,0 +1,225 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+
+	"github.com/perocha/goadapters/messaging"
+)
+
+// Envelope format version
+const envelopeVersion = 1
+
+var (
+	ErrInvalidEnvelope      = errors.New("envelope: invalid envelope")
+	ErrDecryptionFailed     = errors.New("envelope: decryption failed")
+	ErrUnknownKey           = errors.New("envelope: unknown key")
+	ErrUnsupportedAlgorithm = errors.New("envelope: unsupported algorithm")
+	ErrInvalidSignature     = errors.New("envelope: invalid signature")
+	ErrMissingSignature     = errors.New("envelope: missing signature")
+	ErrMissingKeyProvider   = errors.New("envelope: message is encrypted but no key provider is configured")
+	ErrMissingVerifier      = errors.New("envelope: message is signed but no verifier is configured")
+	ErrNotEncrypted         = errors.New("envelope: message is not encrypted")
+)
+
+// Envelope is the wire representation of a sealed message payload, carried in the message data
+type Envelope struct {
+	Version    int    `json:"v"`
+	KeyID      string `json:"kid,omitempty"`
+	WrappedKey []byte `json:"wk,omitempty"`
+	Nonce      []byte `json:"nonce,omitempty"`
+	Ciphertext []byte `json:"ct,omitempty"`
+	Payload    []byte `json:"payload,omitempty"`
+	SigAlg     string `json:"alg,omitempty"`
+	SigKeyID   string `json:"skid,omitempty"`
+	Signature  []byte `json:"sig,omitempty"`
+}
+
+// Options to configure a Sealer, at least one of KeyProvider or Signer must be set
+type Options struct {
+	// KeyProvider wraps the data keys, when nil the payload is only signed
+	KeyProvider KeyProvider
+	// Signer signs outgoing envelopes, when nil envelopes are not signed
+	Signer Signer
+	// Verifier checks signatures of incoming envelopes
+	Verifier Verifier
+	// RequireSignature rejects incoming envelopes without a valid signature
+	RequireSignature bool
+	// AllowPlaintext accepts incoming envelopes that aren't encrypted even though a key provider is set,
+	// e.g. while the publishers migrate to encryption
+	AllowPlaintext bool
+}
+
+// Sealer encrypts and signs message payloads, and opens them on the receiving side
+type Sealer struct {
+	keyProvider      KeyProvider
+	signer           Signer
+	verifier         Verifier
+	requireSignature bool
+	allowPlaintext   bool
+}
+
+// Create a new Sealer
+func New(opts Options) (*Sealer, error) {
+	if opts.KeyProvider == nil && opts.Signer == nil {
+		return nil, errors.New("envelope: a key provider or a signer is required")
+	}
+	if opts.RequireSignature && opts.Verifier == nil {
+		return nil, errors.New("envelope: a verifier is required when signatures are required")
+	}
+
+	return &Sealer{
+		keyProvider:      opts.KeyProvider,
+		signer:           opts.Signer,
+		verifier:         opts.Verifier,
+		requireSignature: opts.RequireSignature,
+		allowPlaintext:   opts.AllowPlaintext,
+	}, nil
+}
+
+// Seal the message data, returning a new message whose data is the serialized envelope
+func (s *Sealer) Seal(ctx context.Context, msg messaging.Message) (messaging.Message, error) {
+	env := Envelope{Version: envelopeVersion}
+	aad := additionalData(msg)
+
+	if s.keyProvider != nil {
+		// Generate a fresh data key for every message
+		dataKey := make([]byte, 32)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, err
+		}
+
+		keyID, wrappedKey, err := s.keyProvider.WrapKey(ctx, dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		gcm, err := newGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+
+		env.KeyID = keyID
+		env.WrappedKey = wrappedKey
+		env.Nonce = nonce
+		env.Ciphertext = gcm.Seal(nil, nonce, msg.GetData(), aad)
+	} else {
+		env.Payload = msg.GetData()
+	}
+
+	if s.signer != nil {
+		env.SigAlg = s.signer.Algorithm()
+		env.SigKeyID = s.signer.KeyID()
+		signature, err := s.signer.Sign(signingInput(&env, aad))
+		if err != nil {
+			return nil, err
+		}
+		env.Signature = signature
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyHeaders(msg, messaging.NewMessage(msg.GetOperationID(), msg.GetError(), msg.GetStatus(), msg.GetCommand(), data)), nil
+}
+
+// Open a sealed message, verifying the signature and decrypting the data
+func (s *Sealer) Open(ctx context.Context, msg messaging.Message) (messaging.Message, error) {
+	var env Envelope
+	if err := json.Unmarshal(msg.GetData(), &env); err != nil || env.Version != envelopeVersion {
+		return nil, ErrInvalidEnvelope
+	}
+	aad := additionalData(msg)
+
+	// Verify the signature first, so that tampered envelopes never reach the key provider.
+	// A signature that can't be checked is rejected rather than ignored
+	if len(env.Signature) > 0 {
+		if s.verifier == nil {
+			return nil, ErrMissingVerifier
+		}
+		err := s.verifier.Verify(env.SigAlg, env.SigKeyID, signingInput(&env, aad), env.Signature)
+		if err != nil {
+			return nil, err
+		}
+	} else if s.requireSignature {
+		return nil, ErrMissingSignature
+	}
+
+	// With a key provider, anyone able to publish could otherwise bypass the encryption with a plaintext payload
+	encrypted := len(env.WrappedKey) > 0 || len(env.Ciphertext) > 0
+	if s.keyProvider != nil && !encrypted && !s.allowPlaintext {
+		return nil, ErrNotEncrypted
+	}
+
+	data := env.Payload
+	if encrypted {
+		if s.keyProvider == nil {
+			return nil, ErrMissingKeyProvider
+		}
+
+		dataKey, err := s.keyProvider.UnwrapKey(ctx, env.KeyID, env.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+
+		gcm, err := newGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(env.Nonce) != gcm.NonceSize() {
+			return nil, ErrInvalidEnvelope
+		}
+
+		data, err = gcm.Open(nil, env.Nonce, env.Ciphertext, aad)
+		if err != nil {
+			return nil, ErrDecryptionFailed
+		}
+	}
+
+	return copyHeaders(msg, messaging.NewMessage(msg.GetOperationID(), msg.GetError(), msg.GetStatus(), msg.GetCommand(), data)), nil
+}
+
+// Copy the headers of the original message, they travel in clear so that subscribe filters keep working
+func copyHeaders(from messaging.Message, to messaging.Message) messaging.Message {
+	source, ok := from.(messaging.HeaderCarrier)
+	if !ok {
+		return to
+	}
+	target, ok := to.(messaging.HeaderCarrier)
+	if !ok {
+		return to
+	}
+	for key, value := range source.GetHeaders() {
+		target.SetHeader(key, value)
+	}
+
+	return to
+}
+
+// Message metadata bound to the payload. The operation ID is excluded since transports may overwrite it
+func additionalData(msg messaging.Message) []byte {
+	return []byte(msg.GetCommand() + "\x00" + msg.GetStatus())
+}
+
+// Build the byte sequence covered by the signature
+func signingInput(env *Envelope, aad []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(env.Version))
+	for _, part := range [][]byte{aad, []byte(env.KeyID), env.WrappedKey, env.Nonce, env.Ciphertext, env.Payload, []byte(env.SigAlg), []byte(env.SigKeyID)} {
+		// Length prefix every field so that boundaries can't be shifted
+		length := len(part)
+		buf.Write([]byte{byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)})
+		buf.Write(part)
+	}
+
+	return buf.Bytes()
+}
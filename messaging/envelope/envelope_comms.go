@@ -0,0 +1,61 @@
+package envelope
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// CommsSender seals messages before sending them through the inner sender
+type CommsSender struct {
+	inner  comms.CommsSender
+	sealer *Sealer
+}
+
+// Wrap a comms sender so that payloads are sealed end to end
+func NewCommsSender(inner comms.CommsSender, sealer *Sealer) *CommsSender {
+	return &CommsSender{
+		inner:  inner,
+		sealer: sealer,
+	}
+}
+
+// Seal the message and send it
+func (c *CommsSender) SendRequest(ctx context.Context, endpoint comms.EndPoint, data messaging.Message) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	sealed, err := c.sealer.Seal(ctx, data)
+	if err != nil {
+		xTelemetry.Error(ctx, "Envelope::SendRequest::Failed to seal message", telemetry.String("Error", err.Error()))
+		return err
+	}
+
+	return c.inner.SendRequest(ctx, endpoint, sealed)
+}
+
+// MessageHandlerFunc handles an opened message received by a comms receiver
+type MessageHandlerFunc func(context.Context, comms.ResponseWriter, messaging.Message) (context.Context, error)
+
+// Handler returns a comms handler that deserializes the request body into a message and opens it.
+// Requests that can't be opened are rejected with a bad request status
+func (s *Sealer) Handler(next MessageHandlerFunc) comms.HandlerFunc {
+	return func(ctx context.Context, w comms.ResponseWriter, r comms.Request) (context.Context, error) {
+		msg := messaging.NewMessage("", nil, "", "", nil)
+		err := msg.Deserialize(r.Body())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return ctx, err
+		}
+
+		opened, err := s.Open(ctx, msg)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return ctx, err
+		}
+
+		return next(ctx, w, opened)
+	}
+}
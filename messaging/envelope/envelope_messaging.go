@@ -0,0 +1,108 @@
+package envelope
+
+import (
+	"context"
+	"sync"
+
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// MessagingSystem seals messages before publishing them and opens them when received
+type MessagingSystem struct {
+	inner  messaging.MessagingSystem
+	sealer *Sealer
+}
+
+// Wrap a messaging system so that payloads are sealed end to end
+func NewMessagingSystem(inner messaging.MessagingSystem, sealer *Sealer) *MessagingSystem {
+	return &MessagingSystem{
+		inner:  inner,
+		sealer: sealer,
+	}
+}
+
+// Seal the message and publish it
+func (m *MessagingSystem) Publish(ctx context.Context, data messaging.Message) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	sealed, err := m.sealer.Seal(ctx, data)
+	if err != nil {
+		xTelemetry.Error(ctx, "Envelope::Publish::Failed to seal message", telemetry.String("Error", err.Error()))
+		return err
+	}
+
+	return m.inner.Publish(ctx, sealed)
+}
+
+// Subscribe to the inner messaging system, opening every received message.
+// Messages that can't be opened are delivered as error messages, like deserialization errors
+func (m *MessagingSystem) Subscribe(ctx context.Context) (<-chan messaging.Message, context.CancelFunc, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	innerChannel, cancel, err := m.inner.Subscribe(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Forwarding stops once the subscription is cancelled, even if nobody reads the channel anymore
+	eventChannel := make(chan messaging.Message)
+	done := make(chan struct{})
+	forward := func(msg messaging.Message) bool {
+		select {
+		case eventChannel <- msg:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(eventChannel)
+
+		for {
+			var msg messaging.Message
+			var ok bool
+			select {
+			case msg, ok = <-innerChannel:
+				if !ok {
+					return
+				}
+			case <-done:
+				return
+			}
+
+			// Error messages carry no payload, forward them as they are
+			if msg.GetError() != nil {
+				if !forward(msg) {
+					return
+				}
+				continue
+			}
+
+			opened, err := m.sealer.Open(ctx, msg)
+			if err != nil {
+				xTelemetry.Error(ctx, "Envelope::Subscribe::Failed to open message", telemetry.String("OperationID", msg.GetOperationID()), telemetry.String("Error", err.Error()))
+				opened = messaging.NewMessage(msg.GetOperationID(), err, msg.GetStatus(), msg.GetCommand(), nil)
+			}
+			if !forward(opened) {
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	cancelSubscription := func() {
+		once.Do(func() {
+			close(done)
+			cancel()
+		})
+	}
+
+	return eventChannel, cancelSubscription, nil
+}
+
+// Close the inner messaging system
+func (m *MessagingSystem) Close(ctx context.Context) error {
+	return m.inner.Close(ctx)
+}
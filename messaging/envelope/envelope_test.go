@@ -0,0 +1,332 @@
+package envelope_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/comms"
+	"github.com/perocha/goadapters/comms/httpadapter"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goadapters/messaging/envelope"
+	"github.com/perocha/goadapters/messaging/memory"
+	"github.com/perocha/goadapters/messaging/messagingtest"
+	"github.com/perocha/goutils/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func initializeTelemetry() context.Context {
+	telemetryConfig := telemetry.NewXTelemetryConfig("", "envelope", "info", 1)
+	xTelemetry, err := telemetry.NewXTelemetry(telemetryConfig)
+	if err != nil {
+		log.Fatalf("Main::Fatal error::Failed to initialize XTelemetry %s\n", err.Error())
+	}
+	return context.WithValue(context.Background(), telemetry.TelemetryContextKey, xTelemetry)
+}
+
+func newKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+	return key
+}
+
+func newSealer(t *testing.T) *envelope.Sealer {
+	keyProvider, err := envelope.NewStaticKeyProvider("kek-1", newKey(t))
+	assert.NoError(t, err)
+	hmacKey, err := envelope.NewHMACKey("sig-1", []byte("secret"))
+	assert.NoError(t, err)
+
+	sealer, err := envelope.New(envelope.Options{
+		KeyProvider:      keyProvider,
+		Signer:           hmacKey,
+		Verifier:         hmacKey,
+		RequireSignature: true,
+	})
+	assert.NoError(t, err)
+	return sealer
+}
+
+// fakeMessagingSystem delivers published messages to its subscriber
+type fakeMessagingSystem struct {
+	channel chan messaging.Message
+}
+
+func (f *fakeMessagingSystem) Publish(ctx context.Context, data messaging.Message) error {
+	f.channel <- data
+	return nil
+}
+
+func (f *fakeMessagingSystem) Subscribe(ctx context.Context) (<-chan messaging.Message, context.CancelFunc, error) {
+	return f.channel, func() {}, nil
+}
+
+func (f *fakeMessagingSystem) Close(ctx context.Context) error {
+	return nil
+}
+
+func TestSealAndOpen(t *testing.T) {
+	ctx := context.Background()
+	sealer := newSealer(t)
+
+	msg := messaging.NewMessage("op-1", nil, "pending", "create", []byte(`{"ssn":"123-45-6789"}`))
+	sealed, err := sealer.Seal(ctx, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "create", sealed.GetCommand())
+	assert.Equal(t, "op-1", sealed.GetOperationID())
+	assert.NotContains(t, string(sealed.GetData()), "123-45-6789")
+
+	opened, err := sealer.Open(ctx, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, msg.GetData(), opened.GetData())
+	assert.Equal(t, "pending", opened.GetStatus())
+}
+
+func TestOpen_TamperedMetadata(t *testing.T) {
+	ctx := context.Background()
+	sealer := newSealer(t)
+
+	sealed, err := sealer.Seal(ctx, messaging.NewMessage("", nil, "pending", "create", []byte("data")))
+	assert.NoError(t, err)
+
+	// Moving the payload to another command must be detected
+	tampered := messaging.NewMessage("", nil, "pending", "delete", sealed.GetData())
+	_, err = sealer.Open(ctx, tampered)
+	assert.ErrorIs(t, err, envelope.ErrInvalidSignature)
+}
+
+func TestOpen_TamperedCiphertext(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, _ := envelope.NewStaticKeyProvider("kek-1", newKey(t))
+	sealer, err := envelope.New(envelope.Options{KeyProvider: keyProvider})
+	assert.NoError(t, err)
+
+	sealed, err := sealer.Seal(ctx, messaging.NewMessage("", nil, "", "create", []byte("data")))
+	assert.NoError(t, err)
+
+	var env envelope.Envelope
+	assert.NoError(t, json.Unmarshal(sealed.GetData(), &env))
+	env.Ciphertext[0] ^= 0xff
+	data, _ := json.Marshal(env)
+
+	_, err = sealer.Open(ctx, messaging.NewMessage("", nil, "", "create", data))
+	assert.ErrorIs(t, err, envelope.ErrDecryptionFailed)
+}
+
+func TestOpen_MissingSignature(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, _ := envelope.NewStaticKeyProvider("kek-1", newKey(t))
+	hmacKey, _ := envelope.NewHMACKey("sig-1", []byte("secret"))
+
+	unsigned, _ := envelope.New(envelope.Options{KeyProvider: keyProvider})
+	strict, _ := envelope.New(envelope.Options{KeyProvider: keyProvider, Verifier: hmacKey, RequireSignature: true})
+
+	sealed, err := unsigned.Seal(ctx, messaging.NewMessage("", nil, "", "create", []byte("data")))
+	assert.NoError(t, err)
+
+	_, err = strict.Open(ctx, sealed)
+	assert.ErrorIs(t, err, envelope.ErrMissingSignature)
+}
+
+func TestOpen_PlaintextWithKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, _ := envelope.NewStaticKeyProvider("kek-1", newKey(t))
+	hmacKey, _ := envelope.NewHMACKey("sig-1", []byte("secret"))
+
+	// A signed but unencrypted payload doesn't bypass the encryption
+	signOnly, _ := envelope.New(envelope.Options{Signer: hmacKey})
+	plaintext, err := signOnly.Seal(ctx, messaging.NewMessage("", nil, "", "create", []byte("injected")))
+	assert.NoError(t, err)
+
+	encrypting, _ := envelope.New(envelope.Options{KeyProvider: keyProvider, Verifier: hmacKey})
+	_, err = encrypting.Open(ctx, plaintext)
+	assert.ErrorIs(t, err, envelope.ErrNotEncrypted)
+
+	// Unless plaintext is explicitly allowed
+	migrating, _ := envelope.New(envelope.Options{KeyProvider: keyProvider, Verifier: hmacKey, AllowPlaintext: true})
+	opened, err := migrating.Open(ctx, plaintext)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("injected"), opened.GetData())
+}
+
+func TestOpen_SignedWithoutVerifier(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, _ := envelope.NewStaticKeyProvider("kek-1", newKey(t))
+	hmacKey, _ := envelope.NewHMACKey("sig-1", []byte("secret"))
+
+	signing, _ := envelope.New(envelope.Options{KeyProvider: keyProvider, Signer: hmacKey})
+	sealed, err := signing.Seal(ctx, messaging.NewMessage("", nil, "", "create", []byte("data")))
+	assert.NoError(t, err)
+
+	// The signature isn't silently skipped
+	_, err = signing.Open(ctx, sealed)
+	assert.ErrorIs(t, err, envelope.ErrMissingVerifier)
+}
+
+func TestEd25519SignOnly(t *testing.T) {
+	ctx := context.Background()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	signer, err := envelope.NewEd25519Signer("ed-1", privateKey)
+	assert.NoError(t, err)
+	verifier, err := envelope.NewEd25519Verifier("ed-1", publicKey)
+	assert.NoError(t, err)
+
+	sender, _ := envelope.New(envelope.Options{Signer: signer})
+	receiver, _ := envelope.New(envelope.Options{Signer: signer, Verifier: envelope.MultiVerifier{verifier}, RequireSignature: true})
+
+	sealed, err := sender.Seal(ctx, messaging.NewMessage("", nil, "", "create", []byte("data")))
+	assert.NoError(t, err)
+
+	opened, err := receiver.Open(ctx, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), opened.GetData())
+}
+
+func TestEd25519Verifier_ConcurrentAddKey(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	verifier, err := envelope.NewEd25519Verifier("ed-1", publicKey)
+	assert.NoError(t, err)
+	signature := ed25519.Sign(privateKey, []byte("data"))
+
+	// Keys can be rotated while envelopes are verified
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, verifier.AddKey("ed-"+strconv.Itoa(i+2), publicKey))
+		}(i)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, verifier.Verify(envelope.AlgorithmEd25519, "ed-1", []byte("data"), signature))
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, verifier.Verify(envelope.AlgorithmEd25519, "ed-11", []byte("data"), signature))
+}
+
+func TestStaticKeyProvider_Rotation(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, _ := envelope.NewStaticKeyProvider("kek-1", newKey(t))
+	sealer, _ := envelope.New(envelope.Options{KeyProvider: keyProvider})
+
+	oldSealed, err := sealer.Seal(ctx, messaging.NewMessage("", nil, "", "create", []byte("old")))
+	assert.NoError(t, err)
+
+	// Rotate to a new key, messages sealed with the old key can still be opened
+	assert.NoError(t, keyProvider.AddKey("kek-2", newKey(t)))
+	assert.NoError(t, keyProvider.SetCurrentKey("kek-2"))
+
+	opened, err := sealer.Open(ctx, oldSealed)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("old"), opened.GetData())
+
+	assert.ErrorIs(t, keyProvider.SetCurrentKey("missing"), envelope.ErrUnknownKey)
+}
+
+func TestMessagingSystem(t *testing.T) {
+	ctx := initializeTelemetry()
+	inner := &fakeMessagingSystem{channel: make(chan messaging.Message, 2)}
+	secure := envelope.NewMessagingSystem(inner, newSealer(t))
+
+	channel, cancel, err := secure.Subscribe(ctx)
+	assert.NoError(t, err)
+	defer cancel()
+
+	err = secure.Publish(ctx, messaging.NewMessage("op-1", nil, "", "create", []byte("secret")))
+	assert.NoError(t, err)
+
+	// A message that isn't an envelope is delivered as an error message
+	err = inner.Publish(ctx, messaging.NewMessage("op-2", nil, "", "create", []byte("plain")))
+	assert.NoError(t, err)
+
+	received := <-channel
+	assert.NoError(t, received.GetError())
+	assert.Equal(t, []byte("secret"), received.GetData())
+
+	received = <-channel
+	assert.ErrorIs(t, received.GetError(), envelope.ErrInvalidEnvelope)
+	assert.Equal(t, "op-2", received.GetOperationID())
+}
+
+func TestCommsSenderAndHandler(t *testing.T) {
+	ctx := initializeTelemetry()
+	sealer := newSealer(t)
+
+	var receivedData []byte
+	handler := sealer.Handler(func(ctx context.Context, w comms.ResponseWriter, msg messaging.Message) (context.Context, error) {
+		receivedData = msg.GetData()
+		w.WriteHeader(http.StatusOK)
+		return ctx, nil
+	})
+
+	receiver, err := httpadapter.HTTPServerAdapterInit(ctx, "8080")
+	assert.NoError(t, err)
+	assert.NoError(t, receiver.RegisterEndPoint(ctx, "/test", handler))
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	httpSender, err := httpadapter.HttpSenderInit(ctx)
+	assert.NoError(t, err)
+	sender := envelope.NewCommsSender(httpSender, sealer)
+
+	endpoint := httpadapter.NewEndpoint("localhost", strings.Split(server.URL, ":")[2], "/test")
+	err = sender.SendRequest(ctx, endpoint, messaging.NewMessage("", nil, "", "create", []byte("secret")))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), receivedData)
+
+	// Plain messages are rejected by the handler
+	err = httpSender.SendRequest(ctx, endpoint, messaging.NewMessage("", nil, "", "create", []byte("secret")))
+	assert.Error(t, err)
+}
+
+func TestContract(t *testing.T) {
+	messagingtest.RunContractTests(t, func(t *testing.T) messaging.MessagingSystem {
+		return envelope.NewMessagingSystem(memory.NewMemoryAdapter("contract"), newSealer(t))
+	}, &messagingtest.Options{Context: initializeTelemetry()})
+}
+
+func TestMessagingSystem_CancelStopsForwarding(t *testing.T) {
+	ctx := initializeTelemetry()
+	inner := &fakeMessagingSystem{channel: make(chan messaging.Message, 2)}
+	secure := envelope.NewMessagingSystem(inner, newSealer(t))
+
+	channel, cancel, err := secure.Subscribe(ctx)
+	assert.NoError(t, err)
+
+	// Received while nobody reads the channel
+	err = secure.Publish(ctx, messaging.NewMessage("op-1", nil, "", "create", []byte("secret")))
+	assert.NoError(t, err)
+
+	// Cancelling releases the forwarding goroutine, which closes the channel
+	cancel()
+	cancel()
+	select {
+	case <-waitClosed(channel):
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+// Drain the channel until it's closed
+func waitClosed(channel <-chan messaging.Message) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		for range channel {
+		}
+		close(closed)
+	}()
+	return closed
+}
@@ -0,0 +1,126 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"sync"
+)
+
+// KeyProvider wraps and unwraps the per-message data keys (key encryption keys never leave the provider)
+type KeyProvider interface {
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error)
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+// StaticKeyProvider implements KeyProvider with local AES keys, intended for tests and local development
+type StaticKeyProvider struct {
+	mu           sync.RWMutex
+	currentKeyID string
+	keys         map[string][]byte
+}
+
+// Create a new static key provider, the key must be 16, 24 or 32 bytes long
+func NewStaticKeyProvider(keyID string, key []byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{
+		keys: make(map[string][]byte),
+	}
+
+	err := p.AddKey(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	p.currentKeyID = keyID
+
+	return p, nil
+}
+
+// Add a key that can be used to unwrap data keys, new data keys are still wrapped with the current key
+func (p *StaticKeyProvider) AddKey(keyID string, key []byte) error {
+	if keyID == "" {
+		return errors.New("key id is empty")
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[keyID] = append([]byte(nil), key...)
+
+	return nil
+}
+
+// Set the key used to wrap new data keys, the key must have been added before
+func (p *StaticKeyProvider) SetCurrentKey(keyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.keys[keyID]; !ok {
+		return ErrUnknownKey
+	}
+	p.currentKeyID = keyID
+
+	return nil
+}
+
+// Wrap a data key with the current key
+func (p *StaticKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	p.mu.RLock()
+	keyID := p.currentKeyID
+	key := p.keys[keyID]
+	p.mu.RUnlock()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+
+	// The wrapped key is the nonce followed by the sealed data key, the key id is bound as additional data
+	wrappedKey := gcm.Seal(nonce, nonce, dataKey, []byte(keyID))
+
+	return keyID, wrappedKey, nil
+}
+
+// Unwrap a data key previously wrapped by this provider
+func (p *StaticKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	p.mu.RLock()
+	key, ok := p.keys[keyID]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < gcm.NonceSize() {
+		return nil, ErrInvalidEnvelope
+	}
+
+	nonce, sealed := wrappedKey[:gcm.NonceSize()], wrappedKey[gcm.NonceSize():]
+	dataKey, err := gcm.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return dataKey, nil
+}
+
+// Create an AES-GCM cipher for the given key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
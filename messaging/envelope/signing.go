@@ -0,0 +1,190 @@
+package envelope
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"sync"
+)
+
+const (
+	// Supported signature algorithms
+	AlgorithmHMACSHA256 = "HS256"
+	AlgorithmEd25519    = "EdDSA"
+)
+
+// Signer produces a signature over the envelope contents
+type Signer interface {
+	Algorithm() string
+	KeyID() string
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier checks a signature produced by a Signer
+type Verifier interface {
+	Verify(algorithm string, keyID string, data []byte, signature []byte) error
+}
+
+// HMACKey signs and verifies envelopes with a shared HMAC-SHA256 secret
+type HMACKey struct {
+	keyID  string
+	secret []byte
+}
+
+// Create a new HMAC key, usable both as Signer and Verifier
+func NewHMACKey(keyID string, secret []byte) (*HMACKey, error) {
+	if keyID == "" {
+		return nil, errors.New("key id is empty")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("hmac secret is empty")
+	}
+
+	return &HMACKey{
+		keyID:  keyID,
+		secret: append([]byte(nil), secret...),
+	}, nil
+}
+
+// Get the signature algorithm
+func (k *HMACKey) Algorithm() string {
+	return AlgorithmHMACSHA256
+}
+
+// Get the key id
+func (k *HMACKey) KeyID() string {
+	return k.keyID
+}
+
+// Sign the data
+func (k *HMACKey) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(data)
+
+	return mac.Sum(nil), nil
+}
+
+// Verify the signature of the data
+func (k *HMACKey) Verify(algorithm string, keyID string, data []byte, signature []byte) error {
+	if algorithm != AlgorithmHMACSHA256 {
+		return ErrUnsupportedAlgorithm
+	}
+	if keyID != k.keyID {
+		return ErrUnknownKey
+	}
+
+	expected, _ := k.Sign(data)
+	if !hmac.Equal(expected, signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Ed25519Signer signs envelopes with an Ed25519 private key
+type Ed25519Signer struct {
+	keyID      string
+	privateKey ed25519.PrivateKey
+}
+
+// Create a new Ed25519 signer
+func NewEd25519Signer(keyID string, privateKey ed25519.PrivateKey) (*Ed25519Signer, error) {
+	if keyID == "" {
+		return nil, errors.New("key id is empty")
+	}
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key size")
+	}
+
+	return &Ed25519Signer{
+		keyID:      keyID,
+		privateKey: privateKey,
+	}, nil
+}
+
+// Get the signature algorithm
+func (s *Ed25519Signer) Algorithm() string {
+	return AlgorithmEd25519
+}
+
+// Get the key id
+func (s *Ed25519Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign the data
+func (s *Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.privateKey, data), nil
+}
+
+// Ed25519Verifier verifies envelopes with a set of Ed25519 public keys
+type Ed25519Verifier struct {
+	mu         sync.RWMutex
+	publicKeys map[string]ed25519.PublicKey
+}
+
+// Create a new Ed25519 verifier with a single public key, more keys can be added with AddKey
+func NewEd25519Verifier(keyID string, publicKey ed25519.PublicKey) (*Ed25519Verifier, error) {
+	v := &Ed25519Verifier{
+		publicKeys: make(map[string]ed25519.PublicKey),
+	}
+
+	err := v.AddKey(keyID, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Add a public key to the verifier
+func (v *Ed25519Verifier) AddKey(keyID string, publicKey ed25519.PublicKey) error {
+	if keyID == "" {
+		return errors.New("key id is empty")
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 public key size")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.publicKeys[keyID] = append(ed25519.PublicKey(nil), publicKey...)
+
+	return nil
+}
+
+// Verify the signature of the data
+func (v *Ed25519Verifier) Verify(algorithm string, keyID string, data []byte, signature []byte) error {
+	if algorithm != AlgorithmEd25519 {
+		return ErrUnsupportedAlgorithm
+	}
+
+	v.mu.RLock()
+	publicKey, ok := v.publicKeys[keyID]
+	v.mu.RUnlock()
+	if !ok {
+		return ErrUnknownKey
+	}
+	if !ed25519.Verify(publicKey, data, signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// MultiVerifier tries each verifier until one accepts the algorithm and key id
+type MultiVerifier []Verifier
+
+// Verify the signature with the first verifier that knows the algorithm and key
+func (m MultiVerifier) Verify(algorithm string, keyID string, data []byte, signature []byte) error {
+	for _, verifier := range m {
+		err := verifier.Verify(algorithm, keyID, data, signature)
+		if errors.Is(err, ErrUnsupportedAlgorithm) || errors.Is(err, ErrUnknownKey) {
+			continue
+		}
+		return err
+	}
+
+	return ErrUnknownKey
+}
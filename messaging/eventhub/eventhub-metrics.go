@@ -0,0 +1,33 @@
+package eventhub
+
+import (
+	"time"
+
+	"github.com/perocha/goadapters/metrics"
+)
+
+// Metrics of the event hub adapters, recorded into metrics.Default
+var (
+	publishedTotal = metrics.Default.Counter("eventhub_published_messages_total", "Messages published to an event hub", "eventhub", "result")
+	publishLatency = metrics.Default.Histogram("eventhub_publish_duration_seconds", "Latency of publishing a message to an event hub", nil, "eventhub", "result")
+	receivedTotal  = metrics.Default.Counter("eventhub_received_messages_total", "Messages received from an event hub partition", "eventhub", "partition", "result")
+	receiveLag     = metrics.Default.Histogram("eventhub_receive_lag_seconds", "Time between a message being enqueued in an event hub partition and received", []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900}, "eventhub", "partition")
+	partitionsOpen = metrics.Default.Gauge("eventhub_partitions_open", "Event hub partitions being processed", "eventhub")
+	inFlight       = metrics.Default.Gauge("eventhub_messages_in_flight", "Messages received from an event hub and not yet taken by the subscriber", "eventhub")
+)
+
+// Result label of a publish or a received message
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "success"
+}
+
+// Record a publish
+func observePublish(eventHubName string, startTime time.Time, err error) {
+	result := resultLabel(err)
+	publishedTotal.With(eventHubName, result).Inc()
+	publishLatency.With(eventHubName, result).ObserveSince(startTime)
+}
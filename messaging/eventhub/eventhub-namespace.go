@@ -0,0 +1,422 @@
+package eventhub
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs/checkpoints"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goutils/pkg/telemetry"
+)
+
+// EventHubNamespaceAdapter talks to every event hub of a namespace with a single set of credentials.
+// Producer clients are created lazily on the first publish to a hub and reused afterwards
+type EventHubNamespaceAdapter struct {
+	mu                sync.Mutex
+	defaultEventHub   string
+	newProducerClient func(eventHubName string) (*azeventhubs.ProducerClient, error)
+	newConsumerClient func(eventHubName string) (*azeventhubs.ConsumerClient, error)
+	checkClient       *container.Client
+	checkpointStore   *checkpoints.BlobStore
+	producers         map[string]*EventHubAdapterImpl
+	// Creates the consumer of a subscription, replaced in tests
+	newConsumer   func(ctx context.Context, eventHubName string) (eventHubConsumer, error)
+	subscriptions map[*subscription]struct{}
+	closed        bool
+}
+
+// eventHubConsumer is the part of EventHubAdapterImpl used by the subscriptions
+type eventHubConsumer interface {
+	Subscribe(ctx context.Context) (<-chan messaging.Message, context.CancelFunc, error)
+	CheckHealth(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// subscription owns the consumers started by a SubscribeTo call, they're closed when it's cancelled
+type subscription struct {
+	ctx       context.Context
+	mu        sync.Mutex
+	consumers []eventHubConsumer
+	cancels   []context.CancelFunc
+	closed    bool
+}
+
+// Initializes a namespace adapter using a namespace level connection string (without EntityPath).
+// containerName and checkpointStoreConnectionString may be empty when the adapter is only used to publish
+func NamespaceInitializer(ctx context.Context, connectionString, defaultEventHubName, containerName, checkpointStoreConnectionString string) (*EventHubNamespaceAdapter, error) {
+	adapter := &EventHubNamespaceAdapter{
+		defaultEventHub: defaultEventHubName,
+		newProducerClient: func(eventHubName string) (*azeventhubs.ProducerClient, error) {
+			return azeventhubs.NewProducerClientFromConnectionString(connectionString, eventHubName, nil)
+		},
+		newConsumerClient: func(eventHubName string) (*azeventhubs.ConsumerClient, error) {
+			return azeventhubs.NewConsumerClientFromConnectionString(connectionString, eventHubName, azeventhubs.DefaultConsumerGroup, nil)
+		},
+		producers:     make(map[string]*EventHubAdapterImpl),
+		subscriptions: make(map[*subscription]struct{}),
+	}
+	adapter.newConsumer = adapter.createConsumer
+
+	if err := adapter.initCheckpointStore(ctx, containerName, checkpointStoreConnectionString); err != nil {
+		return nil, err
+	}
+
+	return adapter, nil
+}
+
+// Initializes a namespace adapter using an Azure AD credential, e.g. azidentity.NewDefaultAzureCredential.
+// fullyQualifiedNamespace is the namespace host name, such as "mynamespace.servicebus.windows.net"
+func NamespaceCredentialInitializer(ctx context.Context, fullyQualifiedNamespace string, credential azcore.TokenCredential, defaultEventHubName, containerName, checkpointStoreConnectionString string) (*EventHubNamespaceAdapter, error) {
+	adapter := &EventHubNamespaceAdapter{
+		defaultEventHub: defaultEventHubName,
+		newProducerClient: func(eventHubName string) (*azeventhubs.ProducerClient, error) {
+			return azeventhubs.NewProducerClient(fullyQualifiedNamespace, eventHubName, credential, nil)
+		},
+		newConsumerClient: func(eventHubName string) (*azeventhubs.ConsumerClient, error) {
+			return azeventhubs.NewConsumerClient(fullyQualifiedNamespace, eventHubName, azeventhubs.DefaultConsumerGroup, credential, nil)
+		},
+		producers:     make(map[string]*EventHubAdapterImpl),
+		subscriptions: make(map[*subscription]struct{}),
+	}
+	adapter.newConsumer = adapter.createConsumer
+
+	if err := adapter.initCheckpointStore(ctx, containerName, checkpointStoreConnectionString); err != nil {
+		return nil, err
+	}
+
+	return adapter, nil
+}
+
+// Creates the shared checkpoint store, if configured
+func (a *EventHubNamespaceAdapter) initCheckpointStore(ctx context.Context, containerName, checkpointStoreConnectionString string) error {
+	if containerName == "" || checkpointStoreConnectionString == "" {
+		return nil
+	}
+
+	checkClient, checkpointStore, err := newCheckpointStore(ctx, containerName, checkpointStoreConnectionString)
+	if err != nil {
+		return err
+	}
+	a.checkClient = checkClient
+	a.checkpointStore = checkpointStore
+
+	return nil
+}
+
+// Publish an event to the default event hub
+func (a *EventHubNamespaceAdapter) Publish(ctx context.Context, data messaging.Message) error {
+	return a.PublishTo(ctx, a.defaultEventHub, data)
+}
+
+// Publish an event to the given event hub, creating its producer client if needed
+func (a *EventHubNamespaceAdapter) PublishTo(ctx context.Context, topic string, data messaging.Message) error {
+	producer, err := a.producer(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	return producer.Publish(ctx, data)
+}
+
+// Subscribe to the default event hub
+func (a *EventHubNamespaceAdapter) Subscribe(ctx context.Context) (<-chan messaging.Message, context.CancelFunc, error) {
+	return a.SubscribeTo(ctx, a.defaultEventHub)
+}
+
+// Subscribe to one or more event hubs, merging their events in a single channel.
+// Cancelling the subscription closes the consumer clients it opened
+func (a *EventHubNamespaceAdapter) SubscribeTo(ctx context.Context, topics ...string) (<-chan messaging.Message, context.CancelFunc, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	if len(topics) == 0 {
+		return nil, nil, errors.New("no event hub to subscribe to")
+	}
+
+	// The consumers are closed after the subscription's context is cancelled, so they keep its values only
+	sub := &subscription{ctx: context.WithoutCancel(ctx)}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil, nil, errors.New("eventhub namespace adapter is closed")
+	}
+	a.subscriptions[sub] = struct{}{}
+	a.mu.Unlock()
+
+	// Start a processor for every event hub
+	var channels []<-chan messaging.Message
+	for _, topic := range topics {
+		consumer, err := a.consumer(ctx, topic)
+		if err == nil {
+			err = sub.add(consumer, nil)
+		}
+		if err != nil {
+			a.unsubscribe(sub)
+			return nil, nil, err
+		}
+
+		channel, cancel, err := consumer.Subscribe(ctx)
+		if err == nil {
+			err = sub.add(nil, cancel)
+		}
+		if err != nil {
+			xTelemetry.Error(ctx, "EventHubNamespaceAdapter::SubscribeTo::Failed", telemetry.String("EventHub", topic), telemetry.String("Error", err.Error()))
+			a.unsubscribe(sub)
+			return nil, nil, err
+		}
+		channels = append(channels, channel)
+	}
+
+	// Merge all the channels, forwarding stops once the subscription is cancelled or the channel is closed.
+	// The merged channel is closed once every forwarder stopped
+	eventChannel := make(chan messaging.Message)
+	done := make(chan struct{})
+	var forwarders sync.WaitGroup
+	for _, channel := range channels {
+		forwarders.Add(1)
+		go func(channel <-chan messaging.Message) {
+			defer forwarders.Done()
+			for {
+				select {
+				case msg, ok := <-channel:
+					if !ok {
+						return
+					}
+					select {
+					case eventChannel <- msg:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}(channel)
+	}
+	go func() {
+		forwarders.Wait()
+		close(eventChannel)
+	}()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			a.unsubscribe(sub)
+		})
+	}
+
+	return eventChannel, cancel, nil
+}
+
+// Stop a subscription and close its consumers
+func (a *EventHubNamespaceAdapter) unsubscribe(sub *subscription) {
+	a.mu.Lock()
+	delete(a.subscriptions, sub)
+	a.mu.Unlock()
+
+	if err := sub.close(); err != nil {
+		xTelemetry := telemetry.GetXTelemetryClient(sub.ctx)
+		xTelemetry.Error(sub.ctx, "EventHubNamespaceAdapter::Failed to close subscription", telemetry.String("Error", err.Error()))
+	}
+}
+
+// Add a consumer or the cancel func of its processor, either may be nil.
+// If the subscription was closed meanwhile, e.g. by Close, they're closed at once
+func (s *subscription) add(consumer eventHubConsumer, cancel context.CancelFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		if cancel != nil {
+			cancel()
+		}
+		if consumer != nil {
+			consumer.Close(s.ctx)
+		}
+		return errors.New("eventhub namespace adapter is closed")
+	}
+	if consumer != nil {
+		s.consumers = append(s.consumers, consumer)
+	}
+	if cancel != nil {
+		s.cancels = append(s.cancels, cancel)
+	}
+
+	return nil
+}
+
+// Get the consumers of a running subscription
+func (s *subscription) running() []eventHubConsumer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	return append([]eventHubConsumer(nil), s.consumers...)
+}
+
+// Stop the processors and close the consumers, once
+func (s *subscription) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+	var errs []error
+	for _, consumer := range s.consumers {
+		errs = append(errs, consumer.Close(s.ctx))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close every client created by the adapter
+func (a *EventHubNamespaceAdapter) Close(ctx context.Context) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+
+	producers := a.producers
+	subscriptions := a.subscriptions
+	a.producers = nil
+	a.subscriptions = nil
+	a.mu.Unlock()
+
+	var errs []error
+	for _, producer := range producers {
+		errs = append(errs, producer.Close(ctx))
+	}
+	for sub := range subscriptions {
+		errs = append(errs, sub.close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Check the connection to the default event hub and to the hubs of the running subscriptions, implements comms.HealthChecker
+func (a *EventHubNamespaceAdapter) CheckHealth(ctx context.Context) error {
+	var checks []eventHubConsumer
+	if a.defaultEventHub != "" {
+		producer, err := a.producer(ctx, a.defaultEventHub)
+		if err != nil {
+			return err
+		}
+		checks = append(checks, producer)
+	}
+
+	a.mu.Lock()
+	for sub := range a.subscriptions {
+		checks = append(checks, sub.running()...)
+	}
+	a.mu.Unlock()
+
+	var errs []error
+	for _, check := range checks {
+		errs = append(errs, check.CheckHealth(ctx))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Get the producer adapter for an event hub, creating it on first use. The producer is created outside
+// the lock, since it fetches the event hub properties, so a slow hub doesn't hold up the others
+func (a *EventHubNamespaceAdapter) producer(ctx context.Context, eventHubName string) (*EventHubAdapterImpl, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	a.mu.Lock()
+	closed := a.closed
+	producer, ok := a.producers[eventHubName]
+	a.mu.Unlock()
+	if closed {
+		return nil, errors.New("eventhub namespace adapter is closed")
+	}
+	if ok {
+		return producer, nil
+	}
+
+	xTelemetry.Debug(ctx, "EventHubNamespaceAdapter::Creating producer client", telemetry.String("EventHub", eventHubName))
+	producerClient, err := a.newProducerClient(eventHubName)
+	if err != nil {
+		xTelemetry.Error(ctx, "EventHubNamespaceAdapter::Failed to initialize producer", telemetry.String("EventHub", eventHubName), telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+
+	created, err := newProducerAdapter(ctx, producerClient)
+	if err != nil {
+		producerClient.Close(ctx)
+		return nil, err
+	}
+
+	// Another publish may have created the producer meanwhile, or the adapter may have been closed
+	a.mu.Lock()
+	closed = a.closed
+	producer, ok = a.producers[eventHubName]
+	if !closed && !ok {
+		a.producers[eventHubName] = created
+	}
+	a.mu.Unlock()
+
+	switch {
+	case closed:
+		created.Close(ctx)
+		return nil, errors.New("eventhub namespace adapter is closed")
+	case ok:
+		created.Close(ctx)
+		return producer, nil
+	default:
+		return created, nil
+	}
+}
+
+// Create a consumer adapter for an event hub. A processor can only run once, so every subscription gets its own
+func (a *EventHubNamespaceAdapter) consumer(ctx context.Context, eventHubName string) (eventHubConsumer, error) {
+	a.mu.Lock()
+	closed := a.closed
+	a.mu.Unlock()
+	if closed {
+		return nil, errors.New("eventhub namespace adapter is closed")
+	}
+
+	return a.newConsumer(ctx, eventHubName)
+}
+
+// Create the consumer client and processor of an event hub
+func (a *EventHubNamespaceAdapter) createConsumer(ctx context.Context, eventHubName string) (eventHubConsumer, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	if a.checkpointStore == nil {
+		err := errors.New("checkpoint store is not configured")
+		xTelemetry.Error(ctx, "EventHubNamespaceAdapter::SubscribeTo::Failed", telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+
+	xTelemetry.Debug(ctx, "EventHubNamespaceAdapter::Creating consumer client", telemetry.String("EventHub", eventHubName))
+	consumerClient, err := a.newConsumerClient(eventHubName)
+	if err != nil {
+		xTelemetry.Error(ctx, "EventHubNamespaceAdapter::Error creating consumer client", telemetry.String("EventHub", eventHubName), telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+
+	consumer, err := newConsumerAdapter(ctx, consumerClient, a.checkClient, a.checkpointStore)
+	if err != nil {
+		consumerClient.Close(ctx)
+		return nil, err
+	}
+
+	return consumer, nil
+}
@@ -12,7 +12,7 @@ import (
 )
 
 // Publish an event to the EventHub
-func (p *EventHubAdapterImpl) Publish(ctx context.Context, data messaging.Message) error {
+func (p *EventHubAdapterImpl) Publish(ctx context.Context, data messaging.Message) (err error) {
 	startTime := time.Now()
 
 	// Add the operation ID to the context
@@ -27,8 +27,19 @@ func (p *EventHubAdapterImpl) Publish(ctx context.Context, data messaging.Messag
 		return err
 	}
 
-	// Create a new batch
-	batch, err := p.ehProducerClient.NewEventDataBatch(ctx, nil)
+	// Record the outcome in the metrics
+	defer func() {
+		observePublish(p.eventHubName, startTime, err)
+	}()
+
+	// Create a new batch, messages with a partition key go to the same partition so that their order is kept
+	var batchOptions *azeventhubs.EventDataBatchOptions
+	if carrier, ok := data.(messaging.HeaderCarrier); ok {
+		if partitionKey := carrier.GetHeader(messaging.PartitionKeyHeader); partitionKey != "" {
+			batchOptions = &azeventhubs.EventDataBatchOptions{PartitionKey: &partitionKey}
+		}
+	}
+	batch, err := p.ehProducerClient.NewEventDataBatch(ctx, batchOptions)
 	if err != nil {
 		panic(err)
 	}
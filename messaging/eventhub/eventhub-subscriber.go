@@ -3,6 +3,7 @@ package eventhub
 import (
 	"context"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
@@ -60,11 +61,20 @@ func (a *EventHubAdapterImpl) dispatchPartitionClients(ctx context.Context, even
 func (a *EventHubAdapterImpl) processEventsForPartition(ctx context.Context, partitionClient *azeventhubs.ProcessorPartitionClient, eventChannel chan messaging.Message) error {
 	xTelemetry := telemetry.GetXTelemetryClient(ctx)
 
+	// Subscribe filter, messages not matching it are dropped but still checkpointed
+	filter := messaging.FilterFromContext(ctx)
+
 	// Defer the shutdown of the partition resources
 	defer func() {
 		shutdownPartitionResources(ctx, partitionClient)
 	}()
 
+	// Count the partitions being processed, and the messages received but not yet taken by the subscriber
+	partitions := partitionsOpen.With(a.eventHubName)
+	partitions.Inc()
+	defer partitions.Dec()
+	pending := inFlight.With(a.eventHubName)
+
 	for {
 		// Receive events from the partition client with a timeout of 20 seconds
 		timeout := time.Second * 20
@@ -83,10 +93,17 @@ func (a *EventHubAdapterImpl) processEventsForPartition(ctx context.Context, par
 		// Uncomment the following line to verify that the consumer is trying to receive events
 		xTelemetry.Debug(ctx, "EventHubAdapter::processEventsForPartition", telemetry.String("PartitionID", partitionClient.PartitionID()), telemetry.Int("Events", len(events)))
 
+		filtered := 0
+		pending.Add(float64(len(events)))
 		for _, eventItem := range events {
 			// Track the current time to log the telemetry
 			startTime := time.Now()
 
+			// Record how long the message waited in the partition
+			if eventItem.EnqueuedTime != nil {
+				receiveLag.With(a.eventHubName, partitionClient.PartitionID()).Observe(startTime.Sub(*eventItem.EnqueuedTime).Seconds())
+			}
+
 			// eventItem.Body is a byte slice and needs to be unmarshalled into a message
 			receivedMessage := messaging.NewMessage("", nil, "", "", nil)
 			err := receivedMessage.Deserialize(eventItem.Body)
@@ -96,14 +113,25 @@ func (a *EventHubAdapterImpl) processEventsForPartition(ctx context.Context, par
 				xTelemetry.Error(ctx, "EventHubAdapter::processEventsForPartition::Error unmarshalling event body", telemetry.String("PartitionID", partitionClient.PartitionID()), telemetry.String("Error", err.Error()))
 				errorMessage := messaging.NewMessage("", err, "", "", nil)
 				eventChannel <- errorMessage
+				receivedTotal.With(a.eventHubName, partitionClient.PartitionID(), "error").Inc()
+			} else if !filter.Matches(receivedMessage) {
+				// The message doesn't match the subscribe filter, drop it
+				filtered++
+				receivedTotal.With(a.eventHubName, partitionClient.PartitionID(), "filtered").Inc()
 			} else {
 				// If we reach this point, we have a message!! Get the operation ID from the message and add it to the context
 				ctx := context.WithValue(context.Background(), telemetry.OperationIDKeyContextKey, receivedMessage.GetOperationID())
 				xTelemetry.Debug(ctx, "EventHubAdapter::processEventsForPartition::Message received", telemetry.String("PartitionID", partitionClient.PartitionID()), telemetry.String("OperationID", receivedMessage.GetOperationID()))
 				eventChannel <- receivedMessage
+				receivedTotal.With(a.eventHubName, partitionClient.PartitionID(), "success").Inc()
 
 				xTelemetry.Dependency(ctx, "EventHub", a.eventHubName, true, startTime, time.Now(), "EventHubAdapter::processEventsForPartition::Message received", telemetry.String("PartitionID", partitionClient.PartitionID()), telemetry.String("Command", receivedMessage.GetCommand()), telemetry.String("Status", receivedMessage.GetStatus()))
 			}
+			pending.Dec()
+		}
+
+		if filtered != 0 {
+			xTelemetry.Info(ctx, "EventHubAdapter::processEventsForPartition::Messages filtered", telemetry.String("PartitionID", partitionClient.PartitionID()), telemetry.String("EventHub", a.eventHubName), telemetry.String("Filtered", strconv.Itoa(filtered)))
 		}
 
 		if len(events) != 0 {
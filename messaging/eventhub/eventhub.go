@@ -2,6 +2,8 @@ package eventhub
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs/checkpoints"
@@ -22,26 +24,46 @@ type EventHubAdapterImpl struct {
 func ConsumerInitializer(ctx context.Context, eventHubName, consumerConnectionString, containerName, checkpointStoreConnectionString string) (*EventHubAdapterImpl, error) {
 	xTelemetry := telemetry.GetXTelemetryClient(ctx)
 
+	// create a checkpoint store that will be used by the event hub
+	checkClient, checkpointStore, err := newCheckpointStore(ctx, containerName, checkpointStoreConnectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	// create a consumer client using a connection string to the namespace and the event hub
+	consumerClient, err := azeventhubs.NewConsumerClientFromConnectionString(consumerConnectionString, eventHubName, azeventhubs.DefaultConsumerGroup, nil)
+	if err != nil {
+		xTelemetry.Error(ctx, "EventHubAdapter::Error creating consumer client", telemetry.String("Error", err.Error()))
+		return nil, err
+	}
+
+	return newConsumerAdapter(ctx, consumerClient, checkClient, checkpointStore)
+}
+
+// Creates the blob container client and the checkpoint store used by consumers
+func newCheckpointStore(ctx context.Context, containerName, checkpointStoreConnectionString string) (*container.Client, *checkpoints.BlobStore, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
 	// create a container client using a connection string and container name
 	checkClient, err := container.NewClientFromConnectionString(checkpointStoreConnectionString, containerName, nil)
 	if err != nil {
 		xTelemetry.Error(ctx, "EventHubAdapter::Error creating container client", telemetry.String("Error", err.Error()))
-		return nil, err
+		return nil, nil, err
 	}
 
 	// create a checkpoint store that will be used by the event hub
 	checkpointStore, err := checkpoints.NewBlobStore(checkClient, nil)
 	if err != nil {
 		xTelemetry.Error(ctx, "EventHubAdapter::Error creating checkpoint store", telemetry.String("Error", err.Error()))
-		return nil, err
+		return nil, nil, err
 	}
 
-	// create a consumer client using a connection string to the namespace and the event hub
-	consumerClient, err := azeventhubs.NewConsumerClientFromConnectionString(consumerConnectionString, eventHubName, azeventhubs.DefaultConsumerGroup, nil)
-	if err != nil {
-		xTelemetry.Error(ctx, "EventHubAdapter::Error creating consumer client", telemetry.String("Error", err.Error()))
-		return nil, err
-	}
+	return checkClient, checkpointStore, nil
+}
+
+// Creates the processor for an existing consumer client and wraps both in an adapter
+func newConsumerAdapter(ctx context.Context, consumerClient *azeventhubs.ConsumerClient, checkClient *container.Client, checkpointStore *checkpoints.BlobStore) (*EventHubAdapterImpl, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
 
 	// Create a processor to receive and process events
 	processor, err := azeventhubs.NewProcessor(consumerClient, checkpointStore, nil)
@@ -79,6 +101,13 @@ func ProducerInitializer(ctx context.Context, eventHubName, producerConnectionSt
 		return nil, err
 	}
 
+	return newProducerAdapter(ctx, producerClient)
+}
+
+// Wraps an existing producer client in an adapter
+func newProducerAdapter(ctx context.Context, producerClient *azeventhubs.ProducerClient) (*EventHubAdapterImpl, error) {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
 	// Obtain the eventHubName from the producer client
 	eventHubProperties, err := producerClient.GetEventHubProperties(ctx, nil)
 	if err != nil {
@@ -121,3 +150,27 @@ func (a *EventHubAdapterImpl) Close(ctx context.Context) error {
 
 	return nil
 }
+
+// Check the connection to the event hub by reading its properties, implements comms.HealthChecker
+func (a *EventHubAdapterImpl) CheckHealth(ctx context.Context) error {
+	xTelemetry := telemetry.GetXTelemetryClient(ctx)
+
+	startTime := time.Now()
+	var err error
+	switch {
+	case a.ehProducerClient != nil:
+		_, err = a.ehProducerClient.GetEventHubProperties(ctx, nil)
+	case a.ehConsumerClient != nil:
+		_, err = a.ehConsumerClient.GetEventHubProperties(ctx, nil)
+	default:
+		err = errors.New("eventhub adapter has no client")
+	}
+
+	if err != nil {
+		xTelemetry.Dependency(ctx, "EventHub", a.eventHubName, false, startTime, time.Now(), "EventHubAdapter::CheckHealth::Failed", telemetry.String("Error", err.Error()))
+		return err
+	}
+	xTelemetry.Dependency(ctx, "EventHub", a.eventHubName, true, startTime, time.Now(), "EventHubAdapter::CheckHealth::Success")
+
+	return nil
+}
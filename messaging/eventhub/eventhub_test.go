@@ -0,0 +1,219 @@
+package eventhub_test
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goadapters/messaging/eventhub"
+	"github.com/perocha/goutils/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initializeTelemetry() context.Context {
+	// Initialize telemetry package
+	serviceName := "eventhub"
+	telemetryConfig := telemetry.NewXTelemetryConfig("", serviceName, "info", 1)
+	xTelemetry, err := telemetry.NewXTelemetry(telemetryConfig)
+	if err != nil {
+		log.Fatalf("Main::Fatal error::Failed to initialize XTelemetry %s\n", err.Error())
+	}
+	// Add telemetry object to the context, so that it can be reused across the application
+	ctx := context.WithValue(context.Background(), telemetry.TelemetryContextKey, xTelemetry)
+	return ctx
+}
+
+// fakeConsumer stands for the consumer client and processor of an event hub
+type fakeConsumer struct {
+	eventHub     string
+	events       chan messaging.Message
+	subscribeErr error
+	healthErr    error
+	healthChecks atomic.Int32
+	cancelled    atomic.Bool
+	closed       atomic.Int32
+}
+
+func (c *fakeConsumer) Subscribe(ctx context.Context) (<-chan messaging.Message, context.CancelFunc, error) {
+	if c.subscribeErr != nil {
+		return nil, nil, c.subscribeErr
+	}
+	return c.events, func() { c.cancelled.Store(true) }, nil
+}
+
+func (c *fakeConsumer) CheckHealth(ctx context.Context) error {
+	c.healthChecks.Add(1)
+	return c.healthErr
+}
+
+func (c *fakeConsumer) Close(ctx context.Context) error {
+	c.closed.Add(1)
+	return nil
+}
+
+// fakeNamespace creates a fake consumer for every subscribed event hub
+type fakeNamespace struct {
+	mu        sync.Mutex
+	consumers []*fakeConsumer
+	// Event hubs whose Subscribe fails
+	failing map[string]bool
+}
+
+func (n *fakeNamespace) newConsumer(ctx context.Context, eventHubName string) (eventhub.Consumer, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	consumer := &fakeConsumer{eventHub: eventHubName, events: make(chan messaging.Message)}
+	if n.failing[eventHubName] {
+		consumer.subscribeErr = errors.New("processor failed")
+	}
+	n.consumers = append(n.consumers, consumer)
+	return consumer, nil
+}
+
+func (n *fakeNamespace) all() []*fakeConsumer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*fakeConsumer(nil), n.consumers...)
+}
+
+func receive(t *testing.T, events <-chan messaging.Message) messaging.Message {
+	select {
+	case msg := <-events:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestNamespace_SubscribeToMergesAndCancelCloses(t *testing.T) {
+	ctx := initializeTelemetry()
+	namespace := &fakeNamespace{}
+	adapter := eventhub.NewNamespaceAdapterWithConsumers("", namespace.newConsumer)
+
+	events, cancel, err := adapter.SubscribeTo(ctx, "orders", "payments")
+	require.NoError(t, err)
+	consumers := namespace.all()
+	require.Len(t, consumers, 2)
+
+	// Events of every event hub come out of the same channel
+	go func() { consumers[0].events <- messaging.NewMessage("", nil, "", "order", nil) }()
+	assert.Equal(t, "order", receive(t, events).GetCommand())
+	go func() { consumers[1].events <- messaging.NewMessage("", nil, "", "payment", nil) }()
+	assert.Equal(t, "payment", receive(t, events).GetCommand())
+
+	// Cancelling stops the processors and closes the clients, once
+	cancel()
+	cancel()
+	for _, consumer := range consumers {
+		assert.True(t, consumer.cancelled.Load())
+		assert.Equal(t, int32(1), consumer.closed.Load())
+	}
+
+	// Closing the adapter doesn't close them again
+	require.NoError(t, adapter.Close(ctx))
+	for _, consumer := range consumers {
+		assert.Equal(t, int32(1), consumer.closed.Load())
+	}
+}
+
+func TestNamespace_SubscribeToClosesMergedChannel(t *testing.T) {
+	ctx := initializeTelemetry()
+	namespace := &fakeNamespace{}
+	adapter := eventhub.NewNamespaceAdapterWithConsumers("", namespace.newConsumer)
+
+	events, cancel, err := adapter.SubscribeTo(ctx, "orders", "payments")
+	require.NoError(t, err)
+	defer cancel()
+	consumers := namespace.all()
+
+	// A closed event hub channel isn't forwarded as nil messages, the others keep flowing
+	close(consumers[0].events)
+	go func() { consumers[1].events <- messaging.NewMessage("", nil, "", "payment", nil) }()
+	assert.Equal(t, "payment", receive(t, events).GetCommand())
+
+	// The merged channel is closed once every event hub channel is
+	close(consumers[1].events)
+	select {
+	case msg, ok := <-events:
+		assert.False(t, ok, "unexpected message %v", msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("merged channel not closed")
+	}
+
+	// Cancelling closes it too
+	events, cancel, err = adapter.SubscribeTo(ctx, "orders")
+	require.NoError(t, err)
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("merged channel not closed")
+	}
+}
+
+func TestNamespace_SubscribeToFailureClosesConsumers(t *testing.T) {
+	ctx := initializeTelemetry()
+	namespace := &fakeNamespace{failing: map[string]bool{"payments": true}}
+	adapter := eventhub.NewNamespaceAdapterWithConsumers("", namespace.newConsumer)
+
+	_, _, err := adapter.SubscribeTo(ctx, "orders", "payments")
+	assert.Error(t, err)
+
+	// The consumers opened before the failure are closed too
+	consumers := namespace.all()
+	require.Len(t, consumers, 2)
+	assert.True(t, consumers[0].cancelled.Load())
+	for _, consumer := range consumers {
+		assert.Equal(t, int32(1), consumer.closed.Load())
+	}
+}
+
+func TestNamespace_CloseClosesSubscriptions(t *testing.T) {
+	ctx := initializeTelemetry()
+	namespace := &fakeNamespace{}
+	adapter := eventhub.NewNamespaceAdapterWithConsumers("", namespace.newConsumer)
+
+	_, cancel, err := adapter.SubscribeTo(ctx, "orders")
+	require.NoError(t, err)
+	require.NoError(t, adapter.Close(ctx))
+	consumers := namespace.all()
+	assert.Equal(t, int32(1), consumers[0].closed.Load())
+
+	// Cancelling after Close is harmless, new subscriptions are refused
+	cancel()
+	assert.Equal(t, int32(1), consumers[0].closed.Load())
+	_, _, err = adapter.SubscribeTo(ctx, "orders")
+	assert.Error(t, err)
+}
+
+func TestNamespace_CheckHealthOfLiveSubscriptions(t *testing.T) {
+	ctx := initializeTelemetry()
+	namespace := &fakeNamespace{}
+	adapter := eventhub.NewNamespaceAdapterWithConsumers("", namespace.newConsumer)
+
+	_, cancelOrders, err := adapter.SubscribeTo(ctx, "orders")
+	require.NoError(t, err)
+	_, cancelPayments, err := adapter.SubscribeTo(ctx, "payments")
+	require.NoError(t, err)
+	defer cancelPayments()
+	consumers := namespace.all()
+
+	consumers[0].healthErr = errors.New("connection lost")
+	assert.Error(t, adapter.CheckHealth(ctx))
+
+	// A cancelled subscription isn't checked anymore
+	cancelOrders()
+	checks := consumers[0].healthChecks.Load()
+	assert.NoError(t, adapter.CheckHealth(ctx))
+	assert.Equal(t, checks, consumers[0].healthChecks.Load())
+	assert.Equal(t, int32(2), consumers[1].healthChecks.Load())
+}
@@ -0,0 +1,16 @@
+package eventhub
+
+import "context"
+
+// Consumer is the consumer of a subscription, exported for tests
+type Consumer = eventHubConsumer
+
+// Create a namespace adapter whose subscriptions use the consumers created by newConsumer
+func NewNamespaceAdapterWithConsumers(defaultEventHub string, newConsumer func(ctx context.Context, eventHubName string) (Consumer, error)) *EventHubNamespaceAdapter {
+	return &EventHubNamespaceAdapter{
+		defaultEventHub: defaultEventHub,
+		producers:       make(map[string]*EventHubAdapterImpl),
+		subscriptions:   make(map[*subscription]struct{}),
+		newConsumer:     newConsumer,
+	}
+}
@@ -0,0 +1,337 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Filter decides whether a received message is delivered to the subscriber
+type Filter func(msg Message) bool
+
+type filterKey struct{}
+
+// WithFilter returns a context that makes Subscribe (and SubscribeTo) deliver only the messages matching every filter.
+// Filtered messages are dropped inside the adapter, they're still acknowledged (checkpointed) by the messaging system
+func WithFilter(ctx context.Context, filters ...Filter) context.Context {
+	if existing := FilterFromContext(ctx); existing != nil {
+		filters = append([]Filter{existing}, filters...)
+	}
+
+	return context.WithValue(ctx, filterKey{}, And(filters...))
+}
+
+// FilterFromContext returns the subscribe filter set in the context, or nil if there is none
+func FilterFromContext(ctx context.Context) Filter {
+	filter, _ := ctx.Value(filterKey{}).(Filter)
+	return filter
+}
+
+// Matches reports whether the message passes the filter, a nil filter matches every message
+func (f Filter) Matches(msg Message) bool {
+	return f == nil || f(msg)
+}
+
+// ByCommand matches messages with any of the given commands
+func ByCommand(commands ...string) Filter {
+	return func(msg Message) bool {
+		return contains(commands, msg.GetCommand())
+	}
+}
+
+// ByStatus matches messages with any of the given statuses
+func ByStatus(statuses ...string) Filter {
+	return func(msg Message) bool {
+		return contains(statuses, msg.GetStatus())
+	}
+}
+
+// ByHeader matches messages whose header has any of the given values, or is set at all when no value is given
+func ByHeader(key string, values ...string) Filter {
+	return func(msg Message) bool {
+		carrier, ok := msg.(HeaderCarrier)
+		if !ok {
+			return false
+		}
+		if len(values) == 0 {
+			_, set := carrier.GetHeaders()[key]
+			return set
+		}
+		return contains(values, carrier.GetHeader(key))
+	}
+}
+
+// And matches messages matching all the filters
+func And(filters ...Filter) Filter {
+	return func(msg Message) bool {
+		for _, filter := range filters {
+			if !filter.Matches(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches messages matching at least one of the filters
+func Or(filters ...Filter) Filter {
+	return func(msg Message) bool {
+		for _, filter := range filters {
+			if filter.Matches(msg) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches messages not matching the filter
+func Not(filter Filter) Filter {
+	return func(msg Message) bool {
+		return !filter.Matches(msg)
+	}
+}
+
+// ParseFilter compiles a filter expression. The language supports the fields command, status, operationID and
+// header.<name>, compared with == or != against a quoted string, or with "in" against a list of strings.
+// Comparisons are combined with &&, || and !, and grouped with parentheses:
+//
+//	command in ('create', 'update') && header.tenant == "contoso" && !(status == 'failed')
+func ParseFilter(expression string) (Filter, error) {
+	p := &filterParser{input: expression}
+	p.next()
+
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.kind != tokenEOF {
+		return nil, p.errorf("unexpected %q", p.token.text)
+	}
+
+	return filter, nil
+}
+
+// Check whether the value is in the list
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOperator
+	tokenError
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Recursive descent parser for filter expressions
+type filterParser struct {
+	input string
+	pos   int
+	token filterToken
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("filter: position %d: %s", p.token.pos, fmt.Sprintf(format, args...))
+}
+
+// Read the next token
+func (p *filterParser) next() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.input) {
+		p.token = filterToken{kind: tokenEOF, pos: start}
+		return
+	}
+
+	c := p.input[p.pos]
+	switch {
+	case c == '\'' || c == '"':
+		end := strings.IndexByte(p.input[p.pos+1:], c)
+		if end < 0 {
+			p.token = filterToken{kind: tokenError, text: "unterminated string", pos: start}
+			p.pos = len(p.input)
+			return
+		}
+		p.token = filterToken{kind: tokenString, text: p.input[p.pos+1 : p.pos+1+end], pos: start}
+		p.pos += end + 2
+	case unicode.IsLetter(rune(c)) || c == '_':
+		for p.pos < len(p.input) && isIdentChar(p.input[p.pos]) {
+			p.pos++
+		}
+		p.token = filterToken{kind: tokenIdent, text: p.input[start:p.pos], pos: start}
+	default:
+		for _, op := range []string{"&&", "||", "==", "!=", "!", "(", ")", ","} {
+			if strings.HasPrefix(p.input[p.pos:], op) {
+				p.pos += len(op)
+				p.token = filterToken{kind: tokenOperator, text: op, pos: start}
+				return
+			}
+		}
+		p.token = filterToken{kind: tokenError, text: string(c), pos: start}
+		p.pos = len(p.input)
+	}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func (p *filterParser) isOperator(op string) bool {
+	return p.token.kind == tokenOperator && p.token.text == op
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or(left, right)
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And(left, right)
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.isOperator("!") {
+		p.next()
+		filter, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(filter), nil
+	}
+
+	if p.isOperator("(") {
+		p.next()
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOperator(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		p.next()
+		return filter, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	if p.token.kind != tokenIdent {
+		return nil, p.errorf("expected a field, got %q", p.token.text)
+	}
+	field, err := fieldFilter(p.token.text)
+	if err != nil {
+		return nil, p.errorf("%s", err.Error())
+	}
+	p.next()
+
+	switch {
+	case p.isOperator("=="), p.isOperator("!="):
+		negate := p.token.text == "!="
+		p.next()
+		if p.token.kind != tokenString {
+			return nil, p.errorf("expected a quoted string")
+		}
+		filter := field([]string{p.token.text})
+		p.next()
+		if negate {
+			return Not(filter), nil
+		}
+		return filter, nil
+	case p.token.kind == tokenIdent && p.token.text == "in":
+		p.next()
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return field(values), nil
+	default:
+		return nil, p.errorf("expected ==, != or in")
+	}
+}
+
+// Parse a parenthesized list of strings
+func (p *filterParser) parseList() ([]string, error) {
+	if !p.isOperator("(") {
+		return nil, p.errorf("expected '('")
+	}
+	p.next()
+
+	var values []string
+	for {
+		if p.token.kind != tokenString {
+			return nil, p.errorf("expected a quoted string")
+		}
+		values = append(values, p.token.text)
+		p.next()
+
+		if p.isOperator(")") {
+			p.next()
+			return values, nil
+		}
+		if !p.isOperator(",") {
+			return nil, p.errorf("expected ',' or ')'")
+		}
+		p.next()
+	}
+}
+
+// Map a field name to the filter constructor matching any of the values
+func fieldFilter(name string) (func(values []string) Filter, error) {
+	switch {
+	case name == "command":
+		return func(values []string) Filter { return ByCommand(values...) }, nil
+	case name == "status":
+		return func(values []string) Filter { return ByStatus(values...) }, nil
+	case name == "operationID":
+		return func(values []string) Filter {
+			return func(msg Message) bool { return contains(values, msg.GetOperationID()) }
+		}, nil
+	case strings.HasPrefix(name, "header.") && len(name) > len("header."):
+		key := strings.TrimPrefix(name, "header.")
+		return func(values []string) Filter { return ByHeader(key, values...) }, nil
+	default:
+		return nil, fmt.Errorf("unknown field %q", name)
+	}
+}
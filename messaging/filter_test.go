@@ -0,0 +1,81 @@
+package messaging_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/perocha/goadapters/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHeaderMessage(command string, status string, headers map[string]string) messaging.Message {
+	msg := messaging.NewMessage("op-1", nil, status, command, nil)
+	for key, value := range headers {
+		msg.(messaging.HeaderCarrier).SetHeader(key, value)
+	}
+	return msg
+}
+
+func TestFilters(t *testing.T) {
+	msg := newHeaderMessage("create", "pending", map[string]string{"tenant": "contoso"})
+
+	assert.True(t, messaging.ByCommand("update", "create")(msg))
+	assert.False(t, messaging.ByStatus("failed")(msg))
+	assert.True(t, messaging.ByHeader("tenant", "contoso")(msg))
+	assert.True(t, messaging.ByHeader("tenant")(msg))
+	assert.False(t, messaging.ByHeader("region")(msg))
+	assert.True(t, messaging.And(messaging.ByCommand("create"), messaging.Not(messaging.ByStatus("failed")))(msg))
+	assert.True(t, messaging.Or(messaging.ByCommand("delete"), messaging.ByStatus("pending"))(msg))
+}
+
+func TestParseFilter(t *testing.T) {
+	msg := newHeaderMessage("create", "pending", map[string]string{"tenant": "contoso"})
+
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{`command == 'create'`, true},
+		{`command != "create"`, false},
+		{`command in ('update', 'create') && status == 'pending'`, true},
+		{`header.tenant == 'contoso' && !(status == 'failed')`, true},
+		{`status == 'failed' || operationID == 'op-1'`, true},
+		{`!command in ('create') || header.region == 'eu'`, false},
+	}
+
+	for _, test := range tests {
+		filter, err := messaging.ParseFilter(test.expression)
+		assert.NoError(t, err, test.expression)
+		assert.Equal(t, test.expected, filter(msg), test.expression)
+	}
+}
+
+func TestParseFilter_Errors(t *testing.T) {
+	for _, expression := range []string{
+		``,
+		`command`,
+		`command == create`,
+		`unknown == 'x'`,
+		`command == 'create' &&`,
+		`(command == 'create'`,
+		`command in ('a' 'b')`,
+		`command == 'create`,
+		`command == 'create' $`,
+	} {
+		_, err := messaging.ParseFilter(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestWithFilter(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, messaging.FilterFromContext(ctx))
+
+	ctx = messaging.WithFilter(ctx, messaging.ByCommand("create"))
+	ctx = messaging.WithFilter(ctx, messaging.ByStatus("pending"))
+	filter := messaging.FilterFromContext(ctx)
+
+	assert.True(t, filter.Matches(newHeaderMessage("create", "pending", nil)))
+	assert.False(t, filter.Matches(newHeaderMessage("create", "failed", nil)))
+	assert.False(t, filter.Matches(newHeaderMessage("update", "pending", nil)))
+}
@@ -0,0 +1,189 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/perocha/goadapters/messaging"
+)
+
+// Default capacity of every subscription channel
+const defaultBufferSize = 100
+
+var ErrClosed = errors.New("memory adapter is closed")
+
+// MemoryAdapterImpl is an in-process messaging system, useful for tests and local development.
+// Every subscriber of a topic receives every message published to it after subscribing
+type MemoryAdapterImpl struct {
+	mu           sync.RWMutex
+	defaultTopic string
+	bufferSize   int
+	subscribers  map[string][]*subscriber
+	closed       bool
+	filtered     atomic.Int64
+}
+
+// A subscription to one or more topics
+type subscriber struct {
+	channel chan messaging.Message
+	filter  messaging.Filter
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Create a new in-memory adapter, Publish and Subscribe use the default topic
+func NewMemoryAdapter(defaultTopic string) *MemoryAdapterImpl {
+	return &MemoryAdapterImpl{
+		defaultTopic: defaultTopic,
+		bufferSize:   defaultBufferSize,
+		subscribers:  make(map[string][]*subscriber),
+	}
+}
+
+// Set the capacity of the channels returned by new subscriptions, publishers block while a subscriber's buffer is full
+func (a *MemoryAdapterImpl) SetBufferSize(bufferSize int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.bufferSize = bufferSize
+}
+
+// Publish a message to the default topic
+func (a *MemoryAdapterImpl) Publish(ctx context.Context, data messaging.Message) error {
+	return a.PublishTo(ctx, a.defaultTopic, data)
+}
+
+// Publish a message to a topic
+func (a *MemoryAdapterImpl) PublishTo(ctx context.Context, topic string, data messaging.Message) error {
+	if data == nil {
+		return errors.New("message is nil")
+	}
+
+	// The read lock is held while delivering, so cancelled subscriptions can't close their channel under us
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+
+	for _, sub := range a.subscribers[topic] {
+		if !sub.filter.Matches(data) {
+			a.filtered.Add(1)
+			continue
+		}
+
+		select {
+		case sub.channel <- data:
+		case <-sub.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Subscribe to the default topic
+func (a *MemoryAdapterImpl) Subscribe(ctx context.Context) (<-chan messaging.Message, context.CancelFunc, error) {
+	return a.SubscribeTo(ctx, a.defaultTopic)
+}
+
+// Subscribe to one or more topics, the returned channel is closed when the subscription is cancelled or the adapter closed
+func (a *MemoryAdapterImpl) SubscribeTo(ctx context.Context, topics ...string) (<-chan messaging.Message, context.CancelFunc, error) {
+	if len(topics) == 0 {
+		return nil, nil, errors.New("no topic to subscribe to")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil, nil, ErrClosed
+	}
+
+	sub := &subscriber{
+		channel: make(chan messaging.Message, a.bufferSize),
+		filter:  messaging.FilterFromContext(ctx),
+		done:    make(chan struct{}),
+	}
+	for _, topic := range topics {
+		a.subscribers[topic] = append(a.subscribers[topic], sub)
+	}
+
+	cancel := func() {
+		a.unsubscribe(sub)
+	}
+
+	return sub.channel, cancel, nil
+}
+
+// Number of deliveries dropped because the message didn't match the subscriber's filter
+func (a *MemoryAdapterImpl) FilteredCount() int64 {
+	return a.filtered.Load()
+}
+
+// Close the adapter, closing every subscription channel. Closing twice is a no-op
+func (a *MemoryAdapterImpl) Close(ctx context.Context) error {
+	// Release blocked publishers before taking the write lock
+	a.mu.RLock()
+	for _, subs := range a.subscribers {
+		for _, sub := range subs {
+			sub.stop()
+		}
+	}
+	a.mu.RUnlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	closed := make(map[*subscriber]bool)
+	for _, subs := range a.subscribers {
+		for _, sub := range subs {
+			if !closed[sub] {
+				close(sub.channel)
+				closed[sub] = true
+			}
+		}
+	}
+	a.subscribers = make(map[string][]*subscriber)
+
+	return nil
+}
+
+// Remove a subscription from every topic and close its channel
+func (a *MemoryAdapterImpl) unsubscribe(sub *subscriber) {
+	sub.stop()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	found := false
+	for topic, subs := range a.subscribers {
+		for i, s := range subs {
+			if s == sub {
+				a.subscribers[topic] = append(subs[:i:i], subs[i+1:]...)
+				found = true
+				break
+			}
+		}
+	}
+
+	// The channel is already closed if the adapter was closed first
+	if found {
+		close(sub.channel)
+	}
+}
+
+// Signal publishers to stop delivering to this subscriber
+func (s *subscriber) stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
@@ -0,0 +1,94 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/perocha/goadapters/messaging"
+	"github.com/perocha/goadapters/messaging/memory"
+	"github.com/perocha/goadapters/messaging/messagingtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func testInterfaceImplementation(t *testing.T, adapter messaging.TopicMessagingSystem) {
+	assert.Implements(t, (*messaging.TopicMessagingSystem)(nil), adapter)
+}
+
+func TestInterface(t *testing.T) {
+	testInterfaceImplementation(t, memory.NewMemoryAdapter("orders"))
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	ctx := context.Background()
+	adapter := memory.NewMemoryAdapter("orders")
+
+	channel, cancel, err := adapter.Subscribe(ctx)
+	assert.NoError(t, err)
+	defer cancel()
+
+	err = adapter.Publish(ctx, messaging.NewMessage("op-1", nil, "", "create", []byte("data")))
+	assert.NoError(t, err)
+
+	msg := <-channel
+	assert.Equal(t, "op-1", msg.GetOperationID())
+	assert.Equal(t, []byte("data"), msg.GetData())
+}
+
+func TestSubscribeTo_MultipleTopics(t *testing.T) {
+	ctx := context.Background()
+	adapter := memory.NewMemoryAdapter("orders")
+
+	channel, cancel, err := adapter.SubscribeTo(ctx, "orders", "payments")
+	assert.NoError(t, err)
+	defer cancel()
+
+	assert.NoError(t, adapter.PublishTo(ctx, "payments", messaging.NewMessage("op-1", nil, "", "pay", nil)))
+	assert.NoError(t, adapter.PublishTo(ctx, "shipping", messaging.NewMessage("op-2", nil, "", "ship", nil)))
+	assert.NoError(t, adapter.Publish(ctx, messaging.NewMessage("op-3", nil, "", "create", nil)))
+
+	assert.Equal(t, "pay", (<-channel).GetCommand())
+	assert.Equal(t, "create", (<-channel).GetCommand())
+}
+
+func TestCancelAndClose(t *testing.T) {
+	ctx := context.Background()
+	adapter := memory.NewMemoryAdapter("orders")
+
+	channel, cancel, err := adapter.Subscribe(ctx)
+	assert.NoError(t, err)
+
+	cancel()
+	_, ok := <-channel
+	assert.False(t, ok)
+
+	assert.NoError(t, adapter.Close(ctx))
+	assert.NoError(t, adapter.Close(ctx))
+	assert.ErrorIs(t, adapter.Publish(ctx, messaging.NewMessage("", nil, "", "create", nil)), memory.ErrClosed)
+
+	_, _, err = adapter.Subscribe(ctx)
+	assert.ErrorIs(t, err, memory.ErrClosed)
+}
+
+func TestSubscribe_Filter(t *testing.T) {
+	ctx := context.Background()
+	adapter := memory.NewMemoryAdapter("orders")
+
+	filter, err := messaging.ParseFilter(`command == 'create'`)
+	assert.NoError(t, err)
+
+	channel, cancel, err := adapter.Subscribe(messaging.WithFilter(ctx, filter))
+	assert.NoError(t, err)
+	defer cancel()
+
+	assert.NoError(t, adapter.Publish(ctx, messaging.NewMessage("op-1", nil, "", "delete", nil)))
+	assert.NoError(t, adapter.Publish(ctx, messaging.NewMessage("op-2", nil, "", "create", nil)))
+
+	assert.Equal(t, "op-2", (<-channel).GetOperationID())
+	assert.Equal(t, int64(1), adapter.FilteredCount())
+}
+
+func TestContract(t *testing.T) {
+	messagingtest.RunContractTests(t, func(t *testing.T) messaging.MessagingSystem {
+		return memory.NewMemoryAdapter("contract")
+	}, nil)
+}
@@ -16,13 +16,24 @@ type Message interface {
 	Serialize() ([]byte, error)
 }
 
+// PartitionKeyHeader is the message header holding the partition key. Messaging systems deliver messages with the same key in publish order
+const PartitionKeyHeader = "PartitionKey"
+
+// HeaderCarrier is implemented by messages that carry application headers alongside the data
+type HeaderCarrier interface {
+	GetHeader(key string) string
+	SetHeader(key string, value string)
+	GetHeaders() map[string]string
+}
+
 // MessageImpl implements the Message interface
 type MessageImpl struct {
-	OperationID string `json:"operationID"`
-	Command     string `json:"command"`
-	Status      string `json:"status"`
-	Error       error  `json:"error"`
-	Data        []byte `json:"data"`
+	OperationID string            `json:"operationID"`
+	Command     string            `json:"command"`
+	Status      string            `json:"status"`
+	Error       error             `json:"error"`
+	Data        []byte            `json:"data"`
+	Headers     map[string]string `json:"headers,omitempty"`
 }
 
 // GetError returns the error
@@ -55,6 +66,24 @@ func (m *MessageImpl) GetData() []byte {
 	return m.Data
 }
 
+// Get a header value, empty if the header is not set
+func (m *MessageImpl) GetHeader(key string) string {
+	return m.Headers[key]
+}
+
+// Set a header value
+func (m *MessageImpl) SetHeader(key string, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
+
+// Get all the headers
+func (m *MessageImpl) GetHeaders() map[string]string {
+	return m.Headers
+}
+
 // Deserializes a byte slice into Message
 func (m *MessageImpl) Deserialize(message []byte) error {
 	if m == nil {
@@ -2,9 +2,17 @@ package messaging
 
 import "context"
 
-// Interface for messaging systems
+// Interface for messaging systems. Subscribe only delivers the messages matching the filter set with WithFilter, if any
 type MessagingSystem interface {
 	Publish(ctx context.Context, data Message) error
 	Subscribe(ctx context.Context) (<-chan Message, context.CancelFunc, error)
 	Close(ctx context.Context) error
 }
+
+// Interface for messaging systems that address several topics (e.g. event hubs in a namespace) with a single set of credentials.
+// Publish and Subscribe use the default topic the system was created with
+type TopicMessagingSystem interface {
+	MessagingSystem
+	PublishTo(ctx context.Context, topic string, data Message) error
+	SubscribeTo(ctx context.Context, topics ...string) (<-chan Message, context.CancelFunc, error)
+}
@@ -0,0 +1,291 @@
+// Package messagingtest provides a contract test suite for messaging.MessagingSystem implementations.
+//
+// Implementations run the suite from their own tests:
+//
+//	func TestContract(t *testing.T) {
+//		messagingtest.RunContractTests(t, func(t *testing.T) messaging.MessagingSystem {
+//			return memory.NewMemoryAdapter("contract")
+//		}, nil)
+//	}
+package messagingtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perocha/goadapters/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory creates a fresh messaging system for every test, with no pending messages
+type Factory func(t *testing.T) messaging.MessagingSystem
+
+// Options to tune the suite for slower backends
+type Options struct {
+	// Context passed to every call, e.g. carrying the telemetry client. Defaults to context.Background()
+	Context context.Context
+	// ReceiveTimeout is the maximum wait for an expected message. Defaults to 5 seconds
+	ReceiveTimeout time.Duration
+	// QuietPeriod is how long the suite waits to assert that no message is delivered. Defaults to 200 milliseconds
+	QuietPeriod time.Duration
+	// SettleTime is waited after subscribing and before publishing, for backends that connect asynchronously
+	SettleTime time.Duration
+}
+
+// Suite state shared by the contract tests
+type suite struct {
+	factory Factory
+	options Options
+}
+
+// RunContractTests runs every contract test against the messaging systems created by the factory.
+// Topic-aware systems (messaging.TopicMessagingSystem) also run the topic contract
+func RunContractTests(t *testing.T, factory Factory, options *Options) {
+	s := &suite{factory: factory}
+	if options != nil {
+		s.options = *options
+	}
+	if s.options.Context == nil {
+		s.options.Context = context.Background()
+	}
+	if s.options.ReceiveTimeout <= 0 {
+		s.options.ReceiveTimeout = 5 * time.Second
+	}
+	if s.options.QuietPeriod <= 0 {
+		s.options.QuietPeriod = 200 * time.Millisecond
+	}
+
+	t.Run("PublishSubscribeRoundTrip", s.testRoundTrip)
+	t.Run("OrderingPerKey", s.testOrderingPerKey)
+	t.Run("ConcurrentPublishers", s.testConcurrentPublishers)
+	t.Run("ErrorMessageDelivery", s.testErrorMessageDelivery)
+	t.Run("SubscribeFilter", s.testSubscribeFilter)
+	t.Run("CancelStopsDelivery", s.testCancel)
+	t.Run("CloseIsIdempotent", s.testCloseIdempotent)
+	t.Run("Topics", s.testTopics)
+}
+
+// Create a messaging system that is closed when the test ends
+func (s *suite) newSystem(t *testing.T) messaging.MessagingSystem {
+	system := s.factory(t)
+	require.NotNil(t, system)
+	t.Cleanup(func() {
+		_ = system.Close(s.options.Context)
+	})
+	return system
+}
+
+// Subscribe, cancelling the subscription when the test ends
+func (s *suite) subscribe(t *testing.T, ctx context.Context, system messaging.MessagingSystem) (<-chan messaging.Message, context.CancelFunc) {
+	channel, cancel, err := system.Subscribe(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, channel)
+	require.NotNil(t, cancel)
+	t.Cleanup(cancel)
+
+	time.Sleep(s.options.SettleTime)
+	return channel, cancel
+}
+
+// Receive the next message or fail the test after the receive timeout
+func (s *suite) receive(t *testing.T, channel <-chan messaging.Message) messaging.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-channel:
+		require.True(t, ok, "subscription channel closed unexpectedly")
+		return msg
+	case <-time.After(s.options.ReceiveTimeout):
+		require.FailNow(t, "timed out waiting for a message")
+		return nil
+	}
+}
+
+// Assert that no message is delivered during the quiet period
+func (s *suite) assertNoMessage(t *testing.T, channel <-chan messaging.Message) {
+	t.Helper()
+	select {
+	case msg, ok := <-channel:
+		if ok {
+			assert.Failf(t, "unexpected message", "command %q, operation ID %q", msg.GetCommand(), msg.GetOperationID())
+		}
+	case <-time.After(s.options.QuietPeriod):
+	}
+}
+
+// Build a message with headers
+func newMessage(operationID string, command string, headers map[string]string) messaging.Message {
+	msg := messaging.NewMessage(operationID, nil, "pending", command, []byte(`{"id":"`+operationID+`"}`))
+	if carrier, ok := msg.(messaging.HeaderCarrier); ok {
+		for key, value := range headers {
+			carrier.SetHeader(key, value)
+		}
+	}
+	return msg
+}
+
+func (s *suite) testRoundTrip(t *testing.T) {
+	ctx := s.options.Context
+	system := s.newSystem(t)
+	channel, _ := s.subscribe(t, ctx, system)
+
+	sent := newMessage("op-roundtrip", "create", map[string]string{"tenant": "contoso"})
+	require.NoError(t, system.Publish(ctx, sent))
+
+	received := s.receive(t, channel)
+	assert.NoError(t, received.GetError())
+	assert.Equal(t, sent.GetOperationID(), received.GetOperationID())
+	assert.Equal(t, sent.GetCommand(), received.GetCommand())
+	assert.Equal(t, sent.GetStatus(), received.GetStatus())
+	assert.Equal(t, sent.GetData(), received.GetData())
+
+	if carrier, ok := received.(messaging.HeaderCarrier); ok {
+		assert.Equal(t, "contoso", carrier.GetHeader("tenant"))
+	}
+}
+
+func (s *suite) testOrderingPerKey(t *testing.T) {
+	ctx := s.options.Context
+	system := s.newSystem(t)
+	channel, _ := s.subscribe(t, ctx, system)
+
+	keys := []string{"a", "b", "c"}
+	perKey := 10
+	for i := 0; i < perKey; i++ {
+		for _, key := range keys {
+			msg := newMessage(key+"-"+strconv.Itoa(i), "ordered", map[string]string{messaging.PartitionKeyHeader: key})
+			require.NoError(t, system.Publish(ctx, msg))
+		}
+	}
+
+	next := make(map[string]int)
+	for i := 0; i < perKey*len(keys); i++ {
+		msg := s.receive(t, channel)
+		require.NoError(t, msg.GetError())
+
+		var key string
+		var sequence int
+		_, err := fmt.Sscanf(msg.GetOperationID(), "%1s-%d", &key, &sequence)
+		require.NoError(t, err)
+		assert.Equal(t, next[key], sequence, "out of order delivery for key %q", key)
+		next[key] = sequence + 1
+	}
+}
+
+func (s *suite) testConcurrentPublishers(t *testing.T) {
+	ctx := s.options.Context
+	system := s.newSystem(t)
+	channel, _ := s.subscribe(t, ctx, system)
+
+	publishers := 5
+	perPublisher := 20
+	var wg sync.WaitGroup
+	errs := make(chan error, publishers*perPublisher)
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perPublisher; i++ {
+				errs <- system.Publish(ctx, newMessage(fmt.Sprintf("p%d-%d", p, i), "concurrent", nil))
+			}
+		}(p)
+	}
+
+	// Receive while publishing, so that backends with bounded buffers don't block publishers forever
+	received := make(map[string]int)
+	for i := 0; i < publishers*perPublisher; i++ {
+		msg := s.receive(t, channel)
+		received[msg.GetOperationID()]++
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Len(t, received, publishers*perPublisher)
+	for operationID, count := range received {
+		assert.Equal(t, 1, count, "message %q delivered %d times", operationID, count)
+	}
+}
+
+func (s *suite) testErrorMessageDelivery(t *testing.T) {
+	ctx := s.options.Context
+	system := s.newSystem(t)
+	channel, _ := s.subscribe(t, ctx, system)
+
+	// A message carrying an error must reach the subscriber as an error message, not be dropped or close the channel
+	require.NoError(t, system.Publish(ctx, messaging.NewMessage("op-error", errors.New("forced error"), "failed", "create", nil)))
+	msg := s.receive(t, channel)
+	assert.Error(t, msg.GetError())
+
+	// The subscription keeps working afterwards
+	require.NoError(t, system.Publish(ctx, newMessage("op-after-error", "create", nil)))
+	msg = s.receive(t, channel)
+	assert.NoError(t, msg.GetError())
+	assert.Equal(t, "op-after-error", msg.GetOperationID())
+}
+
+func (s *suite) testSubscribeFilter(t *testing.T) {
+	ctx := s.options.Context
+	system := s.newSystem(t)
+	channel, _ := s.subscribe(t, messaging.WithFilter(ctx, messaging.ByCommand("wanted")), system)
+
+	require.NoError(t, system.Publish(ctx, newMessage("op-unwanted", "unwanted", nil)))
+	require.NoError(t, system.Publish(ctx, newMessage("op-wanted", "wanted", nil)))
+
+	msg := s.receive(t, channel)
+	assert.Equal(t, "op-wanted", msg.GetOperationID())
+	s.assertNoMessage(t, channel)
+}
+
+func (s *suite) testCancel(t *testing.T) {
+	ctx := s.options.Context
+	system := s.newSystem(t)
+	channel, cancel := s.subscribe(t, ctx, system)
+
+	require.NoError(t, system.Publish(ctx, newMessage("op-before-cancel", "create", nil)))
+	assert.Equal(t, "op-before-cancel", s.receive(t, channel).GetOperationID())
+
+	// After cancelling, nothing else is delivered (the channel may be closed). Cancelling twice is safe
+	cancel()
+	cancel()
+	_ = system.Publish(ctx, newMessage("op-after-cancel", "create", nil))
+	s.assertNoMessage(t, channel)
+}
+
+func (s *suite) testCloseIdempotent(t *testing.T) {
+	ctx := s.options.Context
+	system := s.factory(t)
+
+	assert.NoError(t, system.Close(ctx))
+	assert.NoError(t, system.Close(ctx))
+}
+
+func (s *suite) testTopics(t *testing.T) {
+	ctx := s.options.Context
+	system, ok := s.newSystem(t).(messaging.TopicMessagingSystem)
+	if !ok {
+		t.Skip("not a topic-aware messaging system")
+	}
+
+	channel, cancel, err := system.SubscribeTo(ctx, "contract-a", "contract-b")
+	require.NoError(t, err)
+	t.Cleanup(cancel)
+	time.Sleep(s.options.SettleTime)
+
+	require.NoError(t, system.PublishTo(ctx, "contract-c", newMessage("op-c", "create", nil)))
+	require.NoError(t, system.PublishTo(ctx, "contract-a", newMessage("op-a", "create", nil)))
+	require.NoError(t, system.PublishTo(ctx, "contract-b", newMessage("op-b", "create", nil)))
+
+	received := map[string]bool{}
+	received[s.receive(t, channel).GetOperationID()] = true
+	received[s.receive(t, channel).GetOperationID()] = true
+	assert.Equal(t, map[string]bool{"op-a": true, "op-b": true}, received)
+	s.assertNoMessage(t, channel)
+}
@@ -0,0 +1,385 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the Prometheus text format,
+// without depending on the Prometheus client. Adapters record into Default, which HttpReceiver exposes
+// with httpadapter.WithMetrics:
+//
+//	requests := metrics.Default.Counter("orders_created_total", "Orders created", "channel")
+//	requests.With("web").Inc()
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Content type of the text format written by Registry.Write
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Buckets of latency histograms in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry used by the adapters
+var Default = NewRegistry()
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Kinds of metric, as written in the TYPE line
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Registry keeps metrics by name. Getting a metric that already exists returns it, so adapters created
+// more than once share their metrics. It's safe for concurrent use
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*family
+}
+
+// Create an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*family)}
+}
+
+// family is a metric with a series per combination of label values
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+// series is a metric for one combination of label values
+type series struct {
+	labelValues []string
+	// Value of counters and gauges, as float64 bits
+	value atomic.Uint64
+	// Observations of histograms, counts per bucket (not cumulative)
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Get or create a metric. Getting an existing metric with another kind, labels or buckets panics, it's a programming error
+func (r *Registry) family(name string, help string, kind string, buckets []float64, labels []string) *family {
+	if !metricNamePattern.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !labelNamePattern.MatchString(label) || strings.HasPrefix(label, "__") || (kind == kindHistogram && label == "le") {
+			panic(fmt.Sprintf("metrics: invalid label name %q in %s", label, name))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.metrics[name]; ok {
+		if existing.kind != kind || !equal(existing.labels, labels) || !equal(existing.buckets, buckets) {
+			panic(fmt.Sprintf("metrics: %s already registered as a different %s", name, existing.kind))
+		}
+		return existing
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  append([]string(nil), labels...),
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*series),
+	}
+	r.metrics[name] = f
+
+	return f
+}
+
+// Get the series for the label values, creating it on first use
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// Add to the value of a counter or gauge
+func (s *series) add(delta float64) {
+	for {
+		old := s.value.Load()
+		if s.value.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// CounterVec is a counter with labels
+type CounterVec struct {
+	family *family
+}
+
+// Counter only goes up, e.g. the number of requests
+type Counter struct {
+	series *series
+}
+
+// Get or create a counter with the label names
+func (r *Registry) Counter(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{family: r.family(name, help, kindCounter, nil, labels)}
+}
+
+// Get the counter for the label values, in the order of the label names
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{series: v.family.with(labelValues)}
+}
+
+// Add one
+func (c *Counter) Inc() {
+	c.series.add(1)
+}
+
+// Add a value, negative values are ignored since counters can't go down
+func (c *Counter) Add(value float64) {
+	if value > 0 {
+		c.series.add(value)
+	}
+}
+
+// GaugeVec is a gauge with labels
+type GaugeVec struct {
+	family *family
+}
+
+// Gauge goes up and down, e.g. the number of requests in flight
+type Gauge struct {
+	series *series
+}
+
+// Get or create a gauge with the label names
+func (r *Registry) Gauge(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{family: r.family(name, help, kindGauge, nil, labels)}
+}
+
+// Get the gauge for the label values, in the order of the label names
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{series: v.family.with(labelValues)}
+}
+
+func (g *Gauge) Set(value float64) {
+	g.series.value.Store(math.Float64bits(value))
+}
+
+func (g *Gauge) Inc() {
+	g.series.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.series.add(-1)
+}
+
+func (g *Gauge) Add(value float64) {
+	g.series.add(value)
+}
+
+// HistogramVec is a histogram with labels
+type HistogramVec struct {
+	family *family
+}
+
+// Histogram counts observations in buckets, e.g. request latencies
+type Histogram struct {
+	family *family
+	series *series
+}
+
+// Get or create a histogram with the bucket upper bounds (DefaultBuckets if nil) and the label names
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if len(buckets) == 0 || !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: %s buckets must be sorted and not empty", name))
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] == buckets[i-1] {
+			panic(fmt.Sprintf("metrics: %s has duplicate bucket %v", name, buckets[i]))
+		}
+	}
+
+	return &HistogramVec{family: r.family(name, help, kindHistogram, buckets, labels)}
+}
+
+// Get the histogram for the label values, in the order of the label names
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return &Histogram{family: v.family, series: v.family.with(labelValues)}
+}
+
+// Add an observation
+func (h *Histogram) Observe(value float64) {
+	bucket := sort.SearchFloat64s(h.family.buckets, value)
+
+	h.series.mu.Lock()
+	defer h.series.mu.Unlock()
+
+	if bucket < len(h.series.counts) {
+		h.series.counts[bucket]++
+	}
+	h.series.sum += value
+	h.series.count++
+}
+
+// Observe the time since the start, in seconds
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Write every metric in the Prometheus text format, sorted by name and label values
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.metrics))
+	for _, f := range r.metrics {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Write the HELP and TYPE lines and the series of a metric
+func (f *family) write(b *strings.Builder) {
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return less(all[i].labelValues, all[j].labelValues) })
+
+	if f.help != "" {
+		fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	}
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+
+	for _, s := range all {
+		if f.kind != kindHistogram {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, f.labelPairs(s.labelValues, ""), formatFloat(math.Float64frombits(s.value.Load())))
+			continue
+		}
+
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, count := s.sum, s.count
+		s.mu.Unlock()
+
+		// Buckets are cumulative in the text format
+		cumulative := uint64(0)
+		for i, bound := range f.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, f.labelPairs(s.labelValues, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, f.labelPairs(s.labelValues, "+Inf"), count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", f.name, f.labelPairs(s.labelValues, ""), formatFloat(sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", f.name, f.labelPairs(s.labelValues, ""), count)
+	}
+}
+
+// Format the labels of a series, with the le label of a histogram bucket if not empty
+func (f *family) labelPairs(labelValues []string, le string) string {
+	if len(f.labels) == 0 && le == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(f.labels)+1)
+	for i, label := range f.labels {
+		pairs = append(pairs, label+`="`+escapeLabelValue(labelValues[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// Compare label values in order
+func less(a []string, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+
+	return len(a) < len(b)
+}
+
+func equal[T comparable](a []T, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,110 @@
+package metrics_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/perocha/goadapters/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, registry *metrics.Registry) string {
+	var b strings.Builder
+	require.NoError(t, registry.Write(&b))
+	return b.String()
+}
+
+func TestRegistry_TextFormat(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	requests := registry.Counter("requests_total", "Requests handled", "method", "status")
+	requests.With("POST", "201").Add(2)
+	requests.With("GET", "200").Inc()
+	requests.With("GET", "200").Add(-5)
+
+	inFlight := registry.Gauge("in_flight", "Requests in flight")
+	inFlight.With().Inc()
+	inFlight.With().Inc()
+	inFlight.With().Dec()
+
+	latency := registry.Histogram("latency_seconds", "Latency", []float64{0.1, 1}, "endpoint")
+	latency.With("/orders").Observe(0.05)
+	latency.With("/orders").Observe(0.1)
+	latency.With("/orders").Observe(3)
+
+	expected := `# HELP in_flight Requests in flight
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{endpoint="/orders",le="0.1"} 2
+latency_seconds_bucket{endpoint="/orders",le="1"} 2
+latency_seconds_bucket{endpoint="/orders",le="+Inf"} 3
+latency_seconds_sum{endpoint="/orders"} 3.15
+latency_seconds_count{endpoint="/orders"} 3
+# HELP requests_total Requests handled
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 1
+requests_total{method="POST",status="201"} 2
+`
+	assert.Equal(t, expected, scrape(t, registry))
+}
+
+func TestRegistry_Escaping(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.Gauge("temperature", "Line one\nback\\slash", "room").With(`the "big" one` + "\n").Set(-1.5)
+
+	assert.Equal(t, "# HELP temperature Line one\\nback\\\\slash\n# TYPE temperature gauge\ntemperature{room=\"the \\\"big\\\" one\\n\"} -1.5\n", scrape(t, registry))
+}
+
+func TestRegistry_GetExisting(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	// Adapters created more than once share their metrics
+	registry.Counter("published_total", "Published", "hub").With("orders").Inc()
+	registry.Counter("published_total", "Published", "hub").With("orders").Inc()
+	assert.Contains(t, scrape(t, registry), `published_total{hub="orders"} 2`)
+
+	assert.Panics(t, func() { registry.Gauge("published_total", "Published", "hub") })
+	assert.Panics(t, func() { registry.Counter("published_total", "Published", "topic") })
+	assert.Panics(t, func() { registry.Counter("published_total", "Published", "hub").With("orders", "extra") })
+}
+
+func TestRegistry_InvalidNames(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	assert.Panics(t, func() { registry.Counter("1requests", "") })
+	assert.Panics(t, func() { registry.Counter("requests-total", "") })
+	assert.Panics(t, func() { registry.Counter("requests_total", "", "__reserved") })
+	assert.Panics(t, func() { registry.Histogram("latency", "", nil, "le") })
+	assert.Panics(t, func() { registry.Histogram("latency", "", []float64{1, 0.5}) })
+	assert.Panics(t, func() { registry.Histogram("latency", "", []float64{}) })
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := registry.Counter("events_total", "", "partition")
+	histogram := registry.Histogram("lag_seconds", "", nil, "partition")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				counter.With("0").Inc()
+				histogram.With("0").Observe(0.2)
+				_ = registry.Write(&strings.Builder{})
+			}
+		}()
+	}
+	wg.Wait()
+
+	output := scrape(t, registry)
+	assert.Contains(t, output, `events_total{partition="0"} 8000`)
+	assert.Contains(t, output, `lag_seconds_count{partition="0"} 8000`)
+	assert.Contains(t, output, `lag_seconds_bucket{partition="0",le="0.25"} 8000`)
+	assert.Contains(t, output, `lag_seconds_bucket{partition="0",le="0.1"} 0`)
+}